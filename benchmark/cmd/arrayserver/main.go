@@ -0,0 +1,29 @@
+// Serve DistribArrays over HTTP (see data.ArrayServer). Arrays are stored in
+// memory by default, or in a directory if -dir is provided.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+)
+
+func main() {
+	addr := flag.String("addr", "localhost:8080", "Address to listen on")
+	dir := flag.String("dir", "", "Store arrays in this directory instead of memory")
+	flag.Parse()
+
+	factory := data.MemArrayFactory
+	if *dir != "" {
+		factory = data.NewFileArrayFactory(*dir)
+	}
+
+	fmt.Printf("Serving arrays on %v\n", *addr)
+	if err := http.ListenAndServe(*addr, data.NewArrayServer(factory)); err != nil {
+		fmt.Printf("Server failed: %v\n", err)
+		os.Exit(1)
+	}
+}
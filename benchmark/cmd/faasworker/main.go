@@ -0,0 +1,42 @@
+// Go version of the FaaS sort worker (faasTest/f.py). Reads a JSON request from
+// stdin and prints a JSON response to stdout (see faasTest/README.md for the
+// protocol). Like f.py, file distributed arrays are looked up in
+// $OL_SHARED_VOLUME.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/faas"
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/sort"
+)
+
+func fail(msg string) {
+	resp, _ := json.Marshal(&faas.FaasResp{Success: false, Err: msg})
+	fmt.Println(string(resp))
+	os.Exit(1)
+}
+
+func main() {
+	sorterName := flag.String("sorter", sort.DefaultLocalSorter().Name(),
+		fmt.Sprintf("Local sort backend to use %v", sort.LocalSorterNames()))
+	flag.Parse()
+
+	dataDir := os.Getenv("OL_SHARED_VOLUME")
+	if dataDir == "" {
+		fail("OL_SHARED_VOLUME not set, set it to the shared directory for distrib arrays")
+	}
+
+	sorter, err := sort.GetLocalSorter(*sorterName)
+	if err != nil {
+		fail(err.Error())
+	}
+
+	resp := faas.ServeFaasRequest(os.Stdin, os.Stdout, dataDir, sorter)
+	if !resp.Success {
+		os.Exit(1)
+	}
+}
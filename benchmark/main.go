@@ -19,7 +19,7 @@ func main() {
 
 	stats, err := benchmark.RunBenchmarks()
 	if err != nil {
-		fmt.Println("Benchmark failed: %v\n", err)
+		fmt.Printf("Benchmark failed: %v\n", err)
 		os.Exit(1)
 	}
 
@@ -1,6 +1,7 @@
 package benchmark
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"os"
@@ -12,7 +13,7 @@ import (
 	"github.com/pkg/errors"
 )
 
-func BenchMemLocalDistrib(arr []byte, stats SortStats) error {
+func BenchMemLocalDistrib(arr []byte, sorter sort.LocalSorter, stats SortStats) error {
 	var err error
 	var ok bool
 
@@ -23,7 +24,7 @@ func BenchMemLocalDistrib(arr []byte, stats SortStats) error {
 	}
 
 	TTotal.Start()
-	_, err = sort.SortDistribFromRaw(arr, "BenchMemLocalDistrib", data.MemArrayFactory, sort.LocalDistribWorker)
+	_, err = sort.SortDistribFromRaw(context.Background(), arr, "BenchMemLocalDistrib", data.MemArrayFactory, sort.NewLocalDistribWorker(sorter), nil)
 	TTotal.Record()
 
 	if err != nil {
@@ -33,7 +34,7 @@ func BenchMemLocalDistrib(arr []byte, stats SortStats) error {
 	return nil
 }
 
-func BenchFileLocalDistrib(arr []byte, stats SortStats) error {
+func BenchFileLocalDistrib(arr []byte, sorter sort.LocalSorter, stats SortStats) error {
 	var ok bool
 
 	var TTotal *PerfTimer
@@ -49,7 +50,7 @@ func BenchFileLocalDistrib(arr []byte, stats SortStats) error {
 	defer os.RemoveAll(tmpDir)
 
 	TTotal.Start()
-	_, err = sort.SortDistribFromRaw(arr, "benchLocalDistrib", data.NewFileArrayFactory(tmpDir), sort.LocalDistribWorker)
+	_, err = sort.SortDistribFromRaw(context.Background(), arr, "benchLocalDistrib", data.NewFileArrayFactory(tmpDir), sort.NewLocalDistribWorker(sorter), nil)
 	TTotal.Record()
 
 	if err != nil {
@@ -59,7 +60,7 @@ func BenchFileLocalDistrib(arr []byte, stats SortStats) error {
 	return nil
 }
 
-func BenchFaasOne(arr []byte, stats SortStats) error {
+func BenchFaasOne(arr []byte, opts *sort.SortOptions, stats SortStats) error {
 	var ok bool
 
 	var TTotal *PerfTimer
@@ -77,15 +78,21 @@ func BenchFaasOne(arr []byte, stats SortStats) error {
 	//Configure SRK
 	//OL will mount tmpDir to the FaaS worker so it can find the distributed arrays
 	os.Setenv("OL_SHARED_VOLUME", tmpDir)
-	fmt.Println("Getting SRK manager")
-	mgr := faas.GetMgr()
-	defer mgr.Destroy()
+	invokerCfg := faas.InvokerConfigFromEnv()
+	invokerCfg.ArrDir = tmpDir
+
+	fmt.Printf("Getting %v invoker\n", invokerCfg.Type)
+	invoker, err := faas.NewInvoker(invokerCfg)
+	if err != nil {
+		return errors.Wrap(err, "Failed to initialize FaaS invoker")
+	}
+	defer invoker.Close()
 
 	arrFactory := data.NewFileArrayFactory(tmpDir)
-	worker := faas.InitFaasWorker(mgr)
+	worker := faas.InitFaasWorker(invoker)
 
 	TTotal.Start()
-	_, err = sort.SortDistribFromRaw(arr, "benchLocalDistrib", arrFactory, worker)
+	_, err = sort.SortDistribFromRaw(context.Background(), arr, "benchLocalDistrib", arrFactory, worker, opts)
 	TTotal.Record()
 
 	if err != nil {
@@ -95,7 +102,7 @@ func BenchFaasOne(arr []byte, stats SortStats) error {
 	return nil
 }
 
-func BenchFaasAll(origRaw []byte, name string) (SortStats, error) {
+func BenchFaasAll(origRaw []byte, name string, opts *sort.SortOptions) (SortStats, error) {
 	var err error
 	const nrepeat = 5
 
@@ -106,7 +113,7 @@ func BenchFaasAll(origRaw []byte, name string) (SortStats, error) {
 	// Timed runs
 	for i := 0; i < nrepeat; i++ {
 		copy(iterIn, origRaw)
-		err = BenchFaasOne(iterIn, stats)
+		err = BenchFaasOne(iterIn, opts, stats)
 		if err != nil {
 			return stats, errors.Wrap(err, "Failed to benchmark FaaS")
 		}
@@ -139,7 +146,7 @@ func RunBenchmarks() (map[string]SortStats, error) {
 	// stats["MemLocalDistrib"] = make(SortStats)
 	// for i := 0; i < nrepeat; i++ {
 	// 	copy(iterIn, origRaw)
-	// 	err = BenchMemLocalDistrib(iterIn, stats["MemLocalDistrib"])
+	// 	err = BenchMemLocalDistrib(iterIn, sort.DefaultLocalSorter(), stats["MemLocalDistrib"])
 	// 	if err != nil {
 	// 		return stats, errors.Wrap(err, "Failed to benchmark MemLocalDistrib")
 	// 	}
@@ -149,7 +156,7 @@ func RunBenchmarks() (map[string]SortStats, error) {
 	// stats["FileLocalDistrib"] = make(SortStats)
 	// for i := 0; i < nrepeat; i++ {
 	// 	copy(iterIn, origRaw)
-	// 	err = BenchFileLocalDistrib(iterIn, stats["FileLocalDistrib"])
+	// 	err = BenchFileLocalDistrib(iterIn, sort.DefaultLocalSorter(), stats["FileLocalDistrib"])
 	// 	if err != nil {
 	// 		return stats, errors.Wrap(err, "Failed to benchmark FileLocalDistrib")
 	// 	}
@@ -162,15 +169,17 @@ func RunBenchmarks() (map[string]SortStats, error) {
 		return stats, errors.Wrapf(err, "Error creating profiling results directory")
 	}
 
-	sort.SetWidth(8)
-	runStats, err := BenchFaasAll(origRaw, "8b")
+	opts := sort.DefaultSortOptions()
+	opts.Width = 8
+	runStats, err := BenchFaasAll(origRaw, "8b", opts)
 	if err != nil {
 		return stats, err
 	}
 	stats["FaaS8"] = runStats
 
-	sort.SetWidth(16)
-	runStats, err = BenchFaasAll(origRaw, "16b")
+	opts = sort.DefaultSortOptions()
+	opts.Width = 16
+	runStats, err = BenchFaasAll(origRaw, "16b", opts)
 	if err != nil {
 		return stats, err
 	}
@@ -1,9 +1,9 @@
 package benchmark
 
 import (
+	"context"
 	"io/ioutil"
 	"os"
-	"path/filepath"
 	"runtime"
 	"testing"
 
@@ -12,17 +12,10 @@ import (
 )
 
 func BenchmarkFileDistribLocal(b *testing.B) {
-	var err error
-
-	err = sort.InitLibSort()
-	if err != nil {
-		b.Fatalf("Failed to initialize libsort: %v", err)
-	}
-
 	// Should be an odd (in both senses) number to pick up unaligned corner
 	// cases
 	// nElem := 1111
-	// nElem := (1024 * 1024) + 5
+	nElem := (1024 * 1024) + 5
 	// XXX need to think hard about doing this big of an experiment. We're
 	// talking hundreds of thousands of files and 10s of GB of data. The local
 	// filesystem is probably inadequate.
@@ -32,37 +25,43 @@ func BenchmarkFileDistribLocal(b *testing.B) {
 		b.Fatalf("Failed to generate inputs: %v", err)
 	}
 
-	iterIn := make([]byte, len(origRaw))
-	b.ResetTimer()
-	for i := 0; i < b.N; i++ {
-		// anonymous function for defer behavior
-		func() {
-			b.StopTimer()
-			copy(iterIn, origRaw)
-			tmpDir, err := ioutil.TempDir("", "radixSortLocalTest*")
-			if err != nil {
-				b.Fatalf("Couldn't create temporary test directory %v", err)
-			}
-
-			defer os.RemoveAll(tmpDir)
-			b.StartTimer()
-
-			_, err = sort.SortDistribFromRaw(iterIn, func(name string, nbucket int) (data.DistribArray, error) {
-				var arr data.DistribArray
-				arr, err := data.NewFileDistribArray(filepath.Join(tmpDir, name), nbucket)
-				return arr, err
-			}, sort.LocalDistribWorker)
+	for _, sorterName := range sort.LocalSorterNames() {
+		sorter, err := sort.GetLocalSorter(sorterName)
+		if err != nil {
+			b.Fatalf("Failed to get local sorter %v: %v", sorterName, err)
+		}
 
-			if err != nil {
-				b.Fatalf("Sort failed: %v", err)
+		b.Run(sorterName, func(b *testing.B) {
+			worker := sort.NewLocalDistribWorker(sorter)
+
+			iterIn := make([]byte, len(origRaw))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// anonymous function for defer behavior
+				func() {
+					b.StopTimer()
+					copy(iterIn, origRaw)
+					tmpDir, err := ioutil.TempDir("", "radixSortLocalTest*")
+					if err != nil {
+						b.Fatalf("Couldn't create temporary test directory %v", err)
+					}
+
+					defer os.RemoveAll(tmpDir)
+					b.StartTimer()
+
+					_, err = sort.SortDistribFromRaw(context.Background(), iterIn, "BenchmarkFileDistribLocal",
+						data.NewFileArrayFactory(tmpDir), worker, nil)
+
+					if err != nil {
+						b.Fatalf("Sort failed: %v", err)
+					}
+				}()
 			}
-		}()
+		})
 	}
 }
 
 func BenchmarkMemDistribLocal(b *testing.B) {
-	var err error
-
 	nElem := nmax_per_dev * ndev
 	// nElem := 1024 * 1024 * 4
 
@@ -70,27 +69,35 @@ func BenchmarkMemDistribLocal(b *testing.B) {
 	if err != nil {
 		b.Fatalf("Failed to generate inputs: %v", err)
 	}
-	iterIn := make([]byte, len(origRaw))
 
-	b.ResetTimer()
+	for _, sorterName := range sort.LocalSorterNames() {
+		sorter, err := sort.GetLocalSorter(sorterName)
+		if err != nil {
+			b.Fatalf("Failed to get local sorter %v: %v", sorterName, err)
+		}
 
-	for i := 0; i < b.N; i++ {
-		b.StopTimer()
-		copy(iterIn, origRaw)
+		b.Run(sorterName, func(b *testing.B) {
+			worker := sort.NewLocalDistribWorker(sorter)
+			iterIn := make([]byte, len(origRaw))
 
-		b.StartTimer()
+			b.ResetTimer()
 
-		_, err = sort.SortDistribFromRaw(iterIn, func(name string, nbucket int) (data.DistribArray, error) {
-			var arr data.DistribArray
-			arr, err := data.NewMemDistribArray(nbucket)
-			return arr, err
-		}, sort.LocalDistribWorker)
-		if err != nil {
-			b.Fatalf("Sort Failed: %v", err)
-		}
+			for i := 0; i < b.N; i++ {
+				b.StopTimer()
+				copy(iterIn, origRaw)
+
+				b.StartTimer()
 
-		b.StopTimer()
+				_, err = sort.SortDistribFromRaw(context.Background(), iterIn, "BenchmarkMemDistribLocal",
+					data.MemArrayFactory, worker, nil)
+				if err != nil {
+					b.Fatalf("Sort Failed: %v", err)
+				}
 
-		runtime.GC()
+				b.StopTimer()
+
+				runtime.GC()
+			}
+		})
 	}
 }
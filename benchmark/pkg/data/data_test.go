@@ -126,6 +126,38 @@ func testArrayFactory(t *testing.T, fact *ArrayFactory) {
 	openArr, err := fact.Open("testFactory0")
 	require.Nil(t, err, "Failed to open array from factory")
 
+	writer, err := openArr.GetPartWriter(1)
+	require.Nil(t, err, "Failed to get writer")
+	_, err = writer.Write([]byte("readonlyreadonly"))
+	require.Nil(t, err, "Failed to write")
+	writer.Close()
+	require.Nil(t, openArr.Close(), "Failed to close array")
+
+	// Any number of read-only handles can coexist
+	roArrs := make([]DistribArray, 2)
+	for i := range roArrs {
+		roArrs[i], err = fact.OpenReadOnly("testFactory0")
+		require.Nil(t, err, "Failed to open array read-only")
+	}
+	for _, roArr := range roArrs {
+		shape, err := roArr.GetShape()
+		require.Nil(t, err, "Failed to get shape")
+		require.Equal(t, (int64)(16), shape.Len(1), "Read-only array has wrong shape")
+
+		retBytes := make([]byte, 16)
+		reader, err := roArr.GetPartReader(1)
+		require.Nil(t, err, "Failed to get reader")
+		readPart(t, reader, retBytes)
+		require.Equal(t, []byte("readonlyreadonly"), retBytes)
+
+		_, err = roArr.GetPartWriter(0)
+		require.NotNil(t, err, "Read-only array returned a writer")
+		require.NotNil(t, roArr.Destroy(), "Destroyed a read-only array")
+		require.Nil(t, roArr.Close(), "Failed to close read-only array")
+	}
+
+	openArr, err = fact.Open("testFactory0")
+	require.Nil(t, err, "Read-only handles damaged the array")
 	openArr.Destroy()
 }
 
@@ -233,4 +265,43 @@ func testDistribArr(t *testing.T, factory *ArrayFactory) {
 		require.Equal(t, 3, shape.NPart(), "New array has old shape")
 		require.Equal(t, (int64)(0), shape.Len(0), "New array has non-empty partitions")
 	})
+	// Zero-capacity partitions grow as needed
+	t.Run("Unlimited", func(t *testing.T) {
+		arr, err := factory.Create("Unlimited", CreateShape([]int64{0, 8, 0}))
+		require.Nilf(t, err, "Failed to initialize array")
+		defer arr.Destroy()
+
+		parts := [][]byte{bytes.Repeat([]byte{1}, 1000), []byte("12345678"), []byte{}}
+		for partId, raw := range parts {
+			writer, err := arr.GetPartWriter(partId)
+			require.Nil(t, err, "Failed to get writer")
+			// Several writes per partition
+			for start := 0; start < len(raw); start += 300 {
+				end := start + 300
+				if end > len(raw) {
+					end = len(raw)
+				}
+				n, err := writer.Write(raw[start:end])
+				require.Nilf(t, err, "Write to partition %v failed", partId)
+				require.Equal(t, end-start, n)
+			}
+			require.Nil(t, writer.Close(), "Failed to close writer")
+		}
+		require.Nil(t, arr.Close(), "Failed to commit array")
+
+		arr, err = factory.Open("Unlimited")
+		require.Nil(t, err, "Failed to reopen array")
+		shape, err := arr.GetShape()
+		require.Nil(t, err, "Failed to get shape")
+		for partId, raw := range parts {
+			require.Equalf(t, (int64)(len(raw)), shape.Len(partId), "Partition %v has the wrong length", partId)
+
+			retBytes := make([]byte, len(raw))
+			reader, err := arr.GetPartReader(partId)
+			require.Nil(t, err, "Failed to get reader")
+			readPart(t, reader, retBytes)
+			reader.Close()
+			require.Equalf(t, raw, retBytes, "Partition %v has the wrong data", partId)
+		}
+	})
 }
@@ -7,6 +7,7 @@ import (
 	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sync"
 
 	"github.com/pkg/errors"
 )
@@ -15,14 +16,121 @@ import (
 // if needed. This is required because we don't want to export the fields of
 // DistribArrayShape but JSON can't handle unexported fields.
 type fileShape struct {
+	// Identifies the metadata format, both are omitted by older writers
+	// (including pylibsort) and by other array types
+	Magic   string `json:",omitempty"`
+	Version int    `json:",omitempty"`
+
 	Lens []int64
 	Caps []int64
+
+	// How the data is laid out on disk (FileDistribArray only). Omitted for
+	// the default single-file layout.
+	Layout FileLayout `json:",omitempty"`
+
+	// Where the data for each unlimited (zero capacity) partition lives in
+	// the data file (FileDistribArray only). Omitted if there are no
+	// unlimited partitions.
+	Extents [][]fileExtent `json:",omitempty"`
+}
+
+// A contiguous byte range of a FileDistribArray's data file
+type fileExtent struct {
+	Off int64
+	Len int64
+}
+
+// Current FileDistribArray metadata format (see fileShape). Version 2 adds
+// Extents and version 3 adds Layout. Arrays are written with the oldest
+// version that can describe them (usually 1).
+const (
+	fileArrayMagic   = "radixsort-filearray"
+	fileArrayVersion = 3
+)
+
+// How a FileDistribArray stores its partitions on disk
+type FileLayout string
+
+const (
+	// All partitions share a single data file (data.dat), this is the
+	// default
+	SingleFileLayout FileLayout = ""
+
+	// Every partition has its own file (p<partID>.dat). Partitions can be
+	// grown, deleted or copied independently.
+	PartitionFileLayout FileLayout = "partition"
+)
+
+// Options for new file arrays. Existing arrays are always opened with the
+// layout they were created with.
+type FileArrayOptions struct {
+	Layout FileLayout
+}
+
+// Returned (wrapped) by OpenFileDistribArray for arrays that were torn by a
+// crash or are otherwise inconsistent. Use errors.Cause() to check for it.
+var ErrCorruptArray = errors.New("Corrupt distributed array")
+
+// Check that a loaded shape is self-consistent
+func (self *fileShape) validate() error {
+	if self.Magic != "" && self.Magic != fileArrayMagic {
+		return errors.Wrapf(ErrCorruptArray, "Unrecognized metadata magic %q", self.Magic)
+	}
+	if self.Version > fileArrayVersion {
+		return fmt.Errorf("Unsupported metadata version %v (newest supported is %v)", self.Version, fileArrayVersion)
+	}
+
+	if self.Layout != SingleFileLayout && self.Layout != PartitionFileLayout {
+		return errors.Wrapf(ErrCorruptArray, "Unrecognized layout %q", self.Layout)
+	}
+	if self.Layout == PartitionFileLayout && self.Extents != nil {
+		return errors.Wrap(ErrCorruptArray, "Partition files can't have extents")
+	}
+
+	if len(self.Lens) != len(self.Caps) {
+		return errors.Wrapf(ErrCorruptArray, "Metadata has %v lens but %v caps", len(self.Lens), len(self.Caps))
+	}
+	if self.Extents != nil && len(self.Extents) != len(self.Lens) {
+		return errors.Wrapf(ErrCorruptArray, "Metadata has %v partitions but %v extent lists", len(self.Lens), len(self.Extents))
+	}
+
+	for i := range self.Lens {
+		if self.Caps[i] < 0 || self.Lens[i] < 0 || (self.Caps[i] != 0 && self.Lens[i] > self.Caps[i]) {
+			return errors.Wrapf(ErrCorruptArray, "Partition %v has length %v but capacity %v", i, self.Lens[i], self.Caps[i])
+		}
+
+		// Fixed-size partitions live at their start offset, only unlimited
+		// ones have extents (partition files never do)
+		extLen := (int64)(0)
+		if self.Extents != nil {
+			if self.Caps[i] != 0 && len(self.Extents[i]) != 0 {
+				return errors.Wrapf(ErrCorruptArray, "Fixed-size partition %v has extents", i)
+			}
+			for _, ext := range self.Extents[i] {
+				if ext.Off < 0 || ext.Len <= 0 {
+					return errors.Wrapf(ErrCorruptArray, "Partition %v has an invalid extent %+v", i, ext)
+				}
+				extLen += ext.Len
+			}
+		}
+		if self.Layout == SingleFileLayout && self.Caps[i] == 0 && extLen != self.Lens[i] {
+			return errors.Wrapf(ErrCorruptArray, "Partition %v has length %v but %v bytes of extents", i, self.Lens[i], extLen)
+		}
+	}
+	return nil
 }
 
-func NewFileArrayFactory(rootDir string) *ArrayFactory {
+// Arrays are stored in directories under rootDir. New arrays use the layout
+// in opts (the default is SingleFileLayout), at most one may be passed.
+func NewFileArrayFactory(rootDir string, opts ...FileArrayOptions) *ArrayFactory {
+	var layout FileLayout
+	for _, opt := range opts {
+		layout = opt.Layout
+	}
+
 	return &ArrayFactory{
 		Create: func(name string, shape DistribArrayShape) (DistribArray, error) {
-			a, err := CreateFileDistribArray(filepath.Join(rootDir, name), shape)
+			a, err := CreateFileDistribArrayLayout(filepath.Join(rootDir, name), shape, layout)
 			return (DistribArray)(a), err
 		},
 
@@ -30,6 +138,11 @@ func NewFileArrayFactory(rootDir string) *ArrayFactory {
 			a, err := OpenFileDistribArray(filepath.Join(rootDir, name))
 			return (DistribArray)(a), err
 		},
+
+		OpenReadOnly: func(name string) (DistribArray, error) {
+			a, err := OpenFileDistribArrayReadOnly(filepath.Join(rootDir, name))
+			return (DistribArray)(a), err
+		},
 	}
 }
 
@@ -39,23 +152,59 @@ func NewFileArrayFactory(rootDir string) *ArrayFactory {
 //			(file size can be used to dermine the number of partitions)
 //		data.dat: Stores the actual data, each partition starts at offset
 //			starts[partID] in the file.
+// Arrays created with PartitionFileLayout have no data.dat, partition i is
+// stored at the start of its own file (p<i>.dat) instead.
+// Commits are crash-safe: the data is synced first, then the new metadata is
+// written to meta.json.tmp, synced and renamed over meta.json. A crash leaves
+// either the old or the new metadata (a stale meta.json.tmp is ignored).
+// Writers for different partitions may be used concurrently (writes to the
+// same partition are serialized), but not concurrently with Close().
+//
+// Partitions with a capacity of zero are unlimited. They have no fixed place in
+// data.dat, instead writes are appended to the end of the file and each
+// partition keeps a list of the extents holding its data (consecutive writes
+// to the same partition extend a single extent). Partition files simply grow.
 type FileDistribArray struct {
 	RootPath string
 	fd       *os.File
 
+	layout FileLayout
+
+	// Partition files for PartitionFileLayout, opened by the first writer
+	// (and protected by partLocks)
+	partFds []*os.File
+
 	// like len and cap for slices for each partition
 	shape DistribArrayShape
 
 	// Optimization/convenience stores the starting point of each partition in
-	// the file
+	// the file (unlimited partitions use extents instead)
 	starts []int64
+
+	// Data extents of each unlimited partition (nil for fixed partitions)
+	extents [][]fileExtent
+
+	// End of the data file, new extents are allocated here
+	end int64
+
+	// Opened with OpenFileDistribArrayReadOnly, nothing may be written
+	readOnly bool
+
+	// True if the shape has changed since the last commit
+	dirty bool
+
+	// Writers hold partLocks[partId] for the whole write so that appends to
+	// a partition don't interleave. shapeLock protects shape.lens, extents,
+	// end and dirty.
+	partLocks []sync.Mutex
+	shapeLock sync.Mutex
 }
 
 type FileDistribRangeReader struct {
 	file *os.File
 
-	// The number of bytes still to read before hitting the limit
-	nRemaining int
+	// The parts of the file still to be read, in order
+	segs []fileExtent
 }
 
 type FileDistribWriter struct {
@@ -65,9 +214,20 @@ type FileDistribWriter struct {
 
 // Create a new FileDistribArray object from an existing on-disk array
 func OpenFileDistribArray(rootPath string) (*FileDistribArray, error) {
+	return openFileDistribArray(rootPath, false)
+}
+
+// Like OpenFileDistribArray but the array is never modified (see
+// ArrayFactory.OpenReadOnly). This is the right way to open inputs, any number
+// of processes can read the same array at once.
+func OpenFileDistribArrayReadOnly(rootPath string) (*FileDistribArray, error) {
+	return openFileDistribArray(rootPath, true)
+}
+
+func openFileDistribArray(rootPath string, readOnly bool) (*FileDistribArray, error) {
 	var err error
 
-	arr := &FileDistribArray{}
+	arr := &FileDistribArray{readOnly: readOnly}
 
 	arr.RootPath, err = filepath.Abs(rootPath)
 	if err != nil {
@@ -79,12 +239,24 @@ func OpenFileDistribArray(rootPath string) (*FileDistribArray, error) {
 		return nil, errors.Wrap(err, "Failed to load metadata")
 	}
 
-	dataPath := filepath.Join(arr.RootPath, "data.dat")
-	dataFile, err := os.OpenFile(dataPath, os.O_RDWR, 0600)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to create data file")
+	// Partition files are opened as needed
+	if arr.layout == SingleFileLayout {
+		flags := os.O_RDWR
+		if readOnly {
+			flags = os.O_RDONLY
+		}
+
+		dataPath := filepath.Join(arr.RootPath, "data.dat")
+		arr.fd, err = os.OpenFile(dataPath, flags, 0600)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to open data file")
+		}
+	}
+
+	if err = arr.checkData(); err != nil {
+		arr.closeFiles(false)
+		return nil, err
 	}
-	arr.fd = dataFile
 
 	return arr, nil
 }
@@ -92,9 +264,17 @@ func OpenFileDistribArray(rootPath string) (*FileDistribArray, error) {
 // Create a new file-backed distributed array. caps describes the size of each
 // partition (like capacity in a slice). Partitions cannot be resized.
 func CreateFileDistribArray(rootPath string, shape DistribArrayShape) (*FileDistribArray, error) {
+	return CreateFileDistribArrayLayout(rootPath, shape, SingleFileLayout)
+}
+
+// Like CreateFileDistribArray but with the given on-disk layout
+func CreateFileDistribArrayLayout(rootPath string, shape DistribArrayShape, layout FileLayout) (*FileDistribArray, error) {
 	var err error
 
-	arr := &FileDistribArray{}
+	if layout != SingleFileLayout && layout != PartitionFileLayout {
+		return nil, fmt.Errorf("Unrecognized layout %q", layout)
+	}
+	arr := &FileDistribArray{layout: layout}
 
 	rootPath, err = filepath.Abs(rootPath)
 	if err != nil {
@@ -115,25 +295,36 @@ func CreateFileDistribArray(rootPath string, shape DistribArrayShape) (*FileDist
 	copy(arr.shape.caps, shape.caps)
 	copy(arr.shape.lens, shape.lens)
 
-	arr.starts = make([]int64, len(shape.caps))
-	capSum := (int64)(0)
-	for i := 0; i < len(shape.caps); i++ {
-		arr.starts[i] = capSum
-		capSum += (int64)(shape.caps[i])
+	for i := range shape.caps {
+		if shape.caps[i] == 0 && shape.lens[i] != 0 {
+			return nil, fmt.Errorf("Unlimited partition %v can't start with data", i)
+		}
 	}
+	arr.initLayout(nil)
 
 	//=============================
 	// Backing file
 	//=============================
-	dataPath := filepath.Join(rootPath, "data.dat")
-
-	// Go's create() doesn't allow you to set permissions so we have to
-	// open and then immediately close
-	dataFile, err := os.OpenFile(dataPath, os.O_CREATE|os.O_RDWR, 0600)
-	if err != nil {
-		return nil, errors.Wrapf(err, "Failed to create data file")
+	if layout == PartitionFileLayout {
+		// Every partition file exists (possibly empty) from the start
+		for i := range arr.partFds {
+			partFile, err := os.OpenFile(arr.partPath(i), os.O_CREATE|os.O_RDWR, 0600)
+			if err != nil {
+				return nil, errors.Wrapf(err, "Failed to create file for partition %v", i)
+			}
+			partFile.Close()
+		}
+	} else {
+		dataPath := filepath.Join(rootPath, "data.dat")
+
+		// Go's create() doesn't allow you to set permissions so we have to
+		// open and then immediately close
+		dataFile, err := os.OpenFile(dataPath, os.O_CREATE|os.O_RDWR, 0600)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to create data file")
+		}
+		arr.fd = dataFile
 	}
-	arr.fd = dataFile
 
 	err = arr.commitMeta()
 	if err != nil {
@@ -143,38 +334,67 @@ func CreateFileDistribArray(rootPath string, shape DistribArrayShape) (*FileDist
 	return arr, nil
 }
 
+// Atomically replace meta.json with the current shape. Callers must sync the
+// data first.
 func (self *FileDistribArray) commitMeta() error {
-	jsonShape := fileShape{Lens: self.shape.lens, Caps: self.shape.caps}
-
-	metaPath := filepath.Join(self.RootPath, "meta.json")
-	metaFile, err := os.OpenFile(metaPath, os.O_CREATE|os.O_WRONLY, 0600)
-	if err != nil {
-		return errors.Wrapf(err, "Failed to create metdata file")
+	jsonShape := fileShape{Magic: fileArrayMagic, Version: 1, Lens: self.shape.lens, Caps: self.shape.caps}
+	if self.layout == PartitionFileLayout {
+		jsonShape.Version = 3
+		jsonShape.Layout = self.layout
+	} else {
+		for _, partCap := range self.shape.caps {
+			if partCap == 0 {
+				jsonShape.Version = 2
+				jsonShape.Extents = self.extents
+				break
+			}
+		}
 	}
 
 	jsonBytes, err := json.Marshal(jsonShape)
 	if err != nil {
 		return errors.Wrapf(err, "Couldn't convert shape to json")
 	}
+
+	metaPath := filepath.Join(self.RootPath, "meta.json")
+	tmpPath := metaPath + ".tmp"
+	metaFile, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to create metdata file")
+	}
+
 	_, err = metaFile.Write(jsonBytes)
+	if err == nil {
+		err = metaFile.Sync()
+	}
+	if closeErr := metaFile.Close(); err == nil {
+		err = closeErr
+	}
 	if err != nil {
+		os.Remove(tmpPath)
 		return errors.Wrap(err, "Error while writing metadata")
 	}
 
-	if err := metaFile.Close(); err != nil {
-		return errors.Wrapf(err, "Failed to close metadata file")
+	if err = os.Rename(tmpPath, metaPath); err != nil {
+		return errors.Wrap(err, "Failed to commit metadata")
+	}
+	self.dirty = false
+
+	// The rename itself isn't durable until the directory is synced
+	dir, err := os.Open(self.RootPath)
+	if err != nil {
+		return errors.Wrap(err, "Failed to open array directory")
+	}
+	defer dir.Close()
+	if err = dir.Sync(); err != nil {
+		return errors.Wrap(err, "Failed to sync array directory")
 	}
 	return nil
 }
 
 func (self *FileDistribArray) loadMeta() error {
 	metaPath := filepath.Join(self.RootPath, "meta.json")
-	metaFile, err := os.OpenFile(metaPath, os.O_CREATE, 0600)
-	if err != nil {
-		return errors.Wrap(err, "Failed to create metdata file")
-	}
-
-	metaBytes, err := ioutil.ReadAll(metaFile)
+	metaBytes, err := ioutil.ReadFile(metaPath)
 	if err != nil {
 		return errors.Wrap(err, "Failed to read metadata")
 	}
@@ -182,52 +402,170 @@ func (self *FileDistribArray) loadMeta() error {
 	var jsonShape fileShape
 	err = json.Unmarshal(metaBytes, &jsonShape)
 	if err != nil {
-		return errors.Wrap(err, "Failed to interpret metadata")
+		return errors.Wrapf(ErrCorruptArray, "Failed to interpret metadata (%v)", err)
+	}
+	if err = jsonShape.validate(); err != nil {
+		return err
 	}
 
 	self.shape.lens = jsonShape.Lens
 	self.shape.caps = jsonShape.Caps
+	self.layout = jsonShape.Layout
+	self.initLayout(jsonShape.Extents)
+	return nil
+}
 
-	if err := metaFile.Close(); err != nil {
-		return errors.Wrapf(err, "Failed to close metadata file")
+// Work out where each partition lives from the shape and any existing extents
+func (self *FileDistribArray) initLayout(extents [][]fileExtent) {
+	nPart := len(self.shape.caps)
+	self.partLocks = make([]sync.Mutex, nPart)
+	self.starts = make([]int64, nPart)
+	self.extents = make([][]fileExtent, nPart)
+
+	// Partition files start at zero
+	if self.layout == PartitionFileLayout {
+		self.partFds = make([]*os.File, nPart)
+		return
 	}
 
-	self.starts = make([]int64, len(self.shape.lens))
 	cumCap := (int64)(0)
-	for i := 0; i < len(self.shape.lens); i++ {
+	for i := 0; i < nPart; i++ {
 		self.starts[i] = cumCap
 		cumCap += (int64)(self.shape.caps[i])
 	}
+
+	self.end = cumCap
+	for i := range extents {
+		self.extents[i] = extents[i]
+		for _, ext := range extents[i] {
+			if ext.Off+ext.Len > self.end {
+				self.end = ext.Off + ext.Len
+			}
+		}
+	}
+}
+
+// Path of the file holding partition partId (PartitionFileLayout only)
+func (self *FileDistribArray) partPath(partId int) string {
+	return filepath.Join(self.RootPath, fmt.Sprintf("p%v.dat", partId))
+}
+
+// Check that the data file holds everything the metadata says was written
+func (self *FileDistribArray) checkData() error {
+	if self.layout == PartitionFileLayout {
+		for i, partLen := range self.shape.lens {
+			info, err := os.Stat(self.partPath(i))
+			if err != nil {
+				return errors.Wrapf(ErrCorruptArray, "Couldn't stat file for partition %v (%v)", i, err)
+			}
+			if info.Size() < partLen {
+				return errors.Wrapf(ErrCorruptArray, "Partition %v has %v bytes, needs %v", i, info.Size(), partLen)
+			}
+		}
+		return nil
+	}
+
+	info, err := self.fd.Stat()
+	if err != nil {
+		return errors.Wrap(err, "Couldn't stat data file")
+	}
+
+	for i := range self.shape.lens {
+		if end := self.starts[i] + self.shape.lens[i]; self.shape.caps[i] != 0 && self.shape.lens[i] != 0 && end > info.Size() {
+			return errors.Wrapf(ErrCorruptArray, "Data file has %v bytes, partition %v needs %v", info.Size(), i, end)
+		}
+		for _, ext := range self.extents[i] {
+			if ext.Off+ext.Len > info.Size() {
+				return errors.Wrapf(ErrCorruptArray, "Data file has %v bytes, partition %v needs %v", info.Size(), i, ext.Off+ext.Len)
+			}
+		}
+	}
 	return nil
 }
 
 func (self *FileDistribArray) GetShape() (*DistribArrayShape, error) {
-	// Copy the slices but not their underlying array (DistribArrayShape is immutable)
-	return &DistribArrayShape{lens: self.shape.lens, caps: self.shape.caps}, nil
+	// Caps never change but lens may be updated by concurrent writers so we
+	// return a snapshot (DistribArrayShape is immutable)
+	self.shapeLock.Lock()
+	defer self.shapeLock.Unlock()
+
+	lens := make([]int64, len(self.shape.lens))
+	copy(lens, self.shape.lens)
+	return &DistribArrayShape{lens: lens, caps: self.shape.caps}, nil
+}
+
+// Current length of partition partId
+func (self *FileDistribArray) partLen(partId int) int64 {
+	self.shapeLock.Lock()
+	defer self.shapeLock.Unlock()
+	return self.shape.lens[partId]
+}
+
+// Returns the parts of the data file holding bytes [start, end) of partition
+// partId (end <= 0 is relative to the partition length)
+func (self *FileDistribArray) rangeExtents(partId int, start int64, end int64) ([]fileExtent, error) {
+	self.shapeLock.Lock()
+	defer self.shapeLock.Unlock()
+
+	if end <= 0 {
+		end += self.shape.lens[partId]
+	}
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("Invalid range [%v, %v) for partition %v", start, end, partId)
+	}
+
+	if self.layout == PartitionFileLayout {
+		if self.shape.caps[partId] == 0 && end > self.shape.lens[partId] {
+			return nil, fmt.Errorf("Range [%v, %v) is past the end of partition %v (%v bytes)",
+				start, end, partId, self.shape.lens[partId])
+		}
+		return []fileExtent{{Off: start, Len: end - start}}, nil
+	}
+
+	if self.shape.caps[partId] != 0 {
+		return []fileExtent{{Off: self.starts[partId] + start, Len: end - start}}, nil
+	}
+
+	var segs []fileExtent
+	pos := (int64)(0)
+	for _, ext := range self.extents[partId] {
+		lo, hi := pos, pos+ext.Len
+		if lo < start {
+			lo = start
+		}
+		if hi > end {
+			hi = end
+		}
+		if lo < hi {
+			segs = append(segs, fileExtent{Off: ext.Off + lo - pos, Len: hi - lo})
+		}
+		pos += ext.Len
+	}
+	if end > pos {
+		return nil, fmt.Errorf("Range [%v, %v) is past the end of partition %v (%v bytes)", start, end, partId, pos)
+	}
+	return segs, nil
 }
 
 func (self *FileDistribArray) GetPartRangeReader(partId, start, end int) (io.ReadCloser, error) {
 	var err error
 
 	reader := FileDistribRangeReader{}
-
-	// Re-open file to get thread-safe readers
-	reader.file, err = os.Open(filepath.Join(self.RootPath, "data.dat"))
+	reader.segs, err = self.rangeExtents(partId, (int64)(start), (int64)(end))
 	if err != nil {
 		return nil, err
 	}
 
-	_, err = reader.file.Seek(self.starts[partId]+(int64)(start), 0)
+	// Re-open file to get thread-safe readers
+	path := filepath.Join(self.RootPath, "data.dat")
+	if self.layout == PartitionFileLayout {
+		path = self.partPath(partId)
+	}
+	reader.file, err = os.Open(path)
 	if err != nil {
 		return nil, err
 	}
 
-	if end <= 0 {
-		reader.nRemaining = (int)(self.shape.lens[partId] + (int64)(end) - (int64)(start))
-	} else {
-		reader.nRemaining = end - start
-	}
-
 	return &reader, nil
 }
 
@@ -235,11 +573,22 @@ func (self *FileDistribArray) GetPartReader(partId int) (io.ReadCloser, error) {
 	return self.GetPartRangeReader(partId, 0, 0)
 }
 
+// Commits any changes. Arrays that weren't written to (including all
+// read-only arrays) leave meta.json alone.
 func (self *FileDistribArray) Close() error {
-	// var eMsg string
+	if self.readOnly || !self.dirty {
+		return self.closeFiles(false)
+	}
 
-	closeErr := self.fd.Close()
-	metaErr := self.commitMeta()
+	// Data must be durable before the metadata that points to it
+	closeErr := self.closeFiles(true)
+
+	var metaErr error
+	if closeErr == nil {
+		metaErr = self.commitMeta()
+	} else {
+		metaErr = fmt.Errorf("not committed")
+	}
 
 	if closeErr != nil || metaErr != nil {
 		return fmt.Errorf("Array commit failure (data may be corrupted): metadata: %v, data: %v", metaErr, closeErr)
@@ -248,7 +597,45 @@ func (self *FileDistribArray) Close() error {
 	return nil
 }
 
+// Close the data file and any open partition files (syncing them first if
+// sync is set). Returns the first error.
+func (self *FileDistribArray) closeFiles(sync bool) error {
+	fds := self.partFds
+	if self.fd != nil {
+		fds = append([]*os.File{self.fd}, fds...)
+	}
+
+	var firstErr error
+	for _, fd := range fds {
+		if fd == nil {
+			continue
+		}
+
+		var err error
+		if sync {
+			err = fd.Sync()
+		}
+		if closeErr := fd.Close(); err == nil {
+			err = closeErr
+		}
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	// Closing again (e.g. Destroy() after Close()) is a no-op
+	self.fd = nil
+	for i := range self.partFds {
+		self.partFds[i] = nil
+	}
+	return firstErr
+}
+
 func (self *FileDistribArray) Destroy() error {
+	if self.readOnly {
+		return fmt.Errorf("Can't destroy a read-only array")
+	}
+
 	// It really doesn't matter if there is an error on closing. We might eat
 	// up resources but RemoveAll means the OS will get to it eventually (the
 	// fd will be closed on process exit at a minimum). Consistency is
@@ -258,20 +645,33 @@ func (self *FileDistribArray) Destroy() error {
 	return os.RemoveAll(self.RootPath)
 }
 func (self *FileDistribRangeReader) Read(dst []byte) (n int, err error) {
-	var toRead int
-	if len(dst) < self.nRemaining {
-		toRead = len(dst)
-	} else {
-		toRead = self.nRemaining
-		err = io.EOF
+	for n < len(dst) && len(self.segs) != 0 {
+		seg := &self.segs[0]
+
+		toRead := (int64)(len(dst) - n)
+		if toRead > seg.Len {
+			toRead = seg.Len
+		}
+
+		nRead, readErr := self.file.ReadAt(dst[n:(int64)(n)+toRead], seg.Off)
+		n += nRead
+		seg.Off += (int64)(nRead)
+		seg.Len -= (int64)(nRead)
+		if seg.Len == 0 {
+			self.segs = self.segs[1:]
+		}
+
+		if (int64)(nRead) < toRead {
+			if readErr == io.EOF {
+				readErr = io.ErrUnexpectedEOF
+			}
+			return n, readErr
+		}
 	}
 
-	n, readErr := self.file.Read(dst[:toRead])
-	self.nRemaining -= n
-	if readErr != nil {
-		err = readErr
+	if len(self.segs) == 0 {
+		err = io.EOF
 	}
-
 	return n, err
 }
 
@@ -280,32 +680,50 @@ func (self *FileDistribRangeReader) Close() error {
 }
 
 func (self *FileDistribArray) GetPartWriter(partId int) (io.WriteCloser, error) {
-	var err error
-
-	writer := &FileDistribWriter{arr: self, partId: partId}
-
-	_, err = writer.arr.fd.Seek(self.starts[partId]+(int64)(self.shape.lens[partId]), 0)
-	if err != nil {
-		return nil, err
+	if self.readOnly {
+		return nil, fmt.Errorf("Array is read-only")
+	}
+	if partId < 0 || partId >= len(self.starts) {
+		return nil, fmt.Errorf("Invalid partition %v (array has %v)", partId, len(self.starts))
 	}
 
-	return writer, nil
+	return &FileDistribWriter{arr: self, partId: partId}, nil
 }
 
+// Appends to the partition with positional writes (WriteAt), the shared fd's
+// offset is never used so writers for different partitions don't interfere.
 func (self *FileDistribWriter) Write(b []byte) (int, error) {
-	var err error
+	arr := self.arr
+	arr.partLocks[self.partId].Lock()
+	defer arr.partLocks[self.partId].Unlock()
+
+	if arr.layout == SingleFileLayout && arr.shape.caps[self.partId] == 0 {
+		return self.writeExtent(b)
+	}
 
-	nRemaining := self.arr.shape.caps[self.partId] - self.arr.shape.lens[self.partId]
+	fd, err := self.file()
+	if err != nil {
+		return 0, err
+	}
 
-	// File arrays have fixed-sized partitions (they're also append-only)
+	// Only writers for this partition change its length and we hold its lock
+	partLen := arr.partLen(self.partId)
+
+	// Fixed-size partitions can't grow (they're also append-only)
 	toWrite := (int64)(len(b))
-	if toWrite > nRemaining {
+	if partCap := arr.shape.caps[self.partId]; partCap != 0 && toWrite > partCap-partLen {
 		err = io.EOF
-		toWrite = nRemaining
+		toWrite = partCap - partLen
 	}
 
-	n, wErr := self.arr.fd.Write(b[:toWrite])
-	self.arr.shape.lens[self.partId] += (int64)(n)
+	n, wErr := fd.WriteAt(b[:toWrite], arr.starts[self.partId]+partLen)
+
+	arr.shapeLock.Lock()
+	arr.shape.lens[self.partId] += (int64)(n)
+	if n != 0 {
+		arr.dirty = true
+	}
+	arr.shapeLock.Unlock()
 
 	if wErr != nil {
 		err = wErr
@@ -313,6 +731,55 @@ func (self *FileDistribWriter) Write(b []byte) (int, error) {
 	return n, err
 }
 
+// Returns the file holding this partition, partition files are opened on first
+// use. The caller must hold the partition's lock.
+func (self *FileDistribWriter) file() (*os.File, error) {
+	arr := self.arr
+	if arr.layout == SingleFileLayout {
+		return arr.fd, nil
+	}
+
+	if arr.partFds[self.partId] == nil {
+		fd, err := os.OpenFile(arr.partPath(self.partId), os.O_RDWR, 0600)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Failed to open file for partition %v", self.partId)
+		}
+		arr.partFds[self.partId] = fd
+	}
+	return arr.partFds[self.partId], nil
+}
+
+// Append b to an unlimited partition of a single-file array. The caller must
+// hold the partition's lock.
+func (self *FileDistribWriter) writeExtent(b []byte) (int, error) {
+	arr := self.arr
+
+	// Reserve space at the end of the file
+	arr.shapeLock.Lock()
+	off := arr.end
+	arr.end += (int64)(len(b))
+	arr.shapeLock.Unlock()
+
+	n, err := arr.fd.WriteAt(b, off)
+	if n == 0 {
+		return 0, err
+	}
+
+	arr.shapeLock.Lock()
+	defer arr.shapeLock.Unlock()
+
+	exts := arr.extents[self.partId]
+	if last := len(exts) - 1; last >= 0 && exts[last].Off+exts[last].Len == off {
+		exts[last].Len += (int64)(n)
+	} else {
+		arr.extents[self.partId] = append(exts, fileExtent{Off: off, Len: (int64)(n)})
+	}
+	arr.shape.lens[self.partId] += (int64)(n)
+	arr.dirty = true
+
+	return n, err
+}
+
 func (self *FileDistribWriter) Close() error {
 	return nil
 }
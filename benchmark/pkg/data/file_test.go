@@ -1,10 +1,18 @@
 package data
 
 import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/require"
 )
 
@@ -23,3 +31,434 @@ func TestFileFactory(t *testing.T) {
 
 	testArrayFactory(t, NewFileArrayFactory(tmpDir))
 }
+
+// Create a committed two-partition array with 8 bytes in each partition
+func createCommittedFileArr(t *testing.T, rootPath string) {
+	arr, err := CreateFileDistribArray(rootPath, CreateShapeUniform(16, 2))
+	require.Nil(t, err, "Failed to create array")
+	for i := 0; i < 2; i++ {
+		writer, err := arr.GetPartWriter(i)
+		require.Nil(t, err, "Failed to get writer")
+		_, err = writer.Write([]byte("abcdefgh"))
+		require.Nil(t, err, "Failed to write")
+		writer.Close()
+	}
+	require.Nil(t, arr.Close(), "Failed to commit array")
+}
+
+func TestFileCommit(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortDataTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	arrPath := filepath.Join(tmpDir, "arr")
+	metaPath := filepath.Join(arrPath, "meta.json")
+	createCommittedFileArr(t, arrPath)
+
+	var meta fileShape
+	metaBytes, err := ioutil.ReadFile(metaPath)
+	require.Nil(t, err, "Couldn't read metadata")
+	require.Nil(t, json.Unmarshal(metaBytes, &meta), "Metadata isn't valid JSON")
+	require.Equal(t, fileArrayMagic, meta.Magic)
+	require.Equal(t, 1, meta.Version, "Arrays without unlimited partitions should stay at version 1")
+	require.Nil(t, meta.Extents)
+
+	_, err = os.Stat(metaPath + ".tmp")
+	require.True(t, os.IsNotExist(err), "Commit left a temporary file behind")
+
+	// Committing over a longer file must not leave trailing garbage
+	require.Nil(t, ioutil.WriteFile(metaPath, append(metaBytes, []byte(strings.Repeat(" ", 1000))...), 0600))
+	arr, err := OpenFileDistribArray(arrPath)
+	require.Nil(t, err, "Couldn't open array with trailing whitespace")
+	writer, err := arr.GetPartWriter(0)
+	require.Nil(t, err, "Failed to get writer")
+	_, err = writer.Write([]byte("i"))
+	require.Nil(t, err, "Failed to write")
+	writer.Close()
+	require.Nil(t, arr.Close(), "Failed to commit array")
+
+	newBytes, err := ioutil.ReadFile(metaPath)
+	require.Nil(t, err, "Couldn't read metadata")
+	require.Less(t, len(newBytes), len(metaBytes)+10, "Metadata not truncated")
+	require.Nil(t, json.Unmarshal(newBytes, &meta), "Metadata isn't valid JSON")
+	require.Equal(t, []int64{9, 8}, meta.Lens)
+
+	// A crash before the rename leaves the old metadata in charge
+	require.Nil(t, ioutil.WriteFile(metaPath+".tmp", []byte(`{"Lens":[`), 0600))
+	arr, err = OpenFileDistribArray(arrPath)
+	require.Nil(t, err, "Stale temporary metadata broke the array")
+	shape, err := arr.GetShape()
+	require.Nil(t, err)
+	require.Equal(t, (int64)(9), shape.Len(0))
+	require.Nil(t, arr.Close(), "Failed to close array")
+
+	// Closing is idempotent so Destroy() after Close() is fine
+	require.Nil(t, arr.Close(), "Second close failed")
+	require.Nil(t, arr.Destroy(), "Failed to destroy closed array")
+}
+
+func TestFileCorruption(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortDataTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	corrupt := func(name string, damage func(arrPath string)) error {
+		arrPath := filepath.Join(tmpDir, name)
+		createCommittedFileArr(t, arrPath)
+		damage(arrPath)
+		_, err := OpenFileDistribArray(arrPath)
+		return err
+	}
+	writeMeta := func(meta string) func(string) {
+		return func(arrPath string) {
+			require.Nil(t, ioutil.WriteFile(filepath.Join(arrPath, "meta.json"), []byte(meta), 0600))
+		}
+	}
+
+	// Arrays written before versioning (or by pylibsort) are still fine
+	err = corrupt("legacy", writeMeta(`{"Lens":[8,8],"Caps":[16,16]}`))
+	require.Nil(t, err, "Couldn't open legacy array")
+
+	for name, damage := range map[string]func(string){
+		"torn":     writeMeta(`{"Lens":[8,8],"Ca`),
+		"empty":    writeMeta(``),
+		"lenCap":   writeMeta(`{"Lens":[8,20],"Caps":[16,16]}`),
+		"mismatch": writeMeta(`{"Lens":[8],"Caps":[16,16]}`),
+		"magic":    writeMeta(`{"Magic":"something-else","Lens":[8,8],"Caps":[16,16]}`),
+		"shortData": func(arrPath string) {
+			require.Nil(t, os.Truncate(filepath.Join(arrPath, "data.dat"), 20))
+		},
+	} {
+		err = corrupt(name, damage)
+		require.NotNilf(t, err, "Opened %v array", name)
+		require.Equalf(t, ErrCorruptArray, errors.Cause(err), "Wrong error for %v array: %v", name, err)
+	}
+
+	err = corrupt("future", writeMeta(`{"Magic":"radixsort-filearray","Version":99,"Lens":[8,8],"Caps":[16,16]}`))
+	require.NotNil(t, err, "Opened an array from the future")
+	require.NotEqual(t, ErrCorruptArray, errors.Cause(err), "Newer arrays aren't corrupt")
+
+	// Opening must never create metadata
+	err = corrupt("missing", func(arrPath string) {
+		require.Nil(t, os.Remove(filepath.Join(arrPath, "meta.json")))
+	})
+	require.NotNil(t, err, "Opened an array without metadata")
+	_, err = os.Stat(filepath.Join(tmpDir, "missing", "meta.json"))
+	require.True(t, os.IsNotExist(err), "Open created metadata")
+}
+
+func TestFileReadOnly(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortDataTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	arrPath := filepath.Join(tmpDir, "arr")
+	metaPath := filepath.Join(arrPath, "meta.json")
+	createCommittedFileArr(t, arrPath)
+
+	// Use a recognizable (but valid) metadata file so rewrites are obvious
+	metaBytes, err := ioutil.ReadFile(metaPath)
+	require.Nil(t, err, "Couldn't read metadata")
+	metaBytes = append(metaBytes, '\n')
+	require.Nil(t, ioutil.WriteFile(metaPath, metaBytes, 0600))
+
+	// Runs in its own goroutine so failures are returned rather than
+	// reported directly
+	read := func() error {
+		arr, err := OpenFileDistribArrayReadOnly(arrPath)
+		if err != nil {
+			return errors.Wrap(err, "Failed to open read-only")
+		}
+		raw, err := FetchPartRefs([]*PartRef{{Arr: arr, PartIdx: 1, Start: 0, NByte: 8}})
+		if err != nil {
+			arr.Close()
+			return errors.Wrap(err, "Failed to read")
+		} else if !bytes.Equal([]byte("abcdefgh"), raw) {
+			arr.Close()
+			return fmt.Errorf("Read %q", raw)
+		}
+		if _, err = arr.GetPartWriter(0); err == nil {
+			arr.Close()
+			return fmt.Errorf("Read-only array returned a writer")
+		}
+		return errors.Wrap(arr.Close(), "Failed to close")
+	}
+
+	var wg sync.WaitGroup
+	readErrs := make([]error, 8)
+	for i := range readErrs {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			readErrs[id] = read()
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range readErrs {
+		require.Nilf(t, err, "Reader %v failed", i)
+	}
+
+	// Read-write handles that don't write must not commit either
+	arr, err := OpenFileDistribArray(arrPath)
+	require.Nil(t, err, "Failed to open array")
+	require.Nil(t, arr.Close(), "Failed to close")
+
+	newBytes, err := ioutil.ReadFile(metaPath)
+	require.Nil(t, err, "Couldn't read metadata")
+	require.Equal(t, metaBytes, newBytes, "Readers rewrote the metadata")
+
+	_, err = OpenFileDistribArrayReadOnly(filepath.Join(tmpDir, "missing"))
+	require.NotNil(t, err, "Opened a missing array")
+	_, err = os.Stat(filepath.Join(tmpDir, "missing"))
+	require.True(t, os.IsNotExist(err), "Read-only open created an array")
+}
+
+// Writers for every partition run at once (run with -race)
+func TestFileConcurrentWriters(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortDataTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	nPart := 16
+	nChunk := 8
+	chunkSz := 32
+	arr, err := CreateFileDistribArray(filepath.Join(tmpDir, "arr"), CreateShapeUniform((int64)(nChunk*chunkSz), nPart))
+	require.Nil(t, err, "Failed to create array")
+
+	// Two writers per partition, each chunk is filled with a single byte that
+	// identifies the partition and writer
+	write := func(id int) error {
+		writer, err := arr.GetPartWriter(id % nPart)
+		if err != nil {
+			return errors.Wrap(err, "Failed to get writer")
+		}
+		defer writer.Close()
+
+		chunk := bytes.Repeat([]byte{(byte)(id)}, chunkSz)
+		for c := 0; c < nChunk/2; c++ {
+			n, err := writer.Write(chunk)
+			if err != nil {
+				return errors.Wrap(err, "Write failed")
+			} else if n != chunkSz {
+				return fmt.Errorf("Short write (%v bytes)", n)
+			}
+
+			if _, err = arr.GetShape(); err != nil {
+				return errors.Wrap(err, "Failed to get shape")
+			}
+		}
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	writeErrs := make([]error, 2*nPart)
+	for i := range writeErrs {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			writeErrs[id] = write(id)
+		}(i)
+	}
+	wg.Wait()
+	for i, err := range writeErrs {
+		require.Nilf(t, err, "Writer %v failed", i)
+	}
+	require.Nil(t, arr.Close(), "Failed to commit array")
+
+	arr, err = OpenFileDistribArrayReadOnly(filepath.Join(tmpDir, "arr"))
+	require.Nil(t, err, "Failed to reopen array")
+	defer arr.Close()
+
+	for partId := 0; partId < nPart; partId++ {
+		raw := make([]byte, nChunk*chunkSz)
+		reader, err := arr.GetPartReader(partId)
+		require.Nil(t, err, "Failed to get reader")
+		readPart(t, reader, raw)
+		reader.Close()
+
+		counts := map[byte]int{}
+		for c := 0; c < nChunk; c++ {
+			chunk := raw[c*chunkSz : (c+1)*chunkSz]
+			require.Equalf(t, bytes.Repeat(chunk[:1], chunkSz), chunk, "Partition %v chunk %v was torn", partId, c)
+			require.Equalf(t, partId, (int)(chunk[0])%nPart, "Partition %v has data from another partition", partId)
+			counts[chunk[0]]++
+		}
+		require.Equal(t, map[byte]int{(byte)(partId): nChunk / 2, (byte)(partId + nPart): nChunk / 2}, counts)
+	}
+}
+
+func TestFileUnlimited(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortDataTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	// Fixed partitions mixed with unlimited ones
+	arrPath := filepath.Join(tmpDir, "arr")
+	arr, err := CreateFileDistribArray(arrPath, CreateShape([]int64{4, 0, 0}))
+	require.Nil(t, err, "Failed to create array")
+
+	write := func(partId int, b string) {
+		writer, err := arr.GetPartWriter(partId)
+		require.Nil(t, err, "Failed to get writer")
+		n, err := writer.Write([]byte(b))
+		require.Nil(t, err, "Write to partition %v failed", partId)
+		require.Equal(t, len(b), n)
+		writer.Close()
+	}
+	write(1, "hello ")
+	write(1, "world")
+	write(2, "interleaved")
+	write(0, "abcd")
+	write(1, ", again")
+
+	expected := []string{"abcd", "hello world, again", "interleaved"}
+	check := func(arr DistribArray) {
+		shape, err := arr.GetShape()
+		require.Nil(t, err)
+		for partId, exp := range expected {
+			require.Equal(t, (int64)(len(exp)), shape.Len(partId))
+
+			reader, err := arr.GetPartReader(partId)
+			require.Nil(t, err, "Failed to get reader")
+			raw := make([]byte, len(exp))
+			readPart(t, reader, raw)
+			reader.Close()
+			require.Equal(t, exp, string(raw))
+		}
+
+		// Ranges that cross extents
+		raw, err := FetchPartRefs([]*PartRef{{Arr: arr, PartIdx: 1, Start: 3, NByte: 12}})
+		require.Nil(t, err, "Range read failed")
+		require.Equal(t, "lo world, ag", string(raw))
+
+		_, err = arr.GetPartRangeReader(1, 10, 100)
+		require.NotNil(t, err, "Read past the end of an unlimited partition")
+	}
+	check(arr)
+
+	// The fixed partition is still bounded
+	writer, err := arr.GetPartWriter(0)
+	require.Nil(t, err)
+	_, err = writer.Write([]byte("x"))
+	require.Equal(t, io.EOF, err, "Fixed partition grew")
+
+	require.Nil(t, arr.Close(), "Failed to commit")
+
+	var meta fileShape
+	metaBytes, err := ioutil.ReadFile(filepath.Join(arrPath, "meta.json"))
+	require.Nil(t, err, "Couldn't read metadata")
+	require.Nil(t, json.Unmarshal(metaBytes, &meta))
+	require.Equal(t, 2, meta.Version)
+	require.Equal(t, 2, len(meta.Extents[1]), "Consecutive writes should share an extent")
+
+	arr, err = OpenFileDistribArray(arrPath)
+	require.Nil(t, err, "Failed to reopen")
+	check(arr)
+
+	// New writes go after everything already in the file
+	write(2, "!")
+	expected[2] += "!"
+	require.Nil(t, arr.Close(), "Failed to commit")
+
+	arr, err = OpenFileDistribArrayReadOnly(arrPath)
+	require.Nil(t, err, "Failed to reopen")
+	check(arr)
+	arr.Close()
+
+	// Extents must agree with the lengths and fit in the data file
+	require.Nil(t, os.Truncate(filepath.Join(arrPath, "data.dat"), 20))
+	_, err = OpenFileDistribArray(arrPath)
+	require.Equal(t, ErrCorruptArray, errors.Cause(err), "Truncated extents not detected")
+
+	require.Nil(t, ioutil.WriteFile(filepath.Join(arrPath, "meta.json"),
+		[]byte(`{"Version":2,"Lens":[0,5],"Caps":[4,0],"Extents":[[],[{"Off":4,"Len":3}]]}`), 0600))
+	_, err = OpenFileDistribArray(arrPath)
+	require.Equal(t, ErrCorruptArray, errors.Cause(err), "Inconsistent extents not detected")
+}
+
+func TestFilePartitionLayout(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortDataTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	// The generic tests with every array in its own partition files
+	opts := FileArrayOptions{Layout: PartitionFileLayout}
+	for _, name := range []string{"DistribArr", "Factory"} {
+		require.Nil(t, os.Mkdir(filepath.Join(tmpDir, name), 0700))
+	}
+	t.Run("DistribArr", func(t *testing.T) {
+		testDistribArr(t, NewFileArrayFactory(filepath.Join(tmpDir, "DistribArr"), opts))
+	})
+	t.Run("Factory", func(t *testing.T) {
+		testArrayFactory(t, NewFileArrayFactory(filepath.Join(tmpDir, "Factory"), opts))
+	})
+
+	arrPath := filepath.Join(tmpDir, "arr")
+	arr, err := CreateFileDistribArrayLayout(arrPath, CreateShape([]int64{4, 0, 0}), PartitionFileLayout)
+	require.Nil(t, err, "Failed to create array")
+
+	write := func(partId int, b string) {
+		writer, err := arr.GetPartWriter(partId)
+		require.Nil(t, err, "Failed to get writer")
+		n, err := writer.Write([]byte(b))
+		require.Nil(t, err, "Write to partition %v failed", partId)
+		require.Equal(t, len(b), n)
+		writer.Close()
+	}
+	write(1, "hello ")
+	write(2, "interleaved")
+	write(0, "abcd")
+	write(1, "world")
+
+	writer, err := arr.GetPartWriter(0)
+	require.Nil(t, err)
+	_, err = writer.Write([]byte("x"))
+	require.Equal(t, io.EOF, err, "Fixed partition grew")
+	require.Nil(t, arr.Close(), "Failed to commit")
+
+	// Each partition is a plain file holding exactly its data
+	expected := []string{"abcd", "hello world", "interleaved"}
+	for partId, exp := range expected {
+		raw, err := ioutil.ReadFile(filepath.Join(arrPath, fmt.Sprintf("p%v.dat", partId)))
+		require.Nil(t, err, "Couldn't read partition file %v", partId)
+		require.Equal(t, exp, string(raw))
+	}
+	_, err = os.Stat(filepath.Join(arrPath, "data.dat"))
+	require.True(t, os.IsNotExist(err), "Partition layout created data.dat")
+
+	var meta fileShape
+	metaBytes, err := ioutil.ReadFile(filepath.Join(arrPath, "meta.json"))
+	require.Nil(t, err, "Couldn't read metadata")
+	require.Nil(t, json.Unmarshal(metaBytes, &meta))
+	require.Equal(t, 3, meta.Version)
+	require.Equal(t, PartitionFileLayout, meta.Layout)
+	require.Nil(t, meta.Extents)
+
+	// The layout is picked up from the metadata
+	arr, err = OpenFileDistribArrayReadOnly(arrPath)
+	require.Nil(t, err, "Failed to reopen")
+	for partId, exp := range expected {
+		raw, err := FetchPartRefs([]*PartRef{{Arr: arr, PartIdx: partId, Start: 0, NByte: len(exp)}})
+		require.Nil(t, err, "Failed to read partition %v", partId)
+		require.Equal(t, exp, string(raw))
+	}
+	raw, err := FetchPartRefs([]*PartRef{{Arr: arr, PartIdx: 1, Start: 3, NByte: 6}})
+	require.Nil(t, err, "Range read failed")
+	require.Equal(t, "lo wor", string(raw))
+
+	_, err = arr.GetPartRangeReader(1, 10, 100)
+	require.NotNil(t, err, "Read past the end of an unlimited partition")
+	arr.Close()
+
+	// Missing or truncated partition files are detected
+	require.Nil(t, os.Truncate(filepath.Join(arrPath, "p1.dat"), 5))
+	_, err = OpenFileDistribArray(arrPath)
+	require.Equal(t, ErrCorruptArray, errors.Cause(err), "Truncated partition not detected")
+
+	require.Nil(t, os.Remove(filepath.Join(arrPath, "p2.dat")))
+	_, err = OpenFileDistribArray(arrPath)
+	require.Equal(t, ErrCorruptArray, errors.Cause(err), "Missing partition not detected")
+
+	_, err = CreateFileDistribArrayLayout(filepath.Join(tmpDir, "bad"), CreateShapeUniform(4, 1), "bogus")
+	require.NotNil(t, err, "Accepted an unknown layout")
+}
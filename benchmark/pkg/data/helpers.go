@@ -36,3 +36,63 @@ func FetchPartRefs(refs []*PartRef) ([]byte, error) {
 
 	return out, nil
 }
+
+// A read-only view of a DistribArray (see ArrayFactory.OpenReadOnly). This is
+// used by array types that have no special read-only mode.
+type readOnlyArray struct {
+	DistribArray
+}
+
+// Wrap arr so that it can't be modified, Close() doesn't close arr
+func ReadOnlyArray(arr DistribArray) DistribArray {
+	return &readOnlyArray{arr}
+}
+
+func (self *readOnlyArray) GetPartWriter(partId int) (io.WriteCloser, error) {
+	return nil, fmt.Errorf("Array is read-only")
+}
+
+func (self *readOnlyArray) Close() error {
+	return nil
+}
+
+func (self *readOnlyArray) Destroy() error {
+	return fmt.Errorf("Can't destroy a read-only array")
+}
+
+// Reads exactly nRemaining bytes from an HTTP response body (or any other
+// stream that may return short reads) and returns io.EOF along with the last
+// bytes. Bodies that end early return io.ErrUnexpectedEOF.
+type httpRangeReader struct {
+	body io.ReadCloser
+
+	// The number of bytes still to read before hitting the limit
+	nRemaining int
+}
+
+func (self *httpRangeReader) Read(dst []byte) (n int, err error) {
+	var toRead int
+	if len(dst) < self.nRemaining {
+		toRead = len(dst)
+	} else {
+		toRead = self.nRemaining
+		err = io.EOF
+	}
+
+	// Unlike files, HTTP bodies are happy to return short reads
+	n, readErr := io.ReadFull(self.body, dst[:toRead])
+	self.nRemaining -= n
+	if readErr != nil {
+		// The body ended before the requested range was complete
+		if readErr == io.EOF {
+			readErr = io.ErrUnexpectedEOF
+		}
+		err = readErr
+	}
+
+	return n, err
+}
+
+func (self *httpRangeReader) Close() error {
+	return self.body.Close()
+}
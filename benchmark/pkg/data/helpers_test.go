@@ -1,6 +1,9 @@
 package data
 
 import (
+	"bytes"
+	"io"
+	"io/ioutil"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -54,3 +57,22 @@ func TestFetchPartRefs(t *testing.T) {
 		out[outPos:outPos+sz],
 		"Third ref wrong")
 }
+
+func TestHttpRangeReader(t *testing.T) {
+	body := []byte("0123456789")
+
+	reader := &httpRangeReader{body: ioutil.NopCloser(bytes.NewReader(body)), nRemaining: 6}
+	out, err := ioutil.ReadAll(reader)
+	require.Nil(t, err, "Failed to read body")
+	require.Equal(t, body[:6], out)
+
+	// A body that ends early must not look like a complete read
+	reader = &httpRangeReader{body: ioutil.NopCloser(bytes.NewReader(body)), nRemaining: 16}
+	out, err = ioutil.ReadAll(reader)
+	require.Equal(t, io.ErrUnexpectedEOF, err, "Truncated body not reported")
+	require.Equal(t, body, out)
+
+	reader = &httpRangeReader{body: ioutil.NopCloser(bytes.NewReader(body)), nRemaining: 16}
+	_, err = io.ReadFull(reader, make([]byte, 16))
+	require.Equal(t, io.ErrUnexpectedEOF, err, "Truncated body not reported")
+}
@@ -8,7 +8,7 @@ import (
 // Describe the logical layout of a distributed array
 type DistribArrayShape struct {
 	lens []int64 // Current number of bytes per partition
-	caps []int64 // Current capacity of each partition a zero capcity indicates unlimited
+	caps []int64 // Current capacity of each partition a zero capcity indicates unlimited (the partition grows as it is written)
 }
 
 // Create a DistribArrayShape with the provided capacities
@@ -109,4 +109,9 @@ type PartRef struct {
 type ArrayFactory struct {
 	Create func(name string, shape DistribArrayShape) (DistribArray, error)
 	Open   func(name string) (DistribArray, error)
+
+	// Open an existing array without ever modifying it. Writers and Destroy()
+	// are rejected and Close() doesn't commit anything, so any number of
+	// processes may have the same array open for reading at once.
+	OpenReadOnly func(name string) (DistribArray, error)
 }
@@ -3,6 +3,7 @@ package data
 import (
 	"fmt"
 	"io"
+	"sync"
 )
 
 var MemArrayFactory *ArrayFactory = &ArrayFactory{
@@ -15,11 +16,22 @@ var MemArrayFactory *ArrayFactory = &ArrayFactory{
 		a, err := OpenMemDistribArray(name)
 		return (DistribArray)(a), err
 	},
+
+	OpenReadOnly: func(name string) (DistribArray, error) {
+		a, err := OpenMemDistribArray(name)
+		if err != nil {
+			return nil, err
+		}
+		return ReadOnlyArray(a), nil
+	},
 }
 
 // A place to store MemDistribArray data in between create and close calls.
 var memArrBacking map[string]*MemDistribArray = map[string]*MemDistribArray{}
 
+// Protects memArrBacking (multiple sorts/workers may share it)
+var memArrLock sync.Mutex
+
 // A write-closer for MemDistrib, close is a nop in this case
 type MemDistribPartWriteCloser struct {
 	arr    *MemDistribArray
@@ -41,7 +53,8 @@ func (self *MemDistribPartWriteCloser) Write(in []byte) (n int, err error) {
 	toWrite := (int64)(len(in))
 	nRemaining := shape.caps[self.partId] - shape.lens[self.partId]
 
-	if toWrite > nRemaining {
+	// Zero capacity partitions are unlimited
+	if shape.caps[self.partId] != 0 && toWrite > nRemaining {
 		toWrite = nRemaining
 		err = io.EOF
 	}
@@ -82,6 +95,9 @@ type MemDistribArray struct {
 }
 
 func CreateMemDistribArray(name string, shape DistribArrayShape) (*MemDistribArray, error) {
+	memArrLock.Lock()
+	defer memArrLock.Unlock()
+
 	if _, ok := memArrBacking[name]; ok {
 		return nil, fmt.Errorf("Array %v exists", name)
 	}
@@ -104,6 +120,8 @@ func CreateMemDistribArray(name string, shape DistribArrayShape) (*MemDistribArr
 }
 
 func OpenMemDistribArray(name string) (*MemDistribArray, error) {
+	memArrLock.Lock()
+	defer memArrLock.Unlock()
 
 	arr, ok := memArrBacking[name]
 	if !ok {
@@ -120,7 +138,7 @@ func (self *MemDistribArray) GetShape() (*DistribArrayShape, error) {
 
 func (self *MemDistribArray) GetPartRangeReader(partId, start, end int) (io.ReadCloser, error) {
 	if end <= 0 {
-		return &MemDistribPartReadCloser{buf: self.parts[partId], start: start, limit: (int)(self.shape.caps[partId]) + end}, nil
+		return &MemDistribPartReadCloser{buf: self.parts[partId], start: start, limit: (int)(self.shape.lens[partId]) + end}, nil
 	} else {
 		return &MemDistribPartReadCloser{buf: self.parts[partId], start: start, limit: end}, nil
 	}
@@ -139,7 +157,9 @@ func (self *MemDistribArray) Close() error {
 }
 
 func (self *MemDistribArray) Destroy() error {
+	memArrLock.Lock()
 	delete(memArrBacking, self.name)
+	memArrLock.Unlock()
 	self.parts = nil
 	return nil
 }
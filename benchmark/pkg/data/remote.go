@@ -0,0 +1,189 @@
+package data
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// Returns a factory for arrays hosted by an ArrayServer at serverUrl (e.g.
+// "http://localhost:8080")
+func NewRemoteArrayFactory(serverUrl string) *ArrayFactory {
+	return &ArrayFactory{
+		Create: func(name string, shape DistribArrayShape) (DistribArray, error) {
+			a, err := CreateRemoteDistribArray(serverUrl, name, shape)
+			return (DistribArray)(a), err
+		},
+
+		Open: func(name string) (DistribArray, error) {
+			a, err := OpenRemoteDistribArray(serverUrl, name)
+			return (DistribArray)(a), err
+		},
+
+		// The server may still have the array open read-write but we never
+		// ask it to commit
+		OpenReadOnly: func(name string) (DistribArray, error) {
+			a, err := OpenRemoteDistribArray(serverUrl, name)
+			if err != nil {
+				return nil, err
+			}
+			return ReadOnlyArray(a), nil
+		},
+	}
+}
+
+// A client for an array hosted by an ArrayServer. The array itself has the
+// consistency semantics of whatever factory the server uses. Writes are sent
+// to the server immediately, Close() asks the server to commit the array.
+type RemoteDistribArray struct {
+	ServerUrl string
+	Name      string
+
+	client *http.Client
+}
+
+type RemoteDistribWriter struct {
+	arr    *RemoteDistribArray
+	partId int
+}
+
+// Create a new array on the server at serverUrl
+func CreateRemoteDistribArray(serverUrl string, name string, shape DistribArrayShape) (*RemoteDistribArray, error) {
+	arr := &RemoteDistribArray{ServerUrl: strings.TrimRight(serverUrl, "/"), Name: name, client: http.DefaultClient}
+
+	jsonBytes, err := json.Marshal(fileShape{Lens: shape.lens, Caps: shape.caps})
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't convert shape to json")
+	}
+
+	resp, err := arr.do("PUT", "", nil, jsonBytes)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create remote array")
+	}
+	resp.Body.Close()
+
+	return arr, nil
+}
+
+// Open an existing array on the server at serverUrl
+func OpenRemoteDistribArray(serverUrl string, name string) (*RemoteDistribArray, error) {
+	arr := &RemoteDistribArray{ServerUrl: strings.TrimRight(serverUrl, "/"), Name: name, client: http.DefaultClient}
+
+	// Make sure it exists
+	if _, err := arr.GetShape(); err != nil {
+		return nil, errors.Wrap(err, "Failed to open remote array")
+	}
+
+	return arr, nil
+}
+
+// Send a request for this array. subPath is relative to the array's URL.
+// Non-2xx responses are converted to errors. On success, the caller must close
+// the response body.
+func (self *RemoteDistribArray) do(method string, subPath string, query url.Values, body []byte) (*http.Response, error) {
+	reqUrl := self.ServerUrl + "/arrays/" + url.PathEscape(self.Name) + subPath
+	if query != nil {
+		reqUrl += "?" + query.Encode()
+	}
+
+	req, err := http.NewRequest(method, reqUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create request")
+	}
+
+	resp, err := self.client.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%v %v failed", method, reqUrl)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		msg, _ := ioutil.ReadAll(resp.Body)
+		return nil, fmt.Errorf("Remote array error (%v): %v", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return resp, nil
+}
+
+func (self *RemoteDistribArray) GetShape() (*DistribArrayShape, error) {
+	resp, err := self.do("GET", "/shape", nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var jsonShape fileShape
+	if err = json.NewDecoder(resp.Body).Decode(&jsonShape); err != nil {
+		return nil, errors.Wrap(err, "Failed to interpret shape")
+	}
+
+	return &DistribArrayShape{lens: jsonShape.Lens, caps: jsonShape.Caps}, nil
+}
+
+func (self *RemoteDistribArray) GetPartRangeReader(partId, start, end int) (io.ReadCloser, error) {
+	query := url.Values{}
+	query.Set("start", fmt.Sprint(start))
+	query.Set("end", fmt.Sprint(end))
+
+	resp, err := self.do("GET", fmt.Sprintf("/parts/%v", partId), query, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &httpRangeReader{body: resp.Body, nRemaining: (int)(resp.ContentLength)}, nil
+}
+
+func (self *RemoteDistribArray) GetPartReader(partId int) (io.ReadCloser, error) {
+	return self.GetPartRangeReader(partId, 0, 0)
+}
+
+func (self *RemoteDistribArray) GetPartWriter(partId int) (io.WriteCloser, error) {
+	return &RemoteDistribWriter{arr: self, partId: partId}, nil
+}
+
+func (self *RemoteDistribArray) Close() error {
+	resp, err := self.do("POST", "/close", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (self *RemoteDistribArray) Destroy() error {
+	resp, err := self.do("DELETE", "", nil, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+func (self *RemoteDistribWriter) Write(b []byte) (int, error) {
+	resp, err := self.arr.do("POST", fmt.Sprintf("/parts/%v", self.partId), nil, b)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var wResp remoteWriteResp
+	if err = json.NewDecoder(resp.Body).Decode(&wResp); err != nil {
+		return 0, errors.Wrap(err, "Failed to interpret write response")
+	}
+
+	if wResp.EOF {
+		return wResp.N, io.EOF
+	}
+	return wResp.N, nil
+}
+
+func (self *RemoteDistribWriter) Close() error {
+	return nil
+}
@@ -0,0 +1,95 @@
+package data
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoteDistribPartRange(t *testing.T) {
+	server := httptest.NewServer(NewArrayServer(MemArrayFactory))
+	defer server.Close()
+
+	targetSz := 4
+	shape := CreateShapeUniform((int64)(targetSz), 1)
+
+	arr, err := CreateRemoteDistribArray(server.URL, "TestRemoteRangeReader", shape)
+	require.Nil(t, err)
+	defer arr.Destroy()
+
+	raw := generateBytes(t, arr, targetSz)
+
+	t.Run("Full Range", func(t *testing.T) { testPartRangeReader(t, arr, raw, 0, 0) })
+	t.Run("First Two", func(t *testing.T) { testPartRangeReader(t, arr, raw, 0, 2) })
+	t.Run("Middle", func(t *testing.T) { testPartRangeReader(t, arr, raw, 1, 3) })
+	t.Run("Last Two Explicit", func(t *testing.T) { testPartRangeReader(t, arr, raw, 3, 4) })
+	t.Run("Last Two Zero End", func(t *testing.T) { testPartRangeReader(t, arr, raw, 3, 0) })
+	t.Run("Negative End", func(t *testing.T) { testPartRangeReader(t, arr, raw, 1, -1) })
+}
+
+func TestRemoteFileDistribArr(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortRemoteTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	server := httptest.NewServer(NewArrayServer(NewFileArrayFactory(tmpDir)))
+	defer server.Close()
+
+	testDistribArr(t, NewRemoteArrayFactory(server.URL))
+}
+
+func TestRemoteFactory(t *testing.T) {
+	server := httptest.NewServer(NewArrayServer(MemArrayFactory))
+	defer server.Close()
+
+	testArrayFactory(t, NewRemoteArrayFactory(server.URL))
+}
+
+func TestRemoteCreateShape(t *testing.T) {
+	server := httptest.NewServer(NewArrayServer(MemArrayFactory))
+	defer server.Close()
+
+	// Lengths set by the client survive the trip to the server
+	shape := CreateShape([]int64{8, 8})
+	shape.lens[1] = 4
+	arr, err := CreateRemoteDistribArray(server.URL, "TestRemoteCreateShape", shape)
+	require.Nil(t, err, "Failed to create array")
+	defer arr.Destroy()
+
+	remoteShape, err := arr.GetShape()
+	require.Nil(t, err, "Failed to get shape")
+	require.Equal(t, shape.lens, remoteShape.lens)
+	require.Equal(t, shape.caps, remoteShape.caps)
+
+	bad := &RemoteDistribArray{ServerUrl: server.URL, Name: "TestRemoteCreateShapeBad", client: http.DefaultClient}
+	_, err = bad.do("PUT", "", nil, []byte(`{"Lens":[0],"Caps":[8,8]}`))
+	require.NotNil(t, err, "Accepted mismatched lens")
+}
+
+// Data written by one client must be visible to another (e.g. a worker process
+// reading its inputs).
+func TestRemoteSharing(t *testing.T) {
+	server := httptest.NewServer(NewArrayServer(MemArrayFactory))
+	defer server.Close()
+
+	writerFact := NewRemoteArrayFactory(server.URL)
+	readerFact := NewRemoteArrayFactory(server.URL)
+
+	arr, err := writerFact.Create("TestRemoteSharing", CreateShapeUniform(16, 2))
+	require.Nil(t, err, "Failed to create array")
+	raw := generateBytes(t, arr, 16)
+	require.Nil(t, arr.Close(), "Failed to close array")
+
+	reader, err := readerFact.Open("TestRemoteSharing")
+	require.Nil(t, err, "Failed to open array from second client")
+	checkArr(t, reader, raw)
+
+	require.Nil(t, reader.Destroy(), "Failed to destroy array")
+
+	_, err = readerFact.Open("TestRemoteSharing")
+	require.NotNil(t, err, "Opened destroyed array")
+}
@@ -0,0 +1,245 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+func NewS3ArrayFactory(client *S3Client, bucket string, prefix string) *ArrayFactory {
+	return &ArrayFactory{
+		Create: func(name string, shape DistribArrayShape) (DistribArray, error) {
+			a, err := CreateS3DistribArray(client, bucket, path.Join(prefix, name), shape)
+			return (DistribArray)(a), err
+		},
+
+		Open: func(name string) (DistribArray, error) {
+			a, err := OpenS3DistribArray(client, bucket, path.Join(prefix, name))
+			return (DistribArray)(a), err
+		},
+
+		OpenReadOnly: func(name string) (DistribArray, error) {
+			a, err := OpenS3DistribArray(client, bucket, path.Join(prefix, name))
+			if err != nil {
+				return nil, err
+			}
+			return ReadOnlyArray(a), nil
+		},
+	}
+}
+
+// Stores a distributed array in an S3-compatible object store. All objects
+// for the array are stored under Key in Bucket:
+//
+//	Key/meta.json: stores the shape of the array (same format as FileDistribArray)
+//	Key/p${partID}.dat: Stores the data for each partition. Objects are
+//		only created for partitions that have been written to.
+//
+// Objects are immutable so partition writers buffer their data locally and
+// upload the whole partition when they are closed. Appending to a partition
+// that already has data requires re-uploading the existing data. Shape changes
+// are only committed to the object store by DistribArray.Close().
+type S3DistribArray struct {
+	Bucket string
+	Key    string
+
+	client *S3Client
+	shape  DistribArrayShape
+
+	// True if the shape has changed since the last commit
+	dirty bool
+}
+
+type S3DistribWriter struct {
+	arr    *S3DistribArray
+	partId int
+	buf    []byte
+}
+
+func s3MetaKey(key string) string {
+	return key + "/meta.json"
+}
+
+func s3PartKey(key string, partId int) string {
+	return fmt.Sprintf("%v/p%v.dat", key, partId)
+}
+
+// Create a new S3-backed distributed array. Partitions cannot be resized.
+func CreateS3DistribArray(client *S3Client, bucket string, key string, shape DistribArrayShape) (*S3DistribArray, error) {
+	key = strings.Trim(key, "/")
+
+	exists, err := client.HeadObject(bucket, s3MetaKey(key))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to check for existing array")
+	}
+	if exists {
+		return nil, fmt.Errorf("Array %v exists", key)
+	}
+
+	arr := &S3DistribArray{Bucket: bucket, Key: key, client: client}
+	arr.shape.caps = make([]int64, len(shape.caps))
+	arr.shape.lens = make([]int64, len(shape.caps))
+	copy(arr.shape.caps, shape.caps)
+	copy(arr.shape.lens, shape.lens)
+
+	if err = arr.commitMeta(); err != nil {
+		return nil, errors.Wrap(err, "Failed to create array metadata")
+	}
+
+	return arr, nil
+}
+
+// Create a new S3DistribArray object from an existing array in the object
+// store
+func OpenS3DistribArray(client *S3Client, bucket string, key string) (*S3DistribArray, error) {
+	arr := &S3DistribArray{Bucket: bucket, Key: strings.Trim(key, "/"), client: client}
+
+	if err := arr.loadMeta(); err != nil {
+		return nil, errors.Wrap(err, "Failed to load metadata")
+	}
+
+	return arr, nil
+}
+
+func (self *S3DistribArray) commitMeta() error {
+	jsonShape := fileShape{Lens: self.shape.lens, Caps: self.shape.caps}
+
+	jsonBytes, err := json.Marshal(jsonShape)
+	if err != nil {
+		return errors.Wrap(err, "Couldn't convert shape to json")
+	}
+
+	if err = self.client.PutObject(self.Bucket, s3MetaKey(self.Key), jsonBytes); err != nil {
+		return errors.Wrap(err, "Error while writing metadata")
+	}
+
+	self.dirty = false
+	return nil
+}
+
+func (self *S3DistribArray) loadMeta() error {
+	metaBytes, err := self.client.GetObject(self.Bucket, s3MetaKey(self.Key))
+	if err != nil {
+		return errors.Wrap(err, "Failed to read metadata")
+	}
+
+	var jsonShape fileShape
+	if err = json.Unmarshal(metaBytes, &jsonShape); err != nil {
+		return errors.Wrap(err, "Failed to interpret metadata")
+	}
+
+	self.shape.lens = jsonShape.Lens
+	self.shape.caps = jsonShape.Caps
+	return nil
+}
+
+func (self *S3DistribArray) GetShape() (*DistribArrayShape, error) {
+	// Copy the slices but not their underlying array (DistribArrayShape is immutable)
+	return &DistribArrayShape{lens: self.shape.lens, caps: self.shape.caps}, nil
+}
+
+func (self *S3DistribArray) GetPartRangeReader(partId, start, end int) (io.ReadCloser, error) {
+	if end <= 0 {
+		end = (int)(self.shape.lens[partId]) + end
+	}
+
+	reader := &httpRangeReader{nRemaining: end - start}
+	if reader.nRemaining <= 0 {
+		reader.nRemaining = 0
+		reader.body = ioutil.NopCloser(strings.NewReader(""))
+		return reader, nil
+	}
+
+	body, err := self.client.GetObjectRange(self.Bucket, s3PartKey(self.Key, partId), (int64)(start), (int64)(end))
+	if err != nil {
+		return nil, errors.Wrapf(err, "Failed to read partition %v", partId)
+	}
+	reader.body = body
+
+	return reader, nil
+}
+
+func (self *S3DistribArray) GetPartReader(partId int) (io.ReadCloser, error) {
+	return self.GetPartRangeReader(partId, 0, 0)
+}
+
+func (self *S3DistribArray) GetPartWriter(partId int) (io.WriteCloser, error) {
+	return &S3DistribWriter{arr: self, partId: partId}, nil
+}
+
+func (self *S3DistribArray) Close() error {
+	if !self.dirty {
+		return nil
+	}
+
+	if err := self.commitMeta(); err != nil {
+		return errors.Wrap(err, "Array commit failure")
+	}
+	return nil
+}
+
+func (self *S3DistribArray) Destroy() error {
+	var firstErr error
+	for i := 0; i < self.shape.NPart(); i++ {
+		if err := self.client.DeleteObject(self.Bucket, s3PartKey(self.Key, i)); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if err := self.client.DeleteObject(self.Bucket, s3MetaKey(self.Key)); err != nil && firstErr == nil {
+		firstErr = err
+	}
+
+	return firstErr
+}
+
+func (self *S3DistribWriter) Write(b []byte) (int, error) {
+	var err error
+
+	shape := self.arr.shape
+	nRemaining := shape.caps[self.partId] - shape.lens[self.partId] - (int64)(len(self.buf))
+
+	// Zero capacity partitions are unlimited
+	toWrite := (int64)(len(b))
+	if shape.caps[self.partId] != 0 && toWrite > nRemaining {
+		err = io.EOF
+		toWrite = nRemaining
+	}
+
+	self.buf = append(self.buf, b[:toWrite]...)
+	return (int)(toWrite), err
+}
+
+// Upload the partition. The partition is not modified until Close is called.
+func (self *S3DistribWriter) Close() error {
+	if len(self.buf) == 0 {
+		return nil
+	}
+
+	arr := self.arr
+	partKey := s3PartKey(arr.Key, self.partId)
+
+	newData := self.buf
+	if arr.shape.lens[self.partId] != 0 {
+		oldData, err := arr.client.GetObject(arr.Bucket, partKey)
+		if err != nil {
+			return errors.Wrapf(err, "Failed to read existing data for partition %v", self.partId)
+		}
+		newData = append(oldData[:arr.shape.lens[self.partId]], self.buf...)
+	}
+
+	if err := arr.client.PutObject(arr.Bucket, partKey, newData); err != nil {
+		return errors.Wrapf(err, "Failed to upload partition %v", self.partId)
+	}
+
+	arr.shape.lens[self.partId] += (int64)(len(self.buf))
+	arr.dirty = true
+	self.buf = nil
+
+	return nil
+}
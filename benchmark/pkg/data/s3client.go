@@ -0,0 +1,253 @@
+package data
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// A minimal client for S3-compatible object stores. It only supports the
+// handful of operations needed by S3DistribArray and always uses path-style
+// addressing (endpoint/bucket/key) which is supported by AWS as well as most
+// S3 clones (minio, ceph, etc.). Requests are signed with AWS signature
+// version 4 unless AccessKey is empty (anonymous access).
+type S3Client struct {
+	Endpoint  string // e.g. "https://s3.us-west-2.amazonaws.com" or "http://localhost:9000"
+	Region    string
+	AccessKey string
+	SecretKey string
+
+	HttpClient *http.Client
+}
+
+// Returned for any non-successful response from the server
+type S3Error struct {
+	StatusCode int
+	Code       string `xml:"Code"`
+	Message    string `xml:"Message"`
+}
+
+func (self *S3Error) Error() string {
+	return fmt.Sprintf("S3 error (%v): %v: %v", self.StatusCode, self.Code, self.Message)
+}
+
+// Returns true if err was caused by a missing key or bucket
+func IsS3NotFound(err error) bool {
+	s3Err, ok := errors.Cause(err).(*S3Error)
+	return ok && s3Err.StatusCode == http.StatusNotFound
+}
+
+func NewS3Client(endpoint string, region string, accessKey string, secretKey string) *S3Client {
+	return &S3Client{
+		Endpoint:   strings.TrimRight(endpoint, "/"),
+		Region:     region,
+		AccessKey:  accessKey,
+		SecretKey:  secretKey,
+		HttpClient: http.DefaultClient,
+	}
+}
+
+// Create or replace key with body
+func (self *S3Client) PutObject(bucket string, key string, body []byte) error {
+	resp, err := self.do("PUT", bucket, key, body, nil)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Read all of key
+func (self *S3Client) GetObject(bucket string, key string) ([]byte, error) {
+	resp, err := self.do("GET", bucket, key, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Read the bytes [start, end) of key. The caller must close the returned
+// reader. end must be greater than start.
+func (self *S3Client) GetObjectRange(bucket string, key string, start int64, end int64) (io.ReadCloser, error) {
+	hdrs := map[string]string{"Range": fmt.Sprintf("bytes=%v-%v", start, end-1)}
+	resp, err := self.do("GET", bucket, key, nil, hdrs)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// Returns true if key exists
+func (self *S3Client) HeadObject(bucket string, key string) (bool, error) {
+	resp, err := self.do("HEAD", bucket, key, nil, nil)
+	if err != nil {
+		if IsS3NotFound(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	resp.Body.Close()
+	return true, nil
+}
+
+// Remove key. Deleting a key that doesn't exist is not an error.
+func (self *S3Client) DeleteObject(bucket string, key string) error {
+	resp, err := self.do("DELETE", bucket, key, nil, nil)
+	if err != nil {
+		if IsS3NotFound(err) {
+			return nil
+		}
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Issue a request and check the response status. On success, the caller is
+// responsible for closing the response body.
+func (self *S3Client) do(method string, bucket string, key string, body []byte, hdrs map[string]string) (*http.Response, error) {
+	reqUrl := self.Endpoint + "/" + s3EscapePath(bucket+"/"+key)
+
+	req, err := http.NewRequest(method, reqUrl, bytes.NewReader(body))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create request")
+	}
+	req.ContentLength = (int64)(len(body))
+
+	for k, v := range hdrs {
+		req.Header.Set(k, v)
+	}
+
+	payloadHash := sha256.Sum256(body)
+	s3SignV4(req, hex.EncodeToString(payloadHash[:]), self.AccessKey, self.SecretKey, self.Region, time.Now())
+
+	resp, err := self.HttpClient.Do(req)
+	if err != nil {
+		return nil, errors.Wrapf(err, "%v %v failed", method, reqUrl)
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+
+		s3Err := &S3Error{StatusCode: resp.StatusCode}
+		errBody, _ := ioutil.ReadAll(resp.Body)
+
+		// HEAD responses (and some servers) don't include an error body
+		if len(errBody) == 0 || xml.Unmarshal(errBody, s3Err) != nil {
+			s3Err.Code = http.StatusText(resp.StatusCode)
+		}
+		return nil, errors.WithMessagef(s3Err, "%v %v failed", method, reqUrl)
+	}
+
+	return resp, nil
+}
+
+// Escape a key for use in a URL path according to the S3 rules (everything but
+// unreserved characters and '/' is percent-encoded).
+func s3EscapePath(p string) string {
+	var buf strings.Builder
+	for _, c := range []byte(p) {
+		if ('A' <= c && c <= 'Z') || ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') ||
+			c == '-' || c == '_' || c == '.' || c == '~' || c == '/' {
+			buf.WriteByte(c)
+		} else {
+			fmt.Fprintf(&buf, "%%%02X", c)
+		}
+	}
+	return buf.String()
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// Sign req using AWS signature version 4. The host, range and all x-amz-*
+// headers are signed. Does nothing if accessKey is empty.
+func s3SignV4(req *http.Request, payloadHash string, accessKey string, secretKey string, region string, now time.Time) {
+	if accessKey == "" {
+		return
+	}
+
+	amzDate := now.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	// Canonical headers must be sorted by lower-case name
+	signed := map[string]string{"host": req.URL.Host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "range" || strings.HasPrefix(lk, "x-amz-") {
+			signed[lk] = strings.TrimSpace(strings.Join(v, ","))
+		}
+	}
+	names := make([]string, 0, len(signed))
+	for k := range signed {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canonHdrs strings.Builder
+	for _, k := range names {
+		canonHdrs.WriteString(k + ":" + signed[k] + "\n")
+	}
+	signedHdrs := strings.Join(names, ";")
+
+	// Query parameters must be sorted and strictly encoded
+	query := req.URL.Query()
+	qKeys := make([]string, 0, len(query))
+	for k := range query {
+		qKeys = append(qKeys, k)
+	}
+	sort.Strings(qKeys)
+	var qParts []string
+	for _, k := range qKeys {
+		for _, v := range query[k] {
+			qParts = append(qParts, url.QueryEscape(k)+"="+url.QueryEscape(v))
+		}
+	}
+
+	canonPath := req.URL.EscapedPath()
+	if canonPath == "" {
+		canonPath = "/"
+	}
+
+	canonReq := strings.Join([]string{
+		req.Method,
+		canonPath,
+		strings.Join(qParts, "&"),
+		canonHdrs.String(),
+		signedHdrs,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	canonHash := sha256.Sum256([]byte(canonReq))
+	toSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(canonHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+secretKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, toSign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%v/%v, SignedHeaders=%v, Signature=%v",
+		accessKey, scope, signedHdrs, signature))
+}
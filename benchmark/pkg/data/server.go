@@ -0,0 +1,329 @@
+package data
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Exposes the arrays from an ArrayFactory over HTTP. Use RemoteDistribArray
+// (or NewRemoteArrayFactory) to access them from another process. All paths
+// are relative to the server root and array names must be path-escaped:
+//
+//	PUT    /arrays/{name}               Create (body is the JSON shape)
+//	GET    /arrays/{name}/shape         Open/GetShape (returns the JSON shape)
+//	GET    /arrays/{name}/parts/{id}    Read partition (optional 'start' and
+//	                                    'end' query params, same semantics as
+//	                                    GetPartRangeReader)
+//	POST   /arrays/{name}/parts/{id}    Append body to partition (returns
+//	                                    remoteWriteResp)
+//	POST   /arrays/{name}/close         Close
+//	DELETE /arrays/{name}               Destroy
+//
+// Arrays are opened lazily from the factory and kept open until a client
+// closes or destroys them.
+type ArrayServer struct {
+	factory *ArrayFactory
+
+	lock sync.Mutex
+	open map[string]*serverArray
+}
+
+// An open array and the lock protecting it. Reads may happen in parallel but
+// anything that modifies the array is exclusive.
+type serverArray struct {
+	arr  DistribArray
+	lock sync.RWMutex
+}
+
+// Response to a partition append
+type remoteWriteResp struct {
+	N   int  `json:"n"`
+	EOF bool `json:"eof"`
+}
+
+func NewArrayServer(factory *ArrayFactory) *ArrayServer {
+	return &ArrayServer{factory: factory, open: map[string]*serverArray{}}
+}
+
+// Returns an open array, opening it from the factory if needed
+func (self *ArrayServer) getArray(name string) (*serverArray, error) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if sArr, ok := self.open[name]; ok {
+		return sArr, nil
+	}
+
+	arr, err := self.factory.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	sArr := &serverArray{arr: arr}
+	self.open[name] = sArr
+	return sArr, nil
+}
+
+// Remove name from the open list, returns nil if it wasn't open
+func (self *ArrayServer) forgetArray(name string) *serverArray {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	sArr := self.open[name]
+	delete(self.open, name)
+	return sArr
+}
+
+func (self *ArrayServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var segs []string
+	for _, seg := range strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/") {
+		unescaped, err := url.PathUnescape(seg)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid path: %v", err), http.StatusBadRequest)
+			return
+		}
+		segs = append(segs, unescaped)
+	}
+
+	if len(segs) < 2 || segs[0] != "arrays" {
+		http.NotFound(w, r)
+		return
+	}
+	name := segs[1]
+
+	switch {
+	case len(segs) == 2 && r.Method == "PUT":
+		self.handleCreate(w, r, name)
+	case len(segs) == 2 && r.Method == "DELETE":
+		self.handleDestroy(w, r, name)
+	case len(segs) == 3 && segs[2] == "shape" && r.Method == "GET":
+		self.handleShape(w, r, name)
+	case len(segs) == 3 && segs[2] == "close" && r.Method == "POST":
+		self.handleClose(w, r, name)
+	case len(segs) == 4 && segs[2] == "parts":
+		partId, err := strconv.Atoi(segs[3])
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Invalid partition ID %v", segs[3]), http.StatusBadRequest)
+			return
+		}
+
+		if r.Method == "GET" {
+			self.handleRead(w, r, name, partId)
+		} else if r.Method == "POST" {
+			self.handleAppend(w, r, name, partId)
+		} else {
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func (self *ArrayServer) handleCreate(w http.ResponseWriter, r *http.Request, name string) {
+	var jsonShape fileShape
+	if err := json.NewDecoder(r.Body).Decode(&jsonShape); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to interpret shape: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	// Preset lengths are passed through like any other factory would see them
+	shape := CreateShape(jsonShape.Caps)
+	if jsonShape.Lens != nil {
+		if len(jsonShape.Lens) != len(jsonShape.Caps) {
+			http.Error(w, fmt.Sprintf("Shape has %v lens but %v caps", len(jsonShape.Lens), len(jsonShape.Caps)),
+				http.StatusBadRequest)
+			return
+		}
+		copy(shape.lens, jsonShape.Lens)
+	}
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	arr, err := self.factory.Create(name, shape)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create array: %v", err), http.StatusConflict)
+		return
+	}
+	self.open[name] = &serverArray{arr: arr}
+}
+
+func (self *ArrayServer) handleShape(w http.ResponseWriter, r *http.Request, name string) {
+	sArr, err := self.getArray(name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open array: %v", err), http.StatusNotFound)
+		return
+	}
+
+	sArr.lock.RLock()
+	shape, err := sArr.arr.GetShape()
+	var jsonShape fileShape
+	if err == nil {
+		jsonShape = fileShape{Lens: shape.lens, Caps: shape.caps}
+	}
+	jsonBytes, _ := json.Marshal(jsonShape)
+	sArr.lock.RUnlock()
+
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get shape: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonBytes)
+}
+
+func (self *ArrayServer) handleRead(w http.ResponseWriter, r *http.Request, name string, partId int) {
+	var err error
+
+	start, end := 0, 0
+	if startStr := r.URL.Query().Get("start"); startStr != "" {
+		if start, err = strconv.Atoi(startStr); err != nil {
+			http.Error(w, "Invalid start", http.StatusBadRequest)
+			return
+		}
+	}
+	if endStr := r.URL.Query().Get("end"); endStr != "" {
+		if end, err = strconv.Atoi(endStr); err != nil {
+			http.Error(w, "Invalid end", http.StatusBadRequest)
+			return
+		}
+	}
+
+	sArr, err := self.getArray(name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open array: %v", err), http.StatusNotFound)
+		return
+	}
+
+	sArr.lock.RLock()
+	defer sArr.lock.RUnlock()
+
+	shape, err := sArr.arr.GetShape()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get shape: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if partId < 0 || partId >= shape.NPart() {
+		http.Error(w, fmt.Sprintf("Invalid partition %v", partId), http.StatusBadRequest)
+		return
+	}
+
+	realEnd := end
+	if end <= 0 {
+		realEnd = (int)(shape.Len(partId)) + end
+	}
+	if start < 0 || realEnd < start || (int64)(realEnd) > shape.Len(partId) {
+		http.Error(w, fmt.Sprintf("Invalid range [%v, %v)", start, end), http.StatusRequestedRangeNotSatisfiable)
+		return
+	}
+
+	reader, err := sArr.arr.GetPartRangeReader(partId, start, end)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read partition: %v", err), http.StatusInternalServerError)
+		return
+	}
+	defer reader.Close()
+
+	w.Header().Set("Content-Length", strconv.Itoa(realEnd-start))
+	w.Header().Set("Content-Type", "application/octet-stream")
+
+	// There isn't much we can do about errors once we've started sending
+	// data, the client will notice the short read.
+	io.Copy(w, reader)
+}
+
+func (self *ArrayServer) handleAppend(w http.ResponseWriter, r *http.Request, name string, partId int) {
+	sArr, err := self.getArray(name)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to open array: %v", err), http.StatusNotFound)
+		return
+	}
+
+	sArr.lock.Lock()
+	defer sArr.lock.Unlock()
+
+	writer, err := sArr.arr.GetPartWriter(partId)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to get writer: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	var resp remoteWriteResp
+	buf := make([]byte, 64*1024)
+	for {
+		nRead, readErr := r.Body.Read(buf)
+
+		if nRead > 0 {
+			n, err := writer.Write(buf[:nRead])
+			resp.N += n
+			if err == io.EOF {
+				resp.EOF = true
+				break
+			} else if err != nil {
+				writer.Close()
+				http.Error(w, fmt.Sprintf("Failed to write: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		if readErr == io.EOF {
+			break
+		} else if readErr != nil {
+			writer.Close()
+			http.Error(w, fmt.Sprintf("Failed to read request: %v", readErr), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if err = writer.Close(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to close writer: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	jsonResp, _ := json.Marshal(resp)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(jsonResp)
+}
+
+func (self *ArrayServer) handleClose(w http.ResponseWriter, r *http.Request, name string) {
+	sArr := self.forgetArray(name)
+	if sArr == nil {
+		// Not open, nothing to commit
+		return
+	}
+
+	sArr.lock.Lock()
+	defer sArr.lock.Unlock()
+
+	if err := sArr.arr.Close(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to close array: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
+
+func (self *ArrayServer) handleDestroy(w http.ResponseWriter, r *http.Request, name string) {
+	sArr := self.forgetArray(name)
+	if sArr == nil {
+		arr, err := self.factory.Open(name)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to open array: %v", err), http.StatusNotFound)
+			return
+		}
+		sArr = &serverArray{arr: arr}
+	}
+
+	sArr.lock.Lock()
+	defer sArr.lock.Unlock()
+
+	if err := sArr.arr.Destroy(); err != nil {
+		http.Error(w, fmt.Sprintf("Failed to destroy array: %v", err), http.StatusInternalServerError)
+		return
+	}
+}
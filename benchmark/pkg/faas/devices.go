@@ -0,0 +1,216 @@
+package faas
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/pkg/errors"
+	"golang.org/x/sync/semaphore"
+)
+
+// Finds the devices that workers may use. Each entry of the returned slice is
+// one device slot, identified by the value that should be passed to the worker
+// in CUDA_VISIBLE_DEVICES. An empty identifier means a CPU-only slot (the
+// worker's environment is left alone).
+type DeviceDiscovery func() ([]string, error)
+
+// Use 'nvidia-smi -L' to find all GPUs on the system
+func NvidiaSmiDiscovery() ([]string, error) {
+	cmd := exec.Command("nvidia-smi", "-L")
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.Wrap(err, "Error determining GPU count")
+	}
+
+	nDev := bytes.Count(out, []byte("\n"))
+	return FixedDiscovery(nDev)()
+}
+
+// Use the devices listed in CUDA_VISIBLE_DEVICES (e.g. when this process was
+// itself given a subset of the GPUs)
+func CudaVisibleDevicesDiscovery() ([]string, error) {
+	visible, ok := os.LookupEnv("CUDA_VISIBLE_DEVICES")
+	if !ok {
+		return nil, fmt.Errorf("CUDA_VISIBLE_DEVICES not set")
+	}
+
+	var devs []string
+	for _, dev := range strings.Split(visible, ",") {
+		dev = strings.TrimSpace(dev)
+
+		// CUDA ignores everything after the first invalid entry
+		if dev == "" || strings.HasPrefix(dev, "-") {
+			break
+		}
+		devs = append(devs, dev)
+	}
+	return devs, nil
+}
+
+// Use a fixed number of GPUs (IDs 0 through nDev-1)
+func FixedDiscovery(nDev int) DeviceDiscovery {
+	return func() ([]string, error) {
+		devs := make([]string, nDev)
+		for i := 0; i < nDev; i++ {
+			devs[i] = strconv.Itoa(i)
+		}
+		return devs, nil
+	}
+}
+
+// Use nSlot CPU-only slots (one per CPU if nSlot is 0). This just limits the
+// number of concurrent workers.
+func CpuSlotDiscovery(nSlot int) DeviceDiscovery {
+	return func() ([]string, error) {
+		if nSlot == 0 {
+			nSlot = runtime.NumCPU()
+		}
+		return make([]string, nSlot), nil
+	}
+}
+
+// Interpret a device configuration string:
+//
+//	"" or "nvidia-smi": NvidiaSmiDiscovery
+//	"cuda": CudaVisibleDevicesDiscovery
+//	"cpu" or "cpu:N": CpuSlotDiscovery
+//	"N": FixedDiscovery(N)
+func ParseDeviceDiscovery(spec string) (DeviceDiscovery, error) {
+	switch {
+	case spec == "" || spec == "nvidia-smi":
+		return NvidiaSmiDiscovery, nil
+	case spec == "cuda":
+		return CudaVisibleDevicesDiscovery, nil
+	case spec == "cpu":
+		return CpuSlotDiscovery(0), nil
+	case strings.HasPrefix(spec, "cpu:"):
+		nSlot, err := strconv.Atoi(strings.TrimPrefix(spec, "cpu:"))
+		if err != nil || nSlot <= 0 {
+			return nil, fmt.Errorf("Invalid number of CPU slots: %v", spec)
+		}
+		return CpuSlotDiscovery(nSlot), nil
+	default:
+		nDev, err := strconv.Atoi(spec)
+		if err != nil || nDev <= 0 {
+			return nil, fmt.Errorf("Unrecognized device configuration: %v", spec)
+		}
+		return FixedDiscovery(nDev), nil
+	}
+}
+
+// Hands out exclusive access to devices. Reserve() blocks until a device is
+// free.
+type DeviceManager struct {
+	devSemaphore *semaphore.Weighted
+	inUse        []uint32
+	ids          []string
+}
+
+func NewDeviceManager(discover DeviceDiscovery) (*DeviceManager, error) {
+	ids, err := discover()
+	if err != nil {
+		return nil, errors.Wrap(err, "Device discovery failed")
+	}
+
+	if len(ids) == 0 {
+		return nil, fmt.Errorf("No devices found")
+	}
+
+	return &DeviceManager{
+		devSemaphore: semaphore.NewWeighted((int64)(len(ids))),
+		inUse:        make([]uint32, len(ids)),
+		ids:          ids,
+	}, nil
+}
+
+func (self *DeviceManager) NDevice() int {
+	return len(self.ids)
+}
+
+// Returns the CUDA_VISIBLE_DEVICES value for slot (empty for CPU-only slots)
+func (self *DeviceManager) DeviceId(slot int) string {
+	return self.ids[slot]
+}
+
+// Reserve a device slot, blocking until one is available or ctx is done.
+// Call Release() when finished.
+func (self *DeviceManager) Reserve(ctx context.Context) (slot int, err error) {
+	if err = self.devSemaphore.Acquire(ctx, 1); err != nil {
+		return -1, err
+	}
+
+	slot = -1
+	for i := 0; i < len(self.inUse); i++ {
+		success := atomic.CompareAndSwapUint32(&self.inUse[i], (uint32)(0), (uint32)(1))
+		if success {
+			slot = i
+			break
+		}
+	}
+
+	// The semaphore ensures the above loop will succeed. This check should
+	// never fail.
+	if slot == -1 {
+		self.devSemaphore.Release(1)
+		return slot, fmt.Errorf("Failed to find free device. This shouldn't happen!")
+	}
+
+	return slot, nil
+}
+
+func (self *DeviceManager) Release(slot int) {
+	atomic.StoreUint32(&self.inUse[slot], 0)
+	self.devSemaphore.Release(1)
+}
+
+// The process-wide device manager is created on first use
+var defaultDevices struct {
+	lock     sync.Mutex
+	discover DeviceDiscovery
+	mgr      *DeviceManager
+}
+
+// Change how the default device manager finds devices. This must be called
+// before the first call to DefaultDeviceManager() to have any effect.
+func SetDeviceDiscovery(discover DeviceDiscovery) {
+	defaultDevices.lock.Lock()
+	defer defaultDevices.lock.Unlock()
+
+	defaultDevices.discover = discover
+}
+
+// Returns the process-wide device manager, creating it if needed. Discovery is
+// configured by SetDeviceDiscovery() or the RADIXBENCH_DEVICES environment
+// variable (see ParseDeviceDiscovery), nvidia-smi is used by default.
+func DefaultDeviceManager() (*DeviceManager, error) {
+	defaultDevices.lock.Lock()
+	defer defaultDevices.lock.Unlock()
+
+	if defaultDevices.mgr != nil {
+		return defaultDevices.mgr, nil
+	}
+
+	discover := defaultDevices.discover
+	if discover == nil {
+		var err error
+		if discover, err = ParseDeviceDiscovery(os.Getenv("RADIXBENCH_DEVICES")); err != nil {
+			return nil, err
+		}
+	}
+
+	mgr, err := NewDeviceManager(discover)
+	if err != nil {
+		return nil, err
+	}
+	defaultDevices.mgr = mgr
+
+	return mgr, nil
+}
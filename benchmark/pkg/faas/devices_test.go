@@ -0,0 +1,77 @@
+package faas
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDeviceDiscovery(t *testing.T) {
+	discover, err := ParseDeviceDiscovery("3")
+	require.Nil(t, err, "Failed to parse fixed device count")
+	devs, err := discover()
+	require.Nil(t, err)
+	require.Equal(t, []string{"0", "1", "2"}, devs)
+
+	discover, err = ParseDeviceDiscovery("cpu:2")
+	require.Nil(t, err, "Failed to parse CPU slots")
+	devs, err = discover()
+	require.Nil(t, err)
+	require.Equal(t, []string{"", ""}, devs)
+
+	_, err = ParseDeviceDiscovery("cpu:0")
+	require.NotNil(t, err, "Accepted zero CPU slots")
+
+	_, err = ParseDeviceDiscovery("notADevice")
+	require.NotNil(t, err, "Accepted invalid device configuration")
+}
+
+func TestCudaVisibleDevicesDiscovery(t *testing.T) {
+	orig, wasSet := os.LookupEnv("CUDA_VISIBLE_DEVICES")
+	defer func() {
+		if wasSet {
+			os.Setenv("CUDA_VISIBLE_DEVICES", orig)
+		} else {
+			os.Unsetenv("CUDA_VISIBLE_DEVICES")
+		}
+	}()
+
+	os.Setenv("CUDA_VISIBLE_DEVICES", "2, 5,-1,3")
+	devs, err := CudaVisibleDevicesDiscovery()
+	require.Nil(t, err)
+	require.Equal(t, []string{"2", "5"}, devs)
+
+	os.Unsetenv("CUDA_VISIBLE_DEVICES")
+	_, err = CudaVisibleDevicesDiscovery()
+	require.NotNil(t, err, "Discovery succeeded without CUDA_VISIBLE_DEVICES")
+}
+
+func TestDeviceManager(t *testing.T) {
+	_, err := NewDeviceManager(FixedDiscovery(0))
+	require.NotNil(t, err, "Created manager without devices")
+
+	mgr, err := NewDeviceManager(FixedDiscovery(2))
+	require.Nil(t, err, "Failed to create device manager")
+	require.Equal(t, 2, mgr.NDevice())
+
+	slot0, err := mgr.Reserve(context.Background())
+	require.Nil(t, err)
+	slot1, err := mgr.Reserve(context.Background())
+	require.Nil(t, err)
+	require.NotEqual(t, slot0, slot1, "Same device reserved twice")
+	require.NotEqual(t, mgr.DeviceId(slot0), mgr.DeviceId(slot1))
+
+	// All devices are busy, this should block until the context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	_, err = mgr.Reserve(ctx)
+	require.NotNil(t, err, "Reserved more devices than exist")
+
+	mgr.Release(slot1)
+	slot, err := mgr.Reserve(context.Background())
+	require.Nil(t, err, "Couldn't reserve released device")
+	require.Equal(t, slot1, slot)
+}
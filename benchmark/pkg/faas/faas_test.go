@@ -11,6 +11,17 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// Get the invoker configured in the environment (see InvokerConfigFromEnv)
+func getEnvInvoker(t *testing.T, arrDir string) Invoker {
+	cfg := InvokerConfigFromEnv()
+	cfg.ArrDir = arrDir
+
+	fmt.Printf("Getting %v invoker\n", cfg.Type)
+	invoker, err := NewInvoker(cfg)
+	require.Nil(t, err, "Failed to create invoker")
+	return invoker
+}
+
 func TestFaasWorker(t *testing.T) {
 	tmpDir, err := ioutil.TempDir("", "radixSortFaasWorkerTest")
 	require.Nil(t, err, "Couldn't create temporary test directory")
@@ -19,12 +30,11 @@ func TestFaasWorker(t *testing.T) {
 	//Configure SRK
 	//OL will mount tmpDir to the FaaS worker so it can find the distributed arrays
 	os.Setenv("OL_SHARED_VOLUME", tmpDir)
-	fmt.Println("Getting SRK manager")
-	mgr := GetMgr()
-	defer mgr.Destroy()
+	invoker := getEnvInvoker(t, tmpDir)
+	defer invoker.Close()
 
 	arrFactory := data.NewFileArrayFactory(tmpDir)
-	worker := InitFaasWorker(mgr)
+	worker := InitFaasWorker(invoker)
 
 	sort.DistribWorkerTest(t, arrFactory, worker)
 }
@@ -37,12 +47,11 @@ func TestSortFaaS(t *testing.T) {
 	//Configure SRK
 	//OL will mount tmpDir to the FaaS worker so it can find the distributed arrays
 	os.Setenv("OL_SHARED_VOLUME", tmpDir)
-	fmt.Println("Getting SRK manager")
-	mgr := GetMgr()
-	defer mgr.Destroy()
+	invoker := getEnvInvoker(t, tmpDir)
+	defer invoker.Close()
 
 	arrFactory := data.NewFileArrayFactory(tmpDir)
-	worker := InitFaasWorker(mgr)
+	worker := InitFaasWorker(invoker)
 
 	sort.SortDistribTest(t, "testSortFaaS", arrFactory, worker)
 }
@@ -5,6 +5,7 @@ import (
 	"path/filepath"
 
 	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/sort"
 	"github.com/pkg/errors"
 )
 
@@ -17,19 +18,40 @@ type FaasFilePartRef struct {
 	NByte     int    `json:"nbyte"`
 }
 
+// Operations a worker can perform (FaasArg.Op)
+const (
+	SortOp  = "sort"
+	StatsOp = "stats"
+)
+
 // Argument expected by the radix sort function in SRK. See the faas
 // documentation for the meaning of these fields (faasTest/README.md)
 type FaasArg struct {
+	// One of the *Op constants, empty means SortOp
+	Op string `json:"op,omitempty"`
+
 	Offset  int                `json:"offset"`
 	Width   int                `json:"width"`
 	ArrType string             `json:"arrType"`
 	Input   []*FaasFilePartRef `json:"input"`
 	Output  string             `json:"output"`
+
+	// Element layout (see sort.ElemFormat). Zero values mean bare uint32
+	// keys for older requestors.
+	KeySize    int `json:"keySize,omitempty"`
+	RecordSize int `json:"recordSize,omitempty"`
+	KeyOffset  int `json:"keyOffset,omitempty"`
+
+	// Digits to count (StatsOp only)
+	Digits []sort.Digit `json:"digits,omitempty"`
 }
 
 type FaasResp struct {
 	Success bool   `json:"success"`
 	Err     string `json:"err"`
+
+	// Result of a StatsOp request
+	Stats *sort.ArrayStats `json:"stats,omitempty"`
 }
 
 // Convert a data.PartRef to FaasPartRef
@@ -48,13 +70,26 @@ func FilePartRefToFaas(ref *data.PartRef) (*FaasFilePartRef, error) {
 	return arg, nil
 }
 
+// Convert a list of data.PartRefs to FaasPartRefs
+func FilePartRefsToFaas(refs []*data.PartRef) ([]*FaasFilePartRef, error) {
+	faasRefs := make([]*FaasFilePartRef, len(refs))
+	for i, ref := range refs {
+		var err error
+		faasRefs[i], err = FilePartRefToFaas(ref)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Invalid input reference %v", i)
+		}
+	}
+	return faasRefs, nil
+}
+
 // Load a FaasFilePartRef into a local data.PartRef. localArrDir is the local mount
 // point for file distributed arrays (the directory shared between FaaS and
-// local for storing distributed arrays).
+// local for storing distributed arrays). The array is opened read-only.
 func LoadFaasFilePartRef(ref *FaasFilePartRef, localArrDir string) (*data.PartRef, error) {
 	localArrPath := filepath.Join(localArrDir, ref.ArrayName)
 
-	arr, err := data.OpenFileDistribArray(localArrPath)
+	arr, err := data.OpenFileDistribArrayReadOnly(localArrPath)
 	if err != nil {
 		return nil, errors.Wrap(err, "Failed to load referenced FileDistributedArray")
 	}
@@ -8,89 +8,184 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"sync/atomic"
+	"strings"
 
 	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
 	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/sort"
 	"github.com/pkg/errors"
 	"github.com/serverlessresearch/srk/pkg/srkmgr"
 	"github.com/sirupsen/logrus"
-	"golang.org/x/sync/semaphore"
 )
 
-type gpuReserver struct {
-	devSemaphore *semaphore.Weighted
-	devs         []uint32
-}
+// Creates a new srk manager (interface to SRK) using the srk config file at
+// configPath. Be sure to call mgr.Destroy() to clean up (failure to do so may
+// require manual cleanup for open-lambda)
+func NewMgr(configPath string) (*srkmgr.SrkManager, error) {
+	mgrArgs := map[string]interface{}{}
+	mgrArgs["config-file"] = configPath
+	srkLogger := logrus.New()
+	srkLogger.SetLevel(logrus.WarnLevel)
+	mgrArgs["logger"] = srkLogger
 
-func (self *gpuReserver) reserve() (devId int, err error) {
-	self.devSemaphore.Acquire(context.Background(), 1)
+	return srkmgr.NewManager(mgrArgs)
+}
 
-	devId = -1
-	for i := 0; i < len(self.devs); i++ {
-		success := atomic.CompareAndSwapUint32(&self.devs[i], (uint32)(0), (uint32)(1))
-		if success {
-			devId = i
-			break
-		}
+// Like NewMgr but uses ./srk.yaml and exits the process on failure
+func GetMgr() *srkmgr.SrkManager {
+	mgr, err := NewMgr("./srk.yaml")
+	if err != nil {
+		fmt.Printf("Failed to initialize: %v\n", err)
+		os.Exit(1)
 	}
+	return mgr
+}
 
-	// The semaphore ensures the above loop will succeed. This check should
-	// never fail.
-	if devId == -1 {
-		return devId, fmt.Errorf("Failed to find free device. This shouldn't happen!")
-	}
+// Sends requests to a FaaS sort worker (see faasTest/README.md for the
+// protocol). Implementations differ in how the worker is run.
+type Invoker interface {
+	// Run the worker on arg. A non-nil error means the worker could not be
+	// invoked (or its response couldn't be understood). Errors reported by the
+	// worker itself are returned in FaasResp.
+	Invoke(ctx context.Context, arg *FaasArg) (*FaasResp, error)
 
-	return devId, nil
+	// Release any resources held by the invoker
+	Close() error
 }
 
-func (self *gpuReserver) release(devId int) {
-	atomic.StoreUint32(&self.devs[devId], 0)
-	self.devSemaphore.Release(1)
+// Names for the different invokers (see InvokerConfig)
+const (
+	SrkInvokerType        = "srk"
+	SubprocessInvokerType = "subprocess"
+	InProcessInvokerType  = "inprocess"
+)
+
+// Describes which Invoker to use and how to configure it. Fields that don't
+// apply to the chosen type are ignored.
+type InvokerConfig struct {
+	// One of the *InvokerType constants
+	Type string
+
+	// SRK config file (srk)
+	SrkConfig string
+
+	// SRK function name (srk)
+	FuncName string
+
+	// Worker command and arguments (subprocess). Defaults to running
+	// faasTest/f.py with python3.
+	Command []string
+
+	// Local directory for file distributed arrays (subprocess, inprocess)
+	ArrDir string
+
+	// Name of the sort.LocalSorter to use (inprocess)
+	Sorter string
+
+	// Device configuration, see ParseDeviceDiscovery (subprocess). If empty,
+	// the DefaultDeviceManager() is used.
+	Devices string
 }
 
-var gpuManager gpuReserver
+// Build an InvokerConfig from the environment:
+//
+//	RADIXBENCH_INVOKER: Invoker type (default "subprocess")
+//	RADIXBENCH_SRKCONFIG: SRK config file (default "./srk.yaml")
+//	RADIXBENCH_WORKER_CMD: Space-separated worker command (default f.py)
+//	RADIXBENCH_SORTER: LocalSorter for the in-process invoker
+//	RADIXBENCH_DEVICES: Devices for the subprocess invoker
+//	OL_SHARED_VOLUME: Directory for file distributed arrays
+func InvokerConfigFromEnv() *InvokerConfig {
+	cfg := &InvokerConfig{
+		Type:      os.Getenv("RADIXBENCH_INVOKER"),
+		SrkConfig: os.Getenv("RADIXBENCH_SRKCONFIG"),
+		FuncName:  "radixsort",
+		Command:   strings.Fields(os.Getenv("RADIXBENCH_WORKER_CMD")),
+		ArrDir:    os.Getenv("OL_SHARED_VOLUME"),
+		Sorter:    os.Getenv("RADIXBENCH_SORTER"),
+		Devices:   os.Getenv("RADIXBENCH_DEVICES"),
+	}
 
-func init() {
-	// Determine the number of GPUs
-	cmd := exec.Command("nvidia-smi", "-L")
-	out, err := cmd.Output()
-	if err != nil {
-		panic(fmt.Sprintf("Error determining GPU count: %v", err))
+	if cfg.Type == "" {
+		cfg.Type = SubprocessInvokerType
 	}
+	if cfg.SrkConfig == "" {
+		cfg.SrkConfig = "./srk.yaml"
+	}
+	return cfg
+}
 
-	nDev := bytes.Count(out, []byte("\n"))
+// Create the Invoker described by cfg
+func NewInvoker(cfg *InvokerConfig) (Invoker, error) {
+	switch cfg.Type {
+	case SrkInvokerType:
+		mgr, err := NewMgr(cfg.SrkConfig)
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to initialize SRK")
+		}
 
-	gpuManager = gpuReserver{semaphore.NewWeighted((int64)(nDev)), make([]uint32, nDev)}
-}
+		funcName := cfg.FuncName
+		if funcName == "" {
+			funcName = "radixsort"
+		}
+		return &SrkInvoker{Mgr: mgr, FuncName: funcName}, nil
+
+	case SubprocessInvokerType:
+		command := cfg.Command
+		if len(command) == 0 {
+			rootPath := os.Getenv("RADIXBENCH_ROOTPATH")
+			if rootPath == "" {
+				return nil, fmt.Errorf("RADIXBENCH_ROOTPATH environment variable not set. Set this to the root of the gpu-radix-sort repo.")
+			}
+			command = []string{"python3", filepath.Join(rootPath, "faasTest/f.py")}
+		}
 
-// Creates a new srk manager (interface to SRK). Be sure to call mgr.Destroy()
-// to clean up (failure to do so may require manual cleanup for open-lambda)
-func GetMgr() *srkmgr.SrkManager {
-	mgrArgs := map[string]interface{}{}
-	mgrArgs["config-file"] = "./srk.yaml"
-	srkLogger := logrus.New()
-	srkLogger.SetLevel(logrus.WarnLevel)
-	mgrArgs["logger"] = srkLogger
+		var devices *DeviceManager
+		if cfg.Devices != "" {
+			discover, err := ParseDeviceDiscovery(cfg.Devices)
+			if err != nil {
+				return nil, err
+			}
+			if devices, err = NewDeviceManager(discover); err != nil {
+				return nil, err
+			}
+		}
+		return &SubprocessInvoker{Command: command, ArrDir: cfg.ArrDir, Devices: devices}, nil
+
+	case InProcessInvokerType:
+		sorter := sort.DefaultLocalSorter()
+		if cfg.Sorter != "" {
+			var err error
+			if sorter, err = sort.GetLocalSorter(cfg.Sorter); err != nil {
+				return nil, err
+			}
+		}
+		return &InProcessInvoker{ArrDir: cfg.ArrDir, Sorter: sorter}, nil
 
-	mgr, err := srkmgr.NewManager(mgrArgs)
-	if err != nil {
-		fmt.Printf("Failed to initialize: %v\n", err)
-		os.Exit(1)
+	default:
+		return nil, fmt.Errorf("Unrecognized invoker type: %v", cfg.Type)
 	}
-	return mgr
 }
 
-func InvokeFaasSort(mgr *srkmgr.SrkManager, arg *FaasArg) error {
+// Invoke the worker through SRK
+type SrkInvoker struct {
+	Mgr      *srkmgr.SrkManager
+	FuncName string
+}
+
+func (self *SrkInvoker) Invoke(ctx context.Context, arg *FaasArg) (*FaasResp, error) {
+	// SRK doesn't support cancellation, the best we can do is not start
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	jsonArg, err := json.Marshal(arg)
 	if err != nil {
-		return errors.Wrap(err, "Failed to marshal FaaS argument")
+		return nil, errors.Wrap(err, "Failed to marshal FaaS argument")
 	}
 
-	rawResp, err := mgr.Provider.Faas.Invoke("radixsort", string(jsonArg))
+	rawResp, err := self.Mgr.Provider.Faas.Invoke(self.FuncName, string(jsonArg))
 	if err != nil {
-		return fmt.Errorf("Failed to invoke function: %v\n", err)
+		return nil, errors.Wrap(err, "Failed to invoke function")
 	}
 
 	respBytes := rawResp.Bytes()
@@ -98,89 +193,118 @@ func InvokeFaasSort(mgr *srkmgr.SrkManager, arg *FaasArg) error {
 	var resp FaasResp
 	err = json.Unmarshal(respBytes, &resp)
 	if err != nil {
-		return errors.Wrapf(err, "Couldn't parse function response: %v", string(respBytes))
+		return nil, errors.Wrapf(err, "Couldn't parse function response: %v", string(respBytes))
 	}
 
-	if !resp.Success {
-		return fmt.Errorf("Remote function error: %v", resp.Err)
-	}
+	return &resp, nil
+}
 
+func (self *SrkInvoker) Close() error {
+	self.Mgr.Destroy()
 	return nil
 }
 
-// Run the function as a local process instead of through SRK. This is kind of
-// a hack and is fragile, ideally we'd get something close to this in SRK and
-// avoid the hacks here. Arguments are passed through the command line args,
-// keep them under your system limit (typically 2MB).
-//
-// You muxt set RADIXBENCH_ROOTPATH to the root dir of the gpu-radix-sort repo
-// in your environment for this to work properly (source env.sh).
-func InvokeFaasDirect(arg *FaasArg) error {
-	devId, err := gpuManager.reserve()
-	if err != nil {
-		return errors.Wrap(err, "Failed to find free GPU")
+// Run the function as a local process instead of through FaaS. Each
+// invocation reserves a device (GPUs are exposed to the worker through
+// CUDA_VISIBLE_DEVICES). The request is passed on stdin and the response is
+// read from stdout, like f.py's directInvoke().
+type SubprocessInvoker struct {
+	// Worker command and arguments
+	Command []string
+
+	// Passed to the worker as OL_SHARED_VOLUME (if set)
+	ArrDir string
+
+	// Devices to run workers on, DefaultDeviceManager() is used if nil
+	Devices *DeviceManager
+}
+
+func (self *SubprocessInvoker) Invoke(ctx context.Context, arg *FaasArg) (*FaasResp, error) {
+	if len(self.Command) == 0 {
+		return nil, fmt.Errorf("No worker command provided")
 	}
-	defer gpuManager.release(devId)
 
-	rootPath := os.Getenv("RADIXBENCH_ROOTPATH")
-	if rootPath == "" {
-		return fmt.Errorf("RADIXBENC_ROOTPATH environment variable not set. Set this to the root of the gpu-radix-sort repo.")
+	devices := self.Devices
+	if devices == nil {
+		var err error
+		if devices, err = DefaultDeviceManager(); err != nil {
+			return nil, errors.Wrap(err, "Failed to initialize devices")
+		}
 	}
 
-	jsonArg, err := json.Marshal(arg)
+	slot, err := devices.Reserve(ctx)
 	if err != nil {
-		return errors.Wrap(err, "Failed to marshal FaaS argument")
+		return nil, errors.Wrap(err, "Failed to find free device")
 	}
+	defer devices.Release(slot)
 
-	funcPath := filepath.Join(rootPath, "faasTest/f.py")
-	cmd := exec.Command("python3", funcPath)
-
-	cmd.Env = append(os.Environ(),
-		fmt.Sprintf("CUDA_VISIBLE_DEVICES=%v", devId))
-
-	cmdIn, err := cmd.StdinPipe()
+	jsonArg, err := json.Marshal(arg)
 	if err != nil {
-		return errors.Wrap(err, "Couldn't get stdin pipe for worker process")
+		return nil, errors.Wrap(err, "Failed to marshal FaaS argument")
 	}
 
-	go func() {
-		defer cmdIn.Close()
-		cmdIn.Write(jsonArg)
-	}()
-
-	out, err := cmd.Output()
-	if err != nil {
-		if exitErr, ok := err.(*exec.ExitError); ok {
-			return fmt.Errorf("Worker returned error: %s", exitErr.Stderr)
-		}
+	cmd := exec.CommandContext(ctx, self.Command[0], self.Command[1:]...)
 
-		return errors.Wrapf(err, "Failed to invoke worker")
+	cmd.Env = os.Environ()
+	if devId := devices.DeviceId(slot); devId != "" {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("CUDA_VISIBLE_DEVICES=%v", devId))
 	}
+	if self.ArrDir != "" {
+		cmd.Env = append(cmd.Env, "OL_SHARED_VOLUME="+self.ArrDir)
+	}
+
+	cmd.Stdin = bytes.NewReader(jsonArg)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	// Workers exit with an error if the sort fails, but they still print a
+	// response so we only fail here if there isn't one.
+	out, runErr := cmd.Output()
 
 	var resp FaasResp
 	err = json.Unmarshal(out, &resp)
 	if err != nil {
-		return errors.Wrapf(err, "Couldn't parse function response: %q", out)
+		if runErr != nil {
+			return nil, errors.Wrapf(runErr, "Failed to invoke worker: %s", stderr.Bytes())
+		}
+		return nil, errors.Wrapf(err, "Couldn't parse function response: %q", out)
 	}
 
-	if !resp.Success {
-		return fmt.Errorf("Remote function error: %v", resp.Err)
+	return &resp, nil
+}
+
+func (self *SubprocessInvoker) Close() error {
+	return nil
+}
+
+// Run the Go worker (see HandleFaasRequest) in the current process. This is
+// mostly useful for testing the FaaS code paths without any external
+// dependencies.
+type InProcessInvoker struct {
+	ArrDir string
+	Sorter sort.LocalSorter
+}
+
+func (self *InProcessInvoker) Invoke(ctx context.Context, arg *FaasArg) (*FaasResp, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
 	}
+	return HandleFaasRequest(arg, self.ArrDir, self.Sorter), nil
+}
 
+func (self *InProcessInvoker) Close() error {
 	return nil
 }
 
-// Returns a DistribWorker that uses mgr to sort via FaaS
-func InitFaasWorker(mgr *srkmgr.SrkManager) sort.DistribWorker {
-	return func(inBkts []*data.PartRef,
-		offset int, width int, baseName string,
+// Returns a DistribWorker that sorts via FaaS using invoker
+func InitFaasWorker(invoker Invoker) sort.DistribWorker {
+	return func(ctx context.Context, inBkts []*data.PartRef,
+		offset int, width int, format sort.ElemFormat, baseName string,
 		factory *data.ArrayFactory) (data.DistribArray, error) {
 
-		var err error
-
-		faasRefs := make([]*FaasFilePartRef, len(inBkts))
-		for i, bktRef := range inBkts {
-			faasRefs[i], err = FilePartRefToFaas(bktRef)
+		faasRefs, err := FilePartRefsToFaas(inBkts)
+		if err != nil {
+			return nil, err
 		}
 
 		faasArg := &FaasArg{
@@ -189,18 +313,19 @@ func InitFaasWorker(mgr *srkmgr.SrkManager) sort.DistribWorker {
 			ArrType: "file",
 			Input:   faasRefs,
 			Output:  baseName + "_output",
-		}
 
-		// err = InvokeFaasSort(mgr, faasArg)
+			KeySize:    format.KeySize,
+			RecordSize: format.RecordSize,
+			KeyOffset:  format.KeyOffset,
+		}
 
-		// OL has been giving us some trouble (numpy not importing correctly),
-		// we're calling directly for now. Technically we don't need mgr, but
-		// I'm lazy and don't feel like refactoring code just for a hack. We
-		// can create new code paths if we need. mgr can be nil in this mode.
-		err = InvokeFaasDirect(faasArg)
+		resp, err := invoker.Invoke(ctx, faasArg)
 		if err != nil {
 			return nil, errors.Wrap(err, "FaaS sort failure")
 		}
+		if !resp.Success {
+			return nil, fmt.Errorf("Remote function error: %v", resp.Err)
+		}
 
 		outArr, err := factory.Open(baseName + "_output")
 		if err != nil {
@@ -210,3 +335,39 @@ func InitFaasWorker(mgr *srkmgr.SrkManager) sort.DistribWorker {
 		return outArr, nil
 	}
 }
+
+// Returns a StatsWorker that computes statistics via FaaS using invoker
+func InitFaasStatsWorker(invoker Invoker) sort.StatsWorker {
+	return func(ctx context.Context, inBkts []*data.PartRef, digits []sort.Digit,
+		format sort.ElemFormat) (*sort.ArrayStats, error) {
+
+		faasRefs, err := FilePartRefsToFaas(inBkts)
+		if err != nil {
+			return nil, err
+		}
+
+		faasArg := &FaasArg{
+			Op:      StatsOp,
+			ArrType: "file",
+			Input:   faasRefs,
+			Digits:  digits,
+
+			KeySize:    format.KeySize,
+			RecordSize: format.RecordSize,
+			KeyOffset:  format.KeyOffset,
+		}
+
+		resp, err := invoker.Invoke(ctx, faasArg)
+		if err != nil {
+			return nil, errors.Wrap(err, "FaaS stats failure")
+		}
+		if !resp.Success {
+			return nil, fmt.Errorf("Remote function error: %v", resp.Err)
+		}
+		if resp.Stats == nil {
+			return nil, fmt.Errorf("Remote function didn't return stats")
+		}
+
+		return resp.Stats, nil
+	}
+}
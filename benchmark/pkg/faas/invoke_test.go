@@ -0,0 +1,161 @@
+package faas
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/sort"
+	"github.com/stretchr/testify/require"
+)
+
+// Not a real test. This lets the test binary act as a subprocess worker (see
+// helperWorkerCommand).
+func TestHelperWorkerProcess(t *testing.T) {
+	if os.Getenv("RADIXBENCH_HELPER_WORKER") != "1" {
+		return
+	}
+
+	sorter, err := sort.GetLocalSorter(sort.GoSorterName)
+	if err != nil {
+		os.Exit(2)
+	}
+
+	resp := ServeFaasRequest(os.Stdin, os.Stdout, os.Getenv("OL_SHARED_VOLUME"), sorter)
+	if !resp.Success {
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
+// A worker command that re-runs the test binary as a Go FaaS worker
+func helperWorkerCommand() []string {
+	return []string{"env", "RADIXBENCH_HELPER_WORKER=1", os.Args[0], "-test.run=TestHelperWorkerProcess"}
+}
+
+func testInvoker(t *testing.T, cfg *InvokerConfig) {
+	t.Run("DistribWorker", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "radixSortInvokerTest")
+		require.Nil(t, err, "Couldn't create temporary test directory")
+		defer os.RemoveAll(tmpDir)
+
+		cfg.ArrDir = tmpDir
+		invoker, err := NewInvoker(cfg)
+		require.Nil(t, err, "Failed to create invoker")
+		defer invoker.Close()
+
+		sort.DistribWorkerTest(t, data.NewFileArrayFactory(tmpDir), InitFaasWorker(invoker))
+	})
+
+	t.Run("SortDistrib", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "radixSortInvokerTest")
+		require.Nil(t, err, "Couldn't create temporary test directory")
+		defer os.RemoveAll(tmpDir)
+
+		cfg.ArrDir = tmpDir
+		invoker, err := NewInvoker(cfg)
+		require.Nil(t, err, "Failed to create invoker")
+		defer invoker.Close()
+
+		sort.SortDistribTest(t, "testInvokerSort", data.NewFileArrayFactory(tmpDir), InitFaasWorker(invoker))
+	})
+
+	// The element format must make it through the protocol
+	t.Run("SortDistribRecords", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "radixSortInvokerTest")
+		require.Nil(t, err, "Couldn't create temporary test directory")
+		defer os.RemoveAll(tmpDir)
+
+		cfg.ArrDir = tmpDir
+		invoker, err := NewInvoker(cfg)
+		require.Nil(t, err, "Failed to create invoker")
+		defer invoker.Close()
+
+		opts := sort.DefaultSortOptions()
+		opts.KeySize = 8
+		opts.RecordSize = 20
+		opts.KeyOffset = 4
+		sort.SortDistribOptsTest(t, "testInvokerSortRecords", data.NewFileArrayFactory(tmpDir), InitFaasWorker(invoker), opts)
+	})
+
+	// The histogram pre-pass runs on the same workers as ArrayStatsFromArr
+	t.Run("SortDistribHistogram", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "radixSortInvokerTest")
+		require.Nil(t, err, "Couldn't create temporary test directory")
+		defer os.RemoveAll(tmpDir)
+
+		cfg.ArrDir = tmpDir
+		invoker, err := NewInvoker(cfg)
+		require.Nil(t, err, "Failed to create invoker")
+		defer invoker.Close()
+
+		opts := sort.DefaultSortOptions()
+		opts.Histogram = InitFaasStatsWorker(invoker)
+		sort.SortDistribOptsTest(t, "testInvokerSortHist", data.NewFileArrayFactory(tmpDir), InitFaasWorker(invoker), opts)
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "radixSortInvokerTest")
+		require.Nil(t, err, "Couldn't create temporary test directory")
+		defer os.RemoveAll(tmpDir)
+
+		cfg.ArrDir = tmpDir
+		invoker, err := NewInvoker(cfg)
+		require.Nil(t, err, "Failed to create invoker")
+		defer invoker.Close()
+
+		sort.StatsWorkerTest(t, data.NewFileArrayFactory(tmpDir), InitFaasStatsWorker(invoker))
+	})
+}
+
+func TestInProcessInvoker(t *testing.T) {
+	testInvoker(t, &InvokerConfig{Type: InProcessInvokerType, Sorter: sort.GoSorterName})
+}
+
+func TestSubprocessInvoker(t *testing.T) {
+	testInvoker(t, &InvokerConfig{Type: SubprocessInvokerType, Command: helperWorkerCommand(), Devices: "cpu:2"})
+}
+
+// Worker failures must be reported as errors by the DistribWorker
+func TestInvokerWorkerFailure(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortInvokerTest")
+	require.Nil(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	invoker, err := NewInvoker(&InvokerConfig{Type: SubprocessInvokerType, Command: helperWorkerCommand(), ArrDir: tmpDir, Devices: "cpu"})
+	require.Nil(t, err, "Failed to create invoker")
+
+	// The referenced array doesn't exist
+	arg := &FaasArg{Offset: 0, Width: 4, ArrType: "file", Output: "out",
+		Input: []*FaasFilePartRef{&FaasFilePartRef{ArrayName: "missing", PartId: 0, Start: 0, NByte: 4}}}
+
+	resp, err := invoker.Invoke(context.Background(), arg)
+	require.Nil(t, err, "Invoker failed")
+	require.False(t, resp.Success, "Worker didn't report failure")
+	require.NotEmpty(t, resp.Err, "Worker didn't report an error message")
+}
+
+func TestNewInvokerConfig(t *testing.T) {
+	_, err := NewInvoker(&InvokerConfig{Type: "notARealInvoker"})
+	require.NotNil(t, err, "Accepted unknown invoker type")
+
+	_, err = NewInvoker(&InvokerConfig{Type: InProcessInvokerType, Sorter: "notARealSorter"})
+	require.NotNil(t, err, "Accepted unknown sorter")
+
+	os.Setenv("RADIXBENCH_INVOKER", InProcessInvokerType)
+	os.Setenv("RADIXBENCH_SORTER", sort.GoSorterName)
+	defer os.Unsetenv("RADIXBENCH_INVOKER")
+	defer os.Unsetenv("RADIXBENCH_SORTER")
+
+	cfg := InvokerConfigFromEnv()
+	require.Equal(t, InProcessInvokerType, cfg.Type, "Invoker type not read from environment")
+
+	invoker, err := NewInvoker(cfg)
+	require.Nil(t, err, "Failed to create invoker from environment")
+
+	inProc, ok := invoker.(*InProcessInvoker)
+	require.True(t, ok, "Created wrong invoker type %T", invoker)
+	require.Equal(t, sort.GoSorterName, inProc.Sorter.Name(), "Sorter not read from environment")
+}
@@ -0,0 +1,135 @@
+package faas
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/sort"
+	"github.com/pkg/errors"
+)
+
+// This is a Go implementation of the FaaS sort worker (faasTest/f.py). It
+// speaks the same protocol (see faasTest/README.md) but sorts using any
+// sort.LocalSorter and doesn't need python or numpy.
+
+func errResp(err error) *FaasResp {
+	return &FaasResp{Success: false, Err: err.Error()}
+}
+
+// Handle a single request of any type (see FaasArg.Op). arrDir is the local
+// mount point for file distributed arrays (the directory shared between FaaS
+// and the requestor).
+func HandleFaasRequest(arg *FaasArg, arrDir string, sorter sort.LocalSorter) *FaasResp {
+	switch arg.Op {
+	case "", SortOp:
+		return HandleFaasSort(arg, arrDir, sorter)
+	case StatsOp:
+		return HandleFaasStats(arg, arrDir)
+	default:
+		return errResp(fmt.Errorf("Unrecognized operation: %v", arg.Op))
+	}
+}
+
+// Open the inputs of arg. The caller must close the returned arrays.
+func loadFaasInputs(arg *FaasArg, arrDir string) ([]*data.PartRef, error) {
+	if arg.ArrType != "file" {
+		return nil, fmt.Errorf("Worker currently only supports file distributed arrays")
+	}
+
+	refs := make([]*data.PartRef, 0, len(arg.Input))
+	for i, faasRef := range arg.Input {
+		ref, err := LoadFaasFilePartRef(faasRef, arrDir)
+		if err != nil {
+			closeFaasInputs(refs)
+			return nil, errors.Wrapf(err, "Failed to load input %v", i)
+		}
+		refs = append(refs, ref)
+	}
+	return refs, nil
+}
+
+func closeFaasInputs(refs []*data.PartRef) {
+	for _, ref := range refs {
+		ref.Arr.Close()
+	}
+}
+
+// The element format requested by arg
+func faasFormat(arg *FaasArg) sort.ElemFormat {
+	format := sort.ElemFormat{KeySize: arg.KeySize, RecordSize: arg.RecordSize, KeyOffset: arg.KeyOffset}
+	if format.KeySize == 0 {
+		format.KeySize = 4
+	}
+	return format
+}
+
+// Handle a single sort request (see HandleFaasRequest)
+func HandleFaasSort(arg *FaasArg, arrDir string, sorter sort.LocalSorter) *FaasResp {
+	refs, err := loadFaasInputs(arg, arrDir)
+	if err != nil {
+		return errResp(err)
+	}
+	defer closeFaasInputs(refs)
+
+	format := faasFormat(arg)
+
+	factory := data.NewFileArrayFactory(arrDir)
+	outArr, err := sort.LocalSortPartial(sorter, refs, arg.Offset, arg.Width, format, arg.Output, factory)
+	if err != nil {
+		return errResp(errors.Wrap(err, "Sort failed"))
+	}
+
+	if err = outArr.Close(); err != nil {
+		return errResp(errors.Wrap(err, "Failed to commit output"))
+	}
+
+	return &FaasResp{Success: true, Err: ""}
+}
+
+// Handle a single stats request (see HandleFaasRequest). The inputs are only
+// read.
+func HandleFaasStats(arg *FaasArg, arrDir string) *FaasResp {
+	refs, err := loadFaasInputs(arg, arrDir)
+	if err != nil {
+		return errResp(err)
+	}
+	defer closeFaasInputs(refs)
+
+	inBytes, err := data.FetchPartRefs(refs)
+	if err != nil {
+		return errResp(errors.Wrap(err, "Couldn't read input references"))
+	}
+
+	stats, err := sort.CpuStatsFormat(inBytes, arg.Digits, faasFormat(arg))
+	if err != nil {
+		return errResp(errors.Wrap(err, "Stats failed"))
+	}
+
+	return &FaasResp{Success: true, Err: "", Stats: stats}
+}
+
+// Read a JSON-encoded FaasArg from in, handle it, and write the JSON-encoded
+// FaasResp to out. This is the equivalent of f.py's directInvoke(). The
+// response is also returned.
+func ServeFaasRequest(in io.Reader, out io.Writer, arrDir string, sorter sort.LocalSorter) *FaasResp {
+	var resp *FaasResp
+
+	var arg FaasArg
+	if err := json.NewDecoder(in).Decode(&arg); err != nil {
+		resp = errResp(errors.Wrap(err, "Argument parsing error"))
+	} else {
+		resp = HandleFaasRequest(&arg, arrDir, sorter)
+	}
+
+	respBytes, err := json.Marshal(resp)
+	if err != nil {
+		// This really shouldn't happen
+		respBytes = []byte(`{"success": false, "err": "Failed to encode response"}`)
+	}
+	out.Write(respBytes)
+	out.Write([]byte("\n"))
+
+	return resp
+}
@@ -0,0 +1,126 @@
+package faas
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/sort"
+	"github.com/stretchr/testify/require"
+)
+
+func TestServeFaasRequest(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortFaasServeTest")
+	require.Nil(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	sorter, err := sort.GetLocalSorter(sort.GoSorterName)
+	require.Nil(t, err, "Couldn't get local sorter")
+
+	nElem := 1021
+	nByte := nElem * 4
+	width := 4
+
+	origRaw, err := sort.CpuGenerateInputs((uint64)(nElem))
+	require.Nil(t, err, "Failed to generate test inputs")
+
+	factory := data.NewFileArrayFactory(tmpDir)
+	inArr, err := factory.Create("input", data.CreateShapeUniform((int64)(nByte), 1))
+	require.Nil(t, err, "Failed to create input array")
+
+	writer, err := inArr.GetPartWriter(0)
+	require.Nil(t, err, "Failed to get writer")
+	_, err = writer.Write(origRaw)
+	require.Nil(t, err, "Failed to write input")
+	writer.Close()
+	require.Nil(t, inArr.Close(), "Failed to commit input")
+
+	// Split the input unevenly to make sure refs are handled properly
+	splitPoint := (nElem / 3) * 4
+	localRefs := []*data.PartRef{
+		&data.PartRef{Arr: inArr, PartIdx: 0, Start: 0, NByte: splitPoint},
+		&data.PartRef{Arr: inArr, PartIdx: 0, Start: splitPoint, NByte: nByte - splitPoint},
+	}
+
+	arg := &FaasArg{Offset: 0, Width: width, ArrType: "file", Output: "output"}
+	for _, ref := range localRefs {
+		faasRef, err := FilePartRefToFaas(ref)
+		require.Nil(t, err, "Failed to convert PartRef")
+		arg.Input = append(arg.Input, faasRef)
+	}
+
+	t.Run("Sort", func(t *testing.T) {
+		jsonArg, err := json.Marshal(arg)
+		require.Nil(t, err, "Failed to marshal argument")
+
+		var out bytes.Buffer
+		resp := ServeFaasRequest(bytes.NewReader(jsonArg), &out, tmpDir, sorter)
+		require.Truef(t, resp.Success, "Worker failed: %v", resp.Err)
+
+		var jsonResp FaasResp
+		err = json.Unmarshal(out.Bytes(), &jsonResp)
+		require.Nil(t, err, "Worker returned invalid JSON")
+		require.Equal(t, *resp, jsonResp, "Printed response doesn't match returned response")
+
+		outArr, err := factory.Open("output")
+		require.Nil(t, err, "Couldn't open output array")
+
+		shape, err := outArr.GetShape()
+		require.Nil(t, err, "Couldn't get output shape")
+		require.Equal(t, 1<<width, shape.NPart(), "Output has wrong number of partitions")
+
+		err = sort.CheckPartialArray(outArr, 0, width)
+		require.Nilf(t, err, "Output not sorted correctly: %v", err)
+		outArr.Destroy()
+	})
+
+	t.Run("BadArrType", func(t *testing.T) {
+		badArg := *arg
+		badArg.ArrType = "mem"
+		resp := HandleFaasSort(&badArg, tmpDir, sorter)
+		require.False(t, resp.Success, "Worker accepted unsupported array type")
+	})
+
+	t.Run("Stats", func(t *testing.T) {
+		statsArg := *arg
+		statsArg.Op = StatsOp
+		statsArg.Output = ""
+		statsArg.Digits = []sort.Digit{{Offset: 0, Width: 4}, {Offset: 28, Width: 4}}
+		jsonArg, err := json.Marshal(&statsArg)
+		require.Nil(t, err, "Failed to marshal argument")
+
+		var out bytes.Buffer
+		resp := ServeFaasRequest(bytes.NewReader(jsonArg), &out, tmpDir, sorter)
+		require.Truef(t, resp.Success, "Worker failed: %v", resp.Err)
+
+		var jsonResp FaasResp
+		err = json.Unmarshal(out.Bytes(), &jsonResp)
+		require.Nil(t, err, "Worker returned invalid JSON")
+		require.Equal(t, *resp, jsonResp, "Printed response doesn't match returned response")
+
+		expected, err := sort.CpuStatsFormat(origRaw, statsArg.Digits, sort.Uint32Format)
+		require.Nil(t, err, "Couldn't compute expected stats")
+		require.Equal(t, expected, jsonResp.Stats, "Wrong stats")
+
+		_, err = factory.Open("output")
+		require.NotNil(t, err, "Stats request wrote an output")
+	})
+
+	t.Run("BadOp", func(t *testing.T) {
+		badArg := *arg
+		badArg.Op = "shuffle"
+		resp := HandleFaasRequest(&badArg, tmpDir, sorter)
+		require.False(t, resp.Success, "Worker accepted unknown operation")
+	})
+
+	t.Run("BadJSON", func(t *testing.T) {
+		var out bytes.Buffer
+		resp := ServeFaasRequest(bytes.NewReader([]byte("{not json")), &out, tmpDir, sorter)
+		require.False(t, resp.Success, "Worker accepted malformed request")
+	})
+
+	inArr.Destroy()
+}
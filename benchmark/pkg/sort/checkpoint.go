@@ -0,0 +1,172 @@
+package sort
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/pkg/errors"
+)
+
+// Progress of a distributed sort. When SortOptions.CheckpointDir is set, this
+// is written to the checkpoint directory after every pass so that the sort can
+// be restarted with ResumeSort() if the driver dies.
+type SortManifest struct {
+	BaseName string
+
+	// Number of bytes being sorted
+	Size int
+
+	// Name of the array the sort started from. Empty if the input belongs to
+	// the caller (SortDistribFromArr).
+	Input string
+
+	// Number of passes that have completed
+	NStep int
+
+	// Passes that the histogram pre-pass found to be unnecessary (see
+	// SortOptions.Histogram). Nil if there was no pre-pass.
+	Skipped []int
+
+	// Names of the arrays output by the last completed pass
+	Outputs []string
+
+	Options *SortOptions
+}
+
+// Location of the manifest for sort baseName in dir
+func ManifestPath(dir string, baseName string) string {
+	return filepath.Join(dir, baseName+"_manifest.json")
+}
+
+// Read the manifest for sort baseName from dir
+func LoadSortManifest(dir string, baseName string) (*SortManifest, error) {
+	raw, err := ioutil.ReadFile(ManifestPath(dir, baseName))
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read sort manifest")
+	}
+
+	var manifest SortManifest
+	if err = json.Unmarshal(raw, &manifest); err != nil {
+		return nil, errors.Wrap(err, "Failed to parse sort manifest")
+	}
+
+	if manifest.Options == nil {
+		return nil, fmt.Errorf("Sort manifest is missing options")
+	}
+	if err = manifest.Options.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Sort manifest has invalid options")
+	}
+
+	return &manifest, nil
+}
+
+// Write the manifest to opts.CheckpointDir (if set). The manifest is replaced
+// atomically, a crash while checkpointing leaves the previous checkpoint.
+func (self *SortManifest) commit() error {
+	dir := self.Options.CheckpointDir
+	if dir == "" {
+		return nil
+	}
+
+	raw, err := json.Marshal(self)
+	if err != nil {
+		return errors.Wrap(err, "Failed to encode sort manifest")
+	}
+
+	tmpFile, err := ioutil.TempFile(dir, self.BaseName+"_manifest")
+	if err != nil {
+		return errors.Wrap(err, "Failed to create sort manifest")
+	}
+
+	if _, err = tmpFile.Write(raw); err == nil {
+		err = tmpFile.Sync()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmpFile.Name(), ManifestPath(dir, self.BaseName))
+	}
+	if err != nil {
+		os.Remove(tmpFile.Name())
+		return errors.Wrap(err, "Failed to write sort manifest")
+	}
+
+	return nil
+}
+
+// Remove the manifest from opts.CheckpointDir (e.g. because the sort finished)
+func (self *SortManifest) remove() error {
+	dir := self.Options.CheckpointDir
+	if dir == "" {
+		return nil
+	}
+
+	err := os.Remove(ManifestPath(dir, self.BaseName))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.Wrap(err, "Failed to remove sort manifest")
+	}
+	return nil
+}
+
+// Continue a checkpointed sort (see SortOptions.CheckpointDir) from the last
+// completed pass. The sort's arrays are reopened with factory, which must be
+// equivalent to the one used by the original sort. Returns the sorted output
+// like SortDistribFromArr().
+func ResumeSort(ctx context.Context, checkpointDir string, baseName string,
+	factory *data.ArrayFactory, worker DistribWorker) ([]data.DistribArray, error) {
+
+	manifest, err := LoadSortManifest(checkpointDir, baseName)
+	if err != nil {
+		return nil, err
+	}
+	manifest.Options.CheckpointDir = checkpointDir
+
+	tracker := newArrayTracker(factory)
+
+	arrs := make([]data.DistribArray, len(manifest.Outputs))
+	for i, name := range manifest.Outputs {
+		if name == "" {
+			return nil, fmt.Errorf("Can't resume %v before its first pass, the input belongs to the caller", baseName)
+		}
+		if arrs[i], err = factory.Open(name); err != nil {
+			return nil, errors.Wrapf(err, "Failed to open checkpointed array %v", name)
+		}
+	}
+
+	// The interrupted pass may have left outputs behind
+	nworker := manifest.Options.nWorker(manifest.Size)
+	for workerId := 0; workerId < nworker; workerId++ {
+		task := taskName(baseName, manifest.NStep, workerId)
+		for attempt := 0; attempt <= manifest.Options.MaxAttempts; attempt++ {
+			tracker.add(attemptName(task, attempt)+"_output", nil)
+		}
+	}
+	if err = tracker.destroyAll(); err != nil {
+		return nil, errors.Wrap(err, "Failed to clean up interrupted pass")
+	}
+	for i, name := range manifest.Outputs {
+		tracker.add(name, arrs[i])
+	}
+
+	outputs, err := sortDistrib(ctx, arrs, manifest, tracker, worker)
+	if err != nil {
+		return nil, cleanupFailedSort(err, tracker, manifest)
+	}
+
+	// SortDistribFromRaw would normally take care of this
+	if manifest.Input != "" && manifest.Options.Cleanup == CleanupIntermediate {
+		if inArr, err := factory.Open(manifest.Input); err == nil {
+			if err = inArr.Destroy(); err != nil {
+				return outputs, errors.Wrap(err, "Failed to destroy sort input")
+			}
+		}
+	}
+
+	return outputs, nil
+}
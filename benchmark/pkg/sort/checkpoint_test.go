@@ -0,0 +1,95 @@
+package sort
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortCheckpointResume(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortCheckpointTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	factory := data.NewFileArrayFactory(tmpDir)
+
+	origRaw, err := CpuGenerateInputs(1111)
+	require.Nil(t, err, "Failed to generate test inputs")
+
+	opts := DefaultSortOptions()
+	opts.CheckpointDir = tmpDir
+	// Simulates the driver dying, the arrays and manifest must survive
+	opts.KeepOnError = true
+
+	_, err = SortDistribFromRaw(context.Background(), origRaw, "testCheckpoint", factory, failingWorker("step2_worker0"), opts)
+	require.NotNil(t, err, "Sort succeeded with a failing worker")
+
+	manifest, err := LoadSortManifest(tmpDir, "testCheckpoint")
+	require.Nil(t, err, "Couldn't load manifest")
+	require.Equal(t, 2, manifest.NStep, "Checkpoint at wrong step")
+	require.Equal(t, opts.NWorker, len(manifest.Outputs), "Wrong number of checkpointed outputs")
+	require.Equal(t, opts.Width, manifest.Options.Width, "Options not checkpointed")
+
+	var lock sync.Mutex
+	var steps []string
+	worker := func(ctx context.Context, inBkts []*data.PartRef, offset int, width int, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+		lock.Lock()
+		steps = append(steps, baseName)
+		lock.Unlock()
+		return LocalDistribWorker(ctx, inBkts, offset, width, format, baseName, factory)
+	}
+
+	outArrs, err := ResumeSort(context.Background(), tmpDir, "testCheckpoint", factory, worker)
+	require.Nil(t, err, "Failed to resume sort")
+
+	for _, name := range steps {
+		require.Falsef(t, strings.Contains(name, "step0") || strings.Contains(name, "step1"), "Resumed sort repeated %v", name)
+	}
+
+	reader, err := NewBucketReader(outArrs, STRIDED)
+	require.Nil(t, err, "Couldn't read output")
+	outRaw := make([]byte, len(origRaw))
+	_, err = bucketRead(reader, outRaw)
+	require.Nil(t, err, "Couldn't read output")
+	require.Nil(t, CheckSort(origRaw, outRaw), "Resumed sort was wrong")
+
+	for _, arr := range outArrs {
+		require.Nil(t, arr.Destroy())
+	}
+
+	_, err = LoadSortManifest(tmpDir, "testCheckpoint")
+	require.NotNil(t, err, "Manifest not removed after sort finished")
+
+	entries, err := ioutil.ReadDir(tmpDir)
+	require.Nil(t, err, "Couldn't list array directory")
+	for _, entry := range entries {
+		t.Errorf("Sort left %v behind", entry.Name())
+	}
+}
+
+// Failed sorts that clean up after themselves must not leave a manifest
+// pointing at destroyed arrays
+func TestSortCheckpointFailure(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortCheckpointTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	opts := DefaultSortOptions()
+	opts.CheckpointDir = tmpDir
+
+	_, err = SortDistribFromRaw(context.Background(), make([]byte, 4096), "testCheckpointFail",
+		data.NewFileArrayFactory(tmpDir), failingWorker("step1_worker1"), opts)
+	require.NotNil(t, err, "Sort succeeded with a failing worker")
+
+	_, err = LoadSortManifest(tmpDir, "testCheckpointFail")
+	require.NotNil(t, err, "Manifest left behind")
+
+	_, err = ResumeSort(context.Background(), tmpDir, "testCheckpointFail", data.NewFileArrayFactory(tmpDir), LocalDistribWorker)
+	require.NotNil(t, err, "Resumed a sort without a checkpoint")
+}
@@ -0,0 +1,222 @@
+package sort
+
+// Pure-Go equivalents of the libsort routines. These have the same semantics
+// as their libsort counterparts (see libsort.go) but run entirely on the CPU
+// and don't need cgo or CUDA. They are always available, builds with the
+// 'nocuda' tag also use them to implement the Gpu* wrappers.
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// Interpret in as uint32s and sort by the radix of width bits starting at bit
+// 'offset'. The sort is stable. boundaries will contain the byte offset of each
+// radix group after sorting (it must have 2^width elements).
+func CpuPartial(in []byte, boundaries []int64, offset int, width int) error {
+	return CpuPartialFormat(in, boundaries, offset, width, Uint32Format)
+}
+
+// Like CpuPartial but for any element format
+func CpuPartialFormat(in []byte, boundaries []int64, offset int, width int, format ElemFormat) error {
+	if err := format.Validate(); err != nil {
+		return err
+	}
+
+	nBucket := 1 << width
+	if len(boundaries) != nBucket {
+		return fmt.Errorf("boundaries has wrong length: expected %v, got %v", nBucket, len(boundaries))
+	}
+	if offset+width > format.KeyBits() {
+		return fmt.Errorf("radix [%v, %v) exceeds the key size (%v bits)", offset, offset+width, format.KeyBits())
+	}
+
+	elemSz := format.ElemSize()
+	if len(in)%elemSz != 0 {
+		return fmt.Errorf("input size (%v) is not a multiple of %v", len(in), elemSz)
+	}
+
+	nElem := len(in) / elemSz
+
+	// Histogram
+	counts := make([]int64, nBucket)
+	for i := 0; i < nElem; i++ {
+		counts[KeyGroupBits(format.Key(in[i*elemSz:]), offset, width)]++
+	}
+
+	// Exclusive prefix sum (in elements)
+	sum := (int64)(0)
+	for i := 0; i < nBucket; i++ {
+		boundaries[i] = sum
+		sum += counts[i]
+	}
+
+	// Stable scatter, we reuse counts as the next free slot in each bucket
+	copy(counts, boundaries)
+	out := make([]byte, len(in))
+	for i := 0; i < nElem; i++ {
+		elem := in[i*elemSz : (i+1)*elemSz]
+		group := KeyGroupBits(format.Key(elem), offset, width)
+		copy(out[counts[group]*(int64)(elemSz):], elem)
+		counts[group]++
+	}
+	copy(in, out)
+
+	for i := 0; i < nBucket; i++ {
+		boundaries[i] *= (int64)(elemSz)
+	}
+
+	return nil
+}
+
+// Compute the count, min and max key and the histogram of each of digits
+// (see ArrayStats) over the elements of in
+func CpuStatsFormat(in []byte, digits []Digit, format ElemFormat) (*ArrayStats, error) {
+	if err := format.Validate(); err != nil {
+		return nil, err
+	}
+
+	elemSz := format.ElemSize()
+	if len(in)%elemSz != 0 {
+		return nil, fmt.Errorf("input size (%v) is not a multiple of %v", len(in), elemSz)
+	}
+
+	counts := make([][]int64, len(digits))
+	for i, digit := range digits {
+		if digit.Offset < 0 || digit.Width <= 0 || digit.Offset+digit.Width > format.KeyBits() {
+			return nil, fmt.Errorf("digit %+v doesn't fit in a %v bit key", digit, format.KeyBits())
+		}
+		counts[i] = make([]int64, 1<<(uint)(digit.Width))
+	}
+
+	stats := &ArrayStats{Digits: digits, Counts: counts}
+	for i := 0; i+elemSz <= len(in); i += elemSz {
+		key := format.Key(in[i:])
+		if stats.Count == 0 || key < stats.Min {
+			stats.Min = key
+		}
+		if stats.Count == 0 || key > stats.Max {
+			stats.Max = key
+		}
+		stats.Count++
+
+		for d, digit := range digits {
+			counts[d][KeyGroupBits(key, digit.Offset, digit.Width)]++
+		}
+	}
+	return stats, nil
+}
+
+// Stable partition of in by splitters (which must be sorted). Bucket i
+// receives the elements with splitters[i-1] <= key < splitters[i]. boundaries
+// will contain the byte offset of each bucket (it must have
+// len(splitters)+1 elements).
+func CpuSplitFormat(in []byte, boundaries []int64, splitters []uint64, format ElemFormat) error {
+	if err := format.Validate(); err != nil {
+		return err
+	}
+
+	nBucket := len(splitters) + 1
+	if len(boundaries) != nBucket {
+		return fmt.Errorf("boundaries has wrong length: expected %v, got %v", nBucket, len(boundaries))
+	}
+
+	elemSz := format.ElemSize()
+	if len(in)%elemSz != 0 {
+		return fmt.Errorf("input size (%v) is not a multiple of %v", len(in), elemSz)
+	}
+
+	nElem := len(in) / elemSz
+	buckets := make([]int, nElem)
+	counts := make([]int64, nBucket)
+	for i := 0; i < nElem; i++ {
+		key := format.Key(in[i*elemSz:])
+		buckets[i] = sort.Search(len(splitters), func(j int) bool { return splitters[j] > key })
+		counts[buckets[i]]++
+	}
+
+	sum := (int64)(0)
+	for i := 0; i < nBucket; i++ {
+		boundaries[i] = sum
+		sum += counts[i]
+	}
+
+	copy(counts, boundaries)
+	out := make([]byte, len(in))
+	for i := 0; i < nElem; i++ {
+		copy(out[counts[buckets[i]]*(int64)(elemSz):], in[i*elemSz:(i+1)*elemSz])
+		counts[buckets[i]]++
+	}
+	copy(in, out)
+
+	for i := 0; i < nBucket; i++ {
+		boundaries[i] *= (int64)(elemSz)
+	}
+
+	return nil
+}
+
+// Interpret in as uint32s and sort them in place
+func CpuFull(in []byte) error {
+	return CpuFullFormat(in, Uint32Format)
+}
+
+// Like CpuFull but for any element format. The sort is stable.
+func CpuFullFormat(in []byte, format ElemFormat) error {
+	if err := format.Validate(); err != nil {
+		return err
+	}
+
+	elemSz := format.ElemSize()
+	if len(in)%elemSz != 0 {
+		return fmt.Errorf("input size (%v) is not a multiple of %v", len(in), elemSz)
+	}
+
+	nElem := len(in) / elemSz
+	keys := make([]uint64, nElem)
+	order := make([]int, nElem)
+	for i := range keys {
+		keys[i] = format.Key(in[i*elemSz:])
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool { return keys[order[i]] < keys[order[j]] })
+
+	out := make([]byte, len(in))
+	for i, src := range order {
+		copy(out[i*elemSz:], in[src*elemSz:(src+1)*elemSz])
+	}
+	copy(in, out)
+
+	return nil
+}
+
+// State for CpuGenerateInputs. This is the same PCG generator used by
+// libsort's populateInput().
+var pcgState uint64 = 0x4d595df4d0f33173
+var pcgLock sync.Mutex
+
+// Generate 'len' uint32's and return the array as a byte slice (total bytes
+// will be 4*len)
+func CpuGenerateInputs(len uint64) ([]byte, error) {
+	const multiplier = 6364136223846793005
+	const increment = 1442695040888963407
+
+	arr := make([]byte, len*4)
+
+	pcgLock.Lock()
+	defer pcgLock.Unlock()
+	for i := (uint64)(0); i < len; i++ {
+		x := pcgState
+		count := (int)(x >> 59)
+
+		pcgState = x*multiplier + increment
+		x ^= x >> 18
+		binary.LittleEndian.PutUint32(arr[i*4:], bits.RotateLeft32((uint32)(x>>27), -count))
+	}
+
+	return arr, nil
+}
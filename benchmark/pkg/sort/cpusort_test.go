@@ -0,0 +1,162 @@
+package sort
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestCpuFull(t *testing.T) {
+	test, err := CpuGenerateInputs((uint64)(4099))
+	require.Nil(t, err, "Failed to generate inputs")
+
+	ref := make([]byte, len(test))
+	copy(ref, test)
+
+	err = CpuFull(test)
+	require.Nil(t, err, "Error while sorting")
+
+	err = CheckSort(ref, test)
+	require.Nilf(t, err, "Sorted Wrong: %v", err)
+}
+
+func TestCpuPartial(t *testing.T) {
+	tLen := 1021
+	width := 8
+	nbucket := 1 << width
+
+	test, err := CpuGenerateInputs((uint64)(tLen))
+	require.Nil(t, err, "failed to generate test inputs")
+
+	boundaries := make([]int64, nbucket)
+
+	ref := make([]byte, len(test))
+	copy(ref, test)
+
+	err = CpuPartial(test, boundaries, 0, width)
+	require.Nil(t, err, "error while sorting")
+
+	checkPartial(t, test, boundaries, ref)
+}
+
+func TestCpuFull64(t *testing.T) {
+	test, err := CpuGenerateInputs((uint64)(4099 * 2))
+	require.Nil(t, err, "Failed to generate inputs")
+
+	ref := make([]byte, len(test))
+	copy(ref, test)
+
+	err = CpuFullFormat(test, Uint64Format)
+	require.Nil(t, err, "Error while sorting")
+
+	err = CheckSortFormat(ref, test, Uint64Format)
+	require.Nilf(t, err, "Sorted Wrong: %v", err)
+}
+
+// Records must move whole with their keys
+func TestCpuRecords(t *testing.T) {
+	// Key in the middle of the record so both sides of it are payload
+	format := ElemFormat{KeySize: 4, RecordSize: 12, KeyOffset: 4}
+
+	test, err := GenerateRecords(1021, format)
+	require.Nil(t, err, "Failed to generate inputs")
+
+	ref := make([]byte, len(test))
+	copy(ref, test)
+
+	boundaries := make([]int64, 1<<8)
+	err = CpuPartialFormat(test, boundaries, 0, 8, format)
+	require.Nil(t, err, "Error while partial sorting")
+	checkPartialFormat(t, test, boundaries, ref, format)
+	require.Nil(t, checkSameRecords(ref, test, 12), "Partial sort split records")
+
+	err = CpuFullFormat(test, format)
+	require.Nil(t, err, "Error while sorting")
+
+	err = CheckSortFormat(ref, test, format)
+	require.Nilf(t, err, "Sorted Wrong: %v", err)
+
+	err = CpuFullFormat(test[:len(test)-4], format)
+	require.NotNil(t, err, "Sorted a partial record")
+}
+
+func TestCpuSplit(t *testing.T) {
+	test, err := CpuGenerateInputs(1021)
+	require.Nil(t, err, "Failed to generate inputs")
+
+	splitters := []uint64{1 << 30, 1 << 30, 3 << 30}
+	boundaries := make([]int64, len(splitters)+1)
+	err = CpuSplitFormat(test, boundaries, splitters, Uint32Format)
+	require.Nil(t, err, "Error while splitting")
+
+	require.Equal(t, boundaries[1], boundaries[2], "Repeated splitter should give an empty bucket")
+
+	boundaries = append(boundaries, (int64)(len(test)))
+	for bucket := 0; bucket < len(splitters)+1; bucket++ {
+		for i := boundaries[bucket]; i < boundaries[bucket+1]; i += 4 {
+			key := Uint32Format.Key(test[i:])
+			if bucket > 0 {
+				require.GreaterOrEqualf(t, key, splitters[bucket-1], "Element at byte %v in wrong bucket", i)
+			}
+			if bucket < len(splitters) {
+				require.Lessf(t, key, splitters[bucket], "Element at byte %v in wrong bucket", i)
+			}
+		}
+	}
+}
+
+// Partial sorts on 64-bit keys must be able to use the upper 32 bits
+func TestCpuPartial64(t *testing.T) {
+	nElem := 1021
+	width := 8
+	offset := 48
+
+	test, err := CpuGenerateInputs((uint64)(nElem * 2))
+	require.Nil(t, err, "failed to generate test inputs")
+
+	boundaries := make([]int64, 1<<width)
+	err = CpuPartialFormat(test, boundaries, offset, width, Uint64Format)
+	require.Nil(t, err, "error while sorting")
+
+	boundaries = append(boundaries, (int64)(len(test)))
+	for bucket := 0; bucket < 1<<width; bucket++ {
+		for i := boundaries[bucket]; i < boundaries[bucket+1]; i += 8 {
+			group := KeyGroupBits(Uint64Format.Key(test[i:]), offset, width)
+			require.Equalf(t, bucket, group, "Element at byte %v in wrong bucket", i)
+		}
+	}
+
+	err = CpuPartialFormat(test, boundaries[:1<<width], 60, width, Uint64Format)
+	require.NotNil(t, err, "Sorted past the end of the key")
+}
+
+// The CPU partial sort must be a drop-in replacement for GpuPartial (same
+// output order and boundaries). When built with 'nocuda' this is trivially
+// true.
+func TestCpuPartialMatchesGpu(t *testing.T) {
+	tLen := 4099
+	width := 4
+	offset := 8
+	nbucket := 1 << width
+
+	err := InitLibSort()
+	require.Nil(t, err, "failed to initialize libsort")
+
+	cpuIn, err := GenerateInputs((uint64)(tLen))
+	require.Nil(t, err, "failed to generate test inputs")
+
+	gpuIn := make([]byte, len(cpuIn))
+	copy(gpuIn, cpuIn)
+
+	cpuBoundaries := make([]int64, nbucket)
+	gpuBoundaries := make([]int64, nbucket)
+
+	err = CpuPartial(cpuIn, cpuBoundaries, offset, width)
+	require.Nil(t, err, "CPU sort failed")
+
+	err = GpuPartial(gpuIn, gpuBoundaries, offset, width)
+	require.Nil(t, err, "GPU sort failed")
+
+	require.Equal(t, gpuBoundaries, cpuBoundaries, "Boundaries differ")
+	require.Equal(t, gpuIn, cpuIn, "Outputs differ")
+}
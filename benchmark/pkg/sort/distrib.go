@@ -1,35 +1,72 @@
 package sort
 
 import (
+	"context"
 	"fmt"
 	"io"
 	"math"
 	"runtime"
-	"sync"
 
 	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
 	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
 )
 
-var sortWidth int = 8
-
-func SetWidth(newWidth int) {
-	sortWidth = newWidth
+// Read InBkts in order and sort by the radix of width width and starting at
+// offset. Elements are laid out as described by format. Returns a distributed
+// array (generated by 'factory') with one part per unique radix value. The
+// output array must be named baseName+"_output" (this lets the sort clean up
+// after failed workers). Workers should give up (and return an error) if ctx
+// is cancelled.
+type DistribWorker func(ctx context.Context, inBkts []*data.PartRef, offset int, width int, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error)
+
+// A DistribWorker that sorts in the local process using the default
+// LocalSorter for this build (see DefaultLocalSorter())
+func LocalDistribWorker(ctx context.Context, inBkts []*data.PartRef, offset int, width int, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+	return NewLocalDistribWorker(DefaultLocalSorter())(ctx, inBkts, offset, width, format, baseName, factory)
 }
 
-// Read InBkts in order and sort by the radix of width width and starting at
-// offset Returns a distributed array (generated by 'factory') with one part
-// per unique radix value. Array names will be prefixed with baseName.
-type DistribWorker func(inBkts []*data.PartRef, offset int, width int, baseName string, factory *data.ArrayFactory) (data.DistribArray, error)
+// Returns a DistribWorker that sorts in the local process using sorter. Local
+// sorts can't be interrupted, cancellation is only checked before starting.
+func NewLocalDistribWorker(sorter LocalSorter) DistribWorker {
+	return func(ctx context.Context, inBkts []*data.PartRef, offset int, width int, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return LocalSortPartial(sorter, inBkts, offset, width, format, baseName+"_output", factory)
+	}
+}
 
-func LocalDistribWorker(inBkts []*data.PartRef, offset int, width int, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+// Read inBkts and partially sort them in the local process using sorter. The
+// output is written to a new array called outName (one partition per radix
+// bucket).
+func LocalSortPartial(sorter LocalSorter, inBkts []*data.PartRef, offset int, width int, format ElemFormat,
+	outName string, factory *data.ArrayFactory) (data.DistribArray, error) {
 	var err error
 
+	if err = sorter.Caps().CheckFormat(format); err != nil {
+		return nil, errors.Wrapf(err, "Local sorter %v can't sort this input", sorter.Name())
+	}
+
+	if err = sorter.Init(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to initialize local sorter %v", sorter.Name())
+	}
+
 	totalLen := 0
 	for i := 0; i < len(inBkts); i++ {
 		totalLen += inBkts[i].NByte
 	}
 
+	elemSz := format.ElemSize()
+	if totalLen%elemSz != 0 {
+		return nil, fmt.Errorf("Input length %v is not a multiple of the element size (%v)", totalLen, elemSz)
+	}
+
+	maxElem := sorter.Caps().MaxElem
+	if maxElem != 0 && totalLen/elemSz > maxElem {
+		return nil, fmt.Errorf("Input too large for local sorter %v: %v elements (max %v)", sorter.Name(), totalLen/elemSz, maxElem)
+	}
+
 	inBytes, err := data.FetchPartRefs(inBkts)
 	if err != nil {
 		return nil, errors.Wrap(err, "Couldn't read input references")
@@ -38,10 +75,17 @@ func LocalDistribWorker(inBkts []*data.PartRef, offset int, width int, baseName
 	// Actual Sort
 	nBucket := 1 << width
 	boundaries := make([]int64, nBucket)
-	if err := GpuPartial(inBytes, boundaries, offset, width); err != nil {
+	if err := sorter.Partial(inBytes, boundaries, offset, width, format); err != nil {
 		return nil, errors.Wrap(err, "Local sort failed")
 	}
 
+	return writeBuckets(inBytes, boundaries, outName, factory)
+}
+
+// Write the bucketed data in inBytes (boundaries holds the byte offset of
+// each bucket) to a new array called outName with one partition per bucket
+func writeBuckets(inBytes []byte, boundaries []int64, outName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+	nBucket := len(boundaries)
 	partSzs := make([]int64, nBucket)
 	for i := 0; i < nBucket; i++ {
 		if i == nBucket-1 {
@@ -54,7 +98,7 @@ func LocalDistribWorker(inBkts []*data.PartRef, offset int, width int, baseName
 	shape := data.CreateShape(partSzs)
 
 	// Write Outputs
-	outArr, err := factory.Create(baseName+"_output", shape)
+	outArr, err := factory.Create(outName, shape)
 	if err != nil {
 		return nil, errors.Wrap(err, "Could not allocate output")
 	}
@@ -83,12 +127,54 @@ func LocalDistribWorker(inBkts []*data.PartRef, offset int, width int, baseName
 	return outArr, nil
 }
 
-// Distributed sort of arr. The bytes in arr will be interpreted as uint32's
-// Returns an ordered list of distributed arrays containing the sorted output
-// (concatenate each array's partitions in order to get final result). 'len' is
-// the number of bytes in arr.
-func SortDistribFromArr(arr data.DistribArray, sz int, baseName string,
-	factory *data.ArrayFactory, worker DistribWorker) ([]data.DistribArray, error) {
+// Distributed sort of arr. The bytes in arr will be interpreted as elements
+// described by opts.Format() (bare uint32's by default), records are moved
+// whole and never split between workers. Returns an ordered list of
+// distributed arrays containing the sorted output (concatenate each array's
+// partitions in order to get final result). 'sz' is the number of bytes in
+// arr. If opts is nil, DefaultSortOptions() is used.
+// Cancelling ctx aborts the sort, the first worker failure in a step cancels
+// the rest of that step's workers. If the sort fails, every array it created
+// is destroyed (unless opts.KeepOnError is set). With opts.Cleanup ==
+// CleanupAll (the default), arr is destroyed as soon as the first pass that
+// moves data has finished, so a failure in a later pass loses it. Use
+// CleanupIntermediate to keep arr, it is never modified.
+func SortDistribFromArr(ctx context.Context, arr data.DistribArray, sz int, baseName string,
+	factory *data.ArrayFactory, worker DistribWorker, opts *SortOptions) ([]data.DistribArray, error) {
+	outputs, _, err := SortDistribFromArrWithReport(ctx, arr, sz, baseName, factory, worker, opts)
+	return outputs, err
+}
+
+// Destroy every array created by a failed sort, along with its checkpoint
+// (unless the options say to keep them). Returns the error to report.
+func cleanupFailedSort(sortErr error, tracker *arrayTracker, manifest *SortManifest) error {
+	// Losing attempts may still be writing outputs
+	waitErr := tracker.wait()
+
+	opts := manifest.Options
+	if opts.KeepOnError || opts.Cleanup == CleanupNone {
+		return errors.Wrapf(sortErr, "Sort failed (keeping arrays %v)", tracker.names())
+	}
+
+	// The checkpoint would refer to destroyed arrays
+	manifest.remove()
+
+	if err := tracker.destroyAll(); err != nil {
+		return errors.Wrapf(sortErr, "Sort failed (and cleanup failed: %v)", err)
+	}
+	if waitErr != nil {
+		return errors.Wrapf(sortErr, "Sort failed (and cleanup failed: %v)", waitErr)
+	}
+	return sortErr
+}
+
+// Implementation of SortDistribFromArr and ResumeSort. Continues the sort
+// described by manifest (which is updated as the sort progresses), arrs are
+// the opened manifest.Outputs. An empty output name means the array belongs to
+// the caller. Every array created while sorting is recorded in tracker, arrays
+// are forgotten when they are destroyed.
+func sortDistrib(ctx context.Context, arrs []data.DistribArray, manifest *SortManifest,
+	tracker *arrayTracker, worker DistribWorker) ([]data.DistribArray, error) {
 	// Data Layout:
 	//	 - Distrib Arrays store all output from a single node
 	//	 - DistribParts represent radix sort buckets (there will be nbucket parts per DistribArray)
@@ -104,21 +190,72 @@ func SortDistribFromArr(arr data.DistribArray, sz int, baseName string,
 	//	   always exist.
 	//	 - Input distribArrays may be garbage collected after every worker has
 	//     provided their output (output distribArrays are copies, not references).
-	nworker := 2 //number of workers (degree of parallelism)
-	// nworker := 1 //number of workers (degree of parallelism)
-	nstep := (32 / sortWidth) // number of steps needed to fully sort
+	opts := manifest.Options
+	baseName := manifest.BaseName
+	sz := manifest.Size
+
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Invalid sort options")
+	}
+
+	nworker := opts.nWorker(sz) //number of workers (degree of parallelism)
+	widths := opts.passWidths()
 
 	// Target number of bytes to process per worker, the last worker might get less
-	nElem := sz / 4
-	maxPerWorker := (int)(math.Ceil((float64)(nElem)/(float64)(nworker))) * 4
+	format := opts.Format()
+	elemSz := format.ElemSize()
+	nElem := sz / elemSz
+	maxPerWorker := (int)(math.Ceil((float64)(nElem)/(float64)(nworker))) * elemSz
+
+	// Initial input is the output of the last completed step (or "step -1")
+	outputs := arrs
+	outNames := manifest.Outputs
+
+	if manifest.NStep == 0 && manifest.Skipped == nil && opts.Histogram != nil {
+		skipped, err := findConstantDigits(ctx, arrs, nElem, opts, nworker, maxPerWorker)
+		if err != nil {
+			return nil, errors.Wrap(err, "Histogram pre-pass failed")
+		}
+		manifest.Skipped = skipped
 
-	// Initial input is the output for "step -1"
-	var outputs []data.DistribArray
-	outputs = []data.DistribArray{arr}
+		// A resumed sort couldn't reopen the caller's input, the first pass
+		// checkpoints the histogram instead
+		if manifest.Input != "" {
+			if err = manifest.commit(); err != nil {
+				return nil, errors.Wrap(err, "Failed to checkpoint histogram")
+			}
+		}
+	}
+
+	skip := make(map[int]bool)
+	for _, step := range manifest.Skipped {
+		skip[step] = true
+	}
+
+	// The first pass that moves data reads the caller's input
+	firstStep := 0
+	for skip[firstStep] {
+		firstStep++
+	}
+
+	offset := 0
+	for _, width := range widths[:manifest.NStep] {
+		offset += width
+	}
+
+	for step := manifest.NStep; step < len(widths); step++ {
+		width := widths[step]
+		if skip[step] {
+			// Every key has the same digit, this pass wouldn't change anything
+			offset += width
+			manifest.NStep = step + 1
+			continue
+		}
 
-	for step := 0; step < nstep; step++ {
 		inputs := outputs
+		inNames := outNames
 		outputs = make([]data.DistribArray, nworker)
+		outNames = make([]string, nworker)
 
 		// This is perhaps over-optimization but it shaves ~6GB off the
 		// resident memory size for MemDistribArrays in the big test (13 vs
@@ -127,70 +264,171 @@ func SortDistribFromArr(arr data.DistribArray, sz int, baseName string,
 		// XXX after the refactor, how important is this? Should I just put it in MemDistribArray.Destroy()?
 		runtime.GC()
 
-		inGen, err := NewBucketReader(inputs, STRIDED)
+		// Repartition previous output. This is done up-front so that we
+		// never have to bail out with workers still running.
+		workerInputs, err := splitInputs(inputs, opts, nworker, maxPerWorker)
 		if err != nil {
 			return nil, err
 		}
 
-		var wg sync.WaitGroup
-		wg.Add(nworker)
-		errChan := make(chan error, nworker)
+		group, stepCtx := errgroup.WithContext(ctx)
 		for workerId := 0; workerId < nworker; workerId++ {
-			// Repartition previous output
-			workerInputs, genErr := inGen.ReadRef(maxPerWorker)
-			if genErr == io.EOF && workerId+1 != nworker {
-				return nil, errors.New("Premature EOF from input generator")
-			} else if genErr != nil && genErr != io.EOF {
-				return nil, errors.Wrap(err, "Input generator had an error")
-			}
-
-			go func(id int, inputs []*data.PartRef) {
-				defer wg.Done()
-
-				workerName := fmt.Sprintf("%v_step%v_worker%v", baseName, step, id)
+			id := workerId
+			group.Go(func() error {
+				out, outName, workerErr := runWorkerTask(stepCtx, worker, workerInputs[id], offset, width, format,
+					taskName(baseName, step, id), tracker, opts)
+				if workerErr != nil {
+					return errors.Wrapf(workerErr, "Worker failure on step %v, worker %v", step, id)
+				}
+				outputs[id] = out
+				outNames[id] = outName
+				return nil
+			})
+		}
+		if err = group.Wait(); err != nil {
+			return nil, errors.Wrapf(err, "Worker failure")
+		}
+		offset += width
 
-				outputs[id], err = worker(inputs, step*sortWidth, sortWidth, workerName, factory)
+		if opts.CheckpointDir != "" {
+			// Outputs must be in the backing store before a checkpoint can
+			// refer to them
+			if err = commitOutputs(outputs, outNames, tracker); err != nil {
+				return nil, errors.Wrapf(err, "Failed to commit outputs of step %v", step)
+			}
+		}
 
-				if err != nil {
-					errChan <- errors.Wrapf(err, "Worker failure on step %v, worker %v", step, id)
-					return
-				}
-			}(workerId, workerInputs)
+		manifest.NStep = step + 1
+		manifest.Outputs = outNames
+		if err = manifest.commit(); err != nil {
+			return nil, errors.Wrapf(err, "Failed to checkpoint step %v", step)
 		}
-		wg.Wait()
-		select {
-		case firstErr := <-errChan:
-			return nil, errors.Wrapf(firstErr, "Worker failure")
-		default:
+
+		if opts.Cleanup == CleanupNone || (step == firstStep && opts.Cleanup == CleanupIntermediate) {
+			continue
 		}
 
 		var destroyErr error
 		for i := 0; i < len(inputs); i++ {
-			if err = inputs[i].Destroy(); err != nil {
+			if err = tracker.destroyInput(inNames[i], inputs[i]); err != nil {
 				destroyErr = err
 			}
 		}
 		if destroyErr != nil {
-			return nil, errors.Wrapf(err, "Failed to destroy one or more intermediate arrays")
+			return nil, errors.Wrapf(destroyErr, "Failed to destroy one or more intermediate arrays")
 		}
 	}
 
+	if err := tracker.wait(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to destroy one or more intermediate arrays")
+	}
+
+	// The sort is done, there's nothing left to resume
+	if err := manifest.remove(); err != nil {
+		return nil, err
+	}
+
 	return outputs, nil
 }
 
+// Divide the elements of arrs (read in opts.ReadOrder) into nworker inputs of
+// at most maxPerWorker bytes each
+func splitInputs(arrs []data.DistribArray, opts *SortOptions, nworker int, maxPerWorker int) ([][]*data.PartRef, error) {
+	inGen, err := NewAlignedBucketReader(arrs, opts.ReadOrder, opts.Format().ElemSize())
+	if err != nil {
+		return nil, err
+	}
+
+	workerInputs := make([][]*data.PartRef, nworker)
+	eof := false
+	for workerId := 0; workerId < nworker; workerId++ {
+		// Rounding can use up the input early, the remaining workers get
+		// empty inputs
+		if eof {
+			workerInputs[workerId] = []*data.PartRef{}
+			continue
+		}
+
+		var genErr error
+		workerInputs[workerId], genErr = inGen.ReadRef(maxPerWorker)
+		if genErr == io.EOF {
+			eof = true
+		} else if genErr != nil {
+			return nil, errors.Wrap(genErr, "Input generator had an error")
+		}
+	}
+	return workerInputs, nil
+}
+
+// Close (commit) and reopen every array in arrs
+func commitOutputs(arrs []data.DistribArray, names []string, tracker *arrayTracker) error {
+	for i := range arrs {
+		if err := arrs[i].Close(); err != nil {
+			return errors.Wrapf(err, "Failed to close %v", names[i])
+		}
+
+		reopened, err := tracker.factory.Open(names[i])
+		if err != nil {
+			return errors.Wrapf(err, "Failed to reopen %v", names[i])
+		}
+		arrs[i] = reopened
+		tracker.add(names[i], reopened)
+	}
+	return nil
+}
+
 // Sort a native byte array using DistribArrays from factory and remote worker
-// invoker 'worker'.
-func SortDistribFromRaw(inRaw []byte, baseName string,
-	factory *data.ArrayFactory, worker DistribWorker) ([]byte, error) {
-	var err error
+// invoker 'worker'. If opts is nil, DefaultSortOptions() is used. See
+// SortDistribFromArr for how ctx and failures are handled.
+func SortDistribFromRaw(ctx context.Context, inRaw []byte, baseName string,
+	factory *data.ArrayFactory, worker DistribWorker, opts *SortOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultSortOptions()
+	}
+
+	inName := baseName + "_input"
+	manifest := &SortManifest{BaseName: baseName, Size: len(inRaw), Input: inName, Outputs: []string{inName}, Options: opts}
+	tracker := newArrayTracker(factory)
+	outRaw, err := sortDistribFromRaw(ctx, inRaw, manifest, tracker, worker)
+	if err != nil && outRaw == nil {
+		return nil, cleanupFailedSort(err, tracker, manifest)
+	}
+	return outRaw, err
+}
+
+// Implementation of SortDistribFromRaw. If the sort itself succeeded but
+// cleaning up afterwards failed, the output is returned along with the error.
+func sortDistribFromRaw(ctx context.Context, inRaw []byte, manifest *SortManifest,
+	tracker *arrayTracker, worker DistribWorker) ([]byte, error) {
 
-	err = InitLibSort()
+	opts := manifest.Options
+	origArr, err := createRawInput(inRaw, manifest.Input, opts, tracker.trackingFactory())
 	if err != nil {
-		return nil, errors.Wrap(err, "Failed to initialize libsort")
+		return nil, err
+	}
+
+	outArrs, err := sortDistrib(ctx, []data.DistribArray{origArr}, manifest, tracker, worker)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to sort distribArrays")
+	}
+
+	return finishRawSort(outArrs, origArr, len(inRaw), opts)
+}
+
+// Write inRaw to a new single-partition array called name, encoding keys as
+// described by opts. inRaw itself is not modified.
+func createRawInput(inRaw []byte, name string, opts *SortOptions, factory *data.ArrayFactory) (data.DistribArray, error) {
+	var err error
+
+	if err = opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Invalid sort options")
+	}
+	if len(inRaw)%opts.Format().ElemSize() != 0 {
+		return nil, fmt.Errorf("Input length %v is not a multiple of the element size (%v)", len(inRaw), opts.Format().ElemSize())
 	}
 
 	shape := data.CreateShapeUniform((int64)(len(inRaw)), 1)
-	origArr, err := factory.Create(baseName+"_input", shape)
+	origArr, err := factory.Create(name, shape)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to create input distribarray")
 	}
@@ -200,7 +438,15 @@ func SortDistribFromRaw(inRaw []byte, baseName string,
 		return nil, errors.Wrap(err, "Failed to get writer for partition")
 	}
 
-	n, err := writer.Write(inRaw)
+	// The caller's input must not be modified
+	encoded := inRaw
+	if opts.encodesKeys() {
+		encoded = make([]byte, len(inRaw))
+		copy(encoded, inRaw)
+		transformKeys(encoded, opts, false)
+	}
+
+	n, err := writer.Write(encoded)
 	if err != nil {
 		return nil, errors.Wrap(err, "error writing initial data")
 	} else if n != len(inRaw) {
@@ -209,24 +455,35 @@ func SortDistribFromRaw(inRaw []byte, baseName string,
 	writer.Close()
 
 	origArr.Close()
-	outArrs, err := SortDistribFromArr(origArr, len(inRaw), baseName, factory, worker)
-	if err != nil {
-		return nil, errors.Wrap(err, "Failed to sort distribArrays")
-	}
+	return origArr, nil
+}
 
-	reader, err := NewBucketReader(outArrs, STRIDED)
-	if err != nil {
-		return nil, errors.Wrap(err, "Failed to get reader for output")
-	}
+// Read the sz-byte sorted output of a raw sort back into memory (decoding
+// keys) and clean up as described by opts. If only the cleanup failed, the
+// output is returned along with the error.
+func finishRawSort(outArrs []data.DistribArray, origArr data.DistribArray, sz int, opts *SortOptions) ([]byte, error) {
+	var err error
 
-	outRaw := make([]byte, len(inRaw))
-	// We don't use ioutil.ReadAll because we know the size of the output already
-	for n := 0; n < len(inRaw); {
-		nCur, err := reader.Read(outRaw[n:])
+	outRaw := make([]byte, sz)
+	if len(outArrs) != 0 {
+		reader, err := NewAlignedBucketReader(outArrs, opts.ReadOrder, opts.Format().ElemSize())
 		if err != nil {
-			return nil, errors.Wrap(err, "Failed to read results")
+			return nil, errors.Wrap(err, "Failed to get reader for output")
 		}
-		n += nCur
+
+		// We don't use ioutil.ReadAll because we know the size of the output already
+		for n := 0; n < sz; {
+			nCur, err := reader.Read(outRaw[n:])
+			if err != nil {
+				return nil, errors.Wrap(err, "Failed to read results")
+			}
+			n += nCur
+		}
+	}
+	transformKeys(outRaw, opts, true)
+
+	if opts.Cleanup == CleanupNone {
+		return outRaw, nil
 	}
 
 	var destroyErr error
@@ -236,12 +493,15 @@ func SortDistribFromRaw(inRaw []byte, baseName string,
 		}
 	}
 
-	if err = origArr.Destroy(); err != nil {
-		destroyErr = err
+	// CleanupAll already destroyed the input after the first pass
+	if opts.Cleanup == CleanupIntermediate {
+		if err = origArr.Destroy(); err != nil {
+			destroyErr = err
+		}
 	}
 
 	if destroyErr != nil {
-		return outRaw, errors.Wrapf(err, "Failed to clean up one or more arrays")
+		return outRaw, errors.Wrapf(destroyErr, "Failed to clean up one or more arrays")
 	}
 
 	return outRaw, nil
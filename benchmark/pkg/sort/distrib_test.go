@@ -1,10 +1,15 @@
 package sort
 
 import (
+	"context"
 	"io"
 	"io/ioutil"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
 	"github.com/pkg/errors"
@@ -146,6 +151,32 @@ func TestBucketReaderRef(t *testing.T) {
 		}
 	})
 
+	// Element-aligned readers must never split an element between refs
+	t.Run("ElemSize", func(t *testing.T) {
+		_, err := NewAlignedBucketReader(arrs, STRIDED, 3)
+		require.NotNil(t, err, "Accepted partitions that don't hold whole elements")
+
+		g, err := NewAlignedBucketReader(arrs, STRIDED, 8)
+		require.Nil(t, err, "Couldn't initialize generator")
+
+		_, err = g.ReadRef(20)
+		require.NotNil(t, err, "Accepted a read of partial elements")
+
+		globalSz := 0
+		for {
+			refs, genErr := g.ReadRef(24)
+			for _, ref := range refs {
+				require.Zerof(t, ref.Start%8, "Reference starts mid-element: %+v", ref)
+				require.Zerof(t, ref.NByte%8, "Reference ends mid-element: %+v", ref)
+				globalSz += ref.NByte
+			}
+			if genErr == io.EOF {
+				break
+			}
+			require.Nil(t, genErr, "Error while reading")
+		}
+		require.Equal(t, nElem, globalSz, "Read the wrong amount of data")
+	})
 }
 
 func TestSortMemDistrib(t *testing.T) {
@@ -159,3 +190,252 @@ func TestSortFileDistrib(t *testing.T) {
 
 	SortDistribTest(t, "testSortFileDistrib", data.NewFileArrayFactory(tmpDir), LocalDistribWorker)
 }
+
+// A sorter that only claims to handle 32-bit keys (like libsort)
+type uint32Sorter struct {
+	goSorter
+}
+
+func (self *uint32Sorter) Caps() LocalSorterCaps {
+	return LocalSorterCaps{KeySizes: []int{4}}
+}
+
+func TestSortDistrib64(t *testing.T) {
+	worker := NewLocalDistribWorker(&goSorter{})
+
+	opts := DefaultSortOptions()
+	opts.KeySize = 8
+	opts.Width = 16
+	opts.NWorker = 3
+
+	t.Run("Mem", func(t *testing.T) {
+		SortDistribOptsTest(t, "testSortDistrib64", data.MemArrayFactory, worker, opts)
+	})
+
+	t.Run("File", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "radixSort64Test")
+		require.Nilf(t, err, "Couldn't create temporary test directory")
+		defer os.RemoveAll(tmpDir)
+
+		SortDistribOptsTest(t, "testSortDistrib64", data.NewFileArrayFactory(tmpDir), worker, opts)
+	})
+
+	t.Run("Misaligned", func(t *testing.T) {
+		_, err := SortDistribFromRaw(context.Background(), make([]byte, 8*10+4), "testSortMisaligned", data.MemArrayFactory, worker, opts)
+		require.NotNil(t, err, "Sorted an input that isn't a whole number of keys")
+	})
+
+	t.Run("UnsupportedSorter", func(t *testing.T) {
+		_, err := SortDistribFromRaw(context.Background(), make([]byte, 8*10), "testSortUnsupported", data.MemArrayFactory,
+			NewLocalDistribWorker(&uint32Sorter{}), opts)
+		require.NotNil(t, err, "Sorter accepted an unsupported key size")
+	})
+}
+
+func TestSortDistribRecords(t *testing.T) {
+	worker := NewLocalDistribWorker(&goSorter{})
+
+	formats := map[string]ElemFormat{
+		// Key/value pairs
+		"KV16": {KeySize: 8, RecordSize: 16},
+		// TeraSort-style rows, records don't line up with a power of two
+		"Row100": {KeySize: 8, RecordSize: 100, KeyOffset: 2},
+		// Record size that isn't a multiple of the key size
+		"Odd13": {KeySize: 4, RecordSize: 13, KeyOffset: 9},
+	}
+
+	for name, format := range formats {
+		opts := DefaultSortOptions()
+		opts.KeySize = format.KeySize
+		opts.RecordSize = format.RecordSize
+		opts.KeyOffset = format.KeyOffset
+		opts.NWorker = 3
+
+		t.Run(name, func(t *testing.T) {
+			tmpDir, err := ioutil.TempDir("", "radixSortRecordTest")
+			require.Nilf(t, err, "Couldn't create temporary test directory")
+			defer os.RemoveAll(tmpDir)
+
+			SortDistribOptsTest(t, "testSortRecords"+name, data.NewFileArrayFactory(tmpDir), worker, opts)
+		})
+	}
+
+	t.Run("UnsupportedSorter", func(t *testing.T) {
+		opts := DefaultSortOptions()
+		opts.RecordSize = 8
+		_, err := SortDistribFromRaw(context.Background(), make([]byte, 8*10), "testSortUnsupported", data.MemArrayFactory,
+			NewLocalDistribWorker(&uint32Sorter{}), opts)
+		require.NotNil(t, err, "Sorter accepted records")
+	})
+}
+
+// One failing worker must cancel its siblings and the sort must not return
+// until every worker has finished.
+func TestSortDistribWorkerFailure(t *testing.T) {
+	var nRunning int32
+
+	worker := func(ctx context.Context, inBkts []*data.PartRef, offset int, width int, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+		atomic.AddInt32(&nRunning, 1)
+		defer atomic.AddInt32(&nRunning, -1)
+
+		if strings.HasSuffix(baseName, "worker0") {
+			return nil, errors.New("Injected failure")
+		}
+
+		// Other workers wait to be cancelled
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	opts := DefaultSortOptions()
+	opts.NWorker = 4
+
+	_, err := SortDistribFromRaw(context.Background(), make([]byte, 4096), "testWorkerFailure", data.MemArrayFactory, worker, opts)
+	require.NotNil(t, err, "Sort succeeded with a failing worker")
+	require.Contains(t, err.Error(), "Injected failure", "Wrong error reported")
+	require.Equal(t, int32(0), atomic.LoadInt32(&nRunning), "Sort returned with workers still running")
+}
+
+// The caller's context must be able to abort a sort
+func TestSortDistribDeadline(t *testing.T) {
+	worker := func(ctx context.Context, inBkts []*data.PartRef, offset int, width int, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+
+	_, err := SortDistribFromRaw(ctx, make([]byte, 4096), "testDeadline", data.MemArrayFactory, worker, nil)
+	require.NotNil(t, err, "Sort ignored deadline")
+	require.Equal(t, context.DeadlineExceeded, errors.Cause(err), "Wrong error reported")
+
+	// Local workers check the context before starting
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = SortDistribFromRaw(cancelled, make([]byte, 4096), "testCancelled", data.MemArrayFactory, LocalDistribWorker, nil)
+	require.Equal(t, context.Canceled, errors.Cause(err), "Local worker ignored cancellation")
+}
+
+// Wraps factory and records the name of every array created through it
+func recordingFactory(factory *data.ArrayFactory) (*data.ArrayFactory, func() []string) {
+	var lock sync.Mutex
+	var names []string
+
+	recorder := &data.ArrayFactory{
+		Create: func(name string, shape data.DistribArrayShape) (data.DistribArray, error) {
+			lock.Lock()
+			names = append(names, name)
+			lock.Unlock()
+			return factory.Create(name, shape)
+		},
+		Open:         factory.Open,
+		OpenReadOnly: factory.OpenReadOnly,
+	}
+
+	return recorder, func() []string {
+		lock.Lock()
+		defer lock.Unlock()
+		return append([]string{}, names...)
+	}
+}
+
+// A worker that fails after creating its output if its name ends in failName
+func failingWorker(failName string) DistribWorker {
+	return func(ctx context.Context, inBkts []*data.PartRef, offset int, width int, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+		out, err := LocalDistribWorker(ctx, inBkts, offset, width, format, baseName, factory)
+		if err != nil {
+			return nil, err
+		}
+		if strings.HasSuffix(baseName, failName) {
+			return nil, errors.New("Injected failure")
+		}
+		return out, nil
+	}
+}
+
+func testSortFailureCleanup(t *testing.T, factory *data.ArrayFactory) {
+	origRaw, err := CpuGenerateInputs(1111)
+	require.Nil(t, err, "Failed to generate test inputs")
+
+	for _, failName := range []string{"step0_worker0", "step2_worker1"} {
+		t.Run("Raw_"+failName, func(t *testing.T) {
+			recorder, created := recordingFactory(factory)
+
+			_, err := SortDistribFromRaw(context.Background(), origRaw, "testFailRaw", recorder, failingWorker(failName), nil)
+			require.NotNil(t, err, "Sort succeeded with a failing worker")
+			require.NotEmpty(t, created(), "Sort didn't create any arrays")
+
+			for _, name := range created() {
+				arr, err := factory.Open(name)
+				if err == nil {
+					arr.Destroy()
+				}
+				require.NotNilf(t, err, "Array %v leaked", name)
+			}
+		})
+	}
+
+	t.Run("Arr", func(t *testing.T) {
+		inArr, err := factory.Create("testFailArrInput", data.CreateShapeUniform((int64)(len(origRaw)), 1))
+		require.Nil(t, err, "Failed to create input array")
+		defer inArr.Destroy()
+
+		writer, err := inArr.GetPartWriter(0)
+		require.Nil(t, err, "Failed to get writer")
+		_, err = writer.Write(origRaw)
+		require.Nil(t, err, "Failed to write input")
+		writer.Close()
+		require.Nil(t, inArr.Close(), "Failed to commit input")
+
+		recorder, created := recordingFactory(factory)
+		_, err = SortDistribFromArr(context.Background(), inArr, len(origRaw), "testFailArr", recorder, failingWorker("step0_worker1"), nil)
+		require.NotNil(t, err, "Sort succeeded with a failing worker")
+
+		for _, name := range created() {
+			_, err := factory.Open(name)
+			require.NotNilf(t, err, "Array %v leaked", name)
+		}
+
+		// The caller's array must survive
+		_, err = factory.Open("testFailArrInput")
+		require.Nil(t, err, "Sort destroyed its input after failing")
+	})
+
+	t.Run("KeepOnError", func(t *testing.T) {
+		recorder, created := recordingFactory(factory)
+
+		opts := DefaultSortOptions()
+		opts.KeepOnError = true
+		_, err := SortDistribFromRaw(context.Background(), origRaw, "testFailKeep", recorder, failingWorker("step1_worker0"), opts)
+		require.NotNil(t, err, "Sort succeeded with a failing worker")
+
+		nKept := 0
+		for _, name := range created() {
+			if arr, err := factory.Open(name); err == nil {
+				nKept++
+				arr.Destroy()
+			}
+		}
+		// CleanupAll still destroys the input once the first step succeeds
+		require.Equal(t, len(created())-1, nKept, "Arrays not kept")
+	})
+}
+
+func TestSortFailureCleanupMem(t *testing.T) {
+	testSortFailureCleanup(t, data.MemArrayFactory)
+}
+
+func TestSortFailureCleanupFile(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortFailureTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	testSortFailureCleanup(t, data.NewFileArrayFactory(tmpDir))
+
+	entries, err := ioutil.ReadDir(tmpDir)
+	require.Nil(t, err, "Couldn't list array directory")
+	for _, entry := range entries {
+		t.Errorf("Sort left %v behind", entry.Name())
+	}
+}
@@ -0,0 +1,84 @@
+package sort
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Describes the layout of the elements being sorted. Elements are either bare
+// keys or fixed-size records with the key somewhere inside them. Keys are
+// little-endian unsigned integers. Records are always moved whole, only the
+// key affects the sort order.
+type ElemFormat struct {
+	// Size of each key in bytes (4 or 8)
+	KeySize int
+
+	// Size of each record in bytes, zero means bare keys (RecordSize ==
+	// KeySize)
+	RecordSize int
+
+	// Byte offset of the key within each record
+	KeyOffset int
+}
+
+// Bare uint32 keys, the original (and default) format
+var Uint32Format = ElemFormat{KeySize: 4}
+
+// Bare uint64 keys
+var Uint64Format = ElemFormat{KeySize: 8}
+
+func (self ElemFormat) Validate() error {
+	if self.KeySize != 4 && self.KeySize != 8 {
+		return fmt.Errorf("Unsupported key size %v: must be 4 or 8 bytes", self.KeySize)
+	}
+
+	if self.RecordSize == 0 {
+		if self.KeyOffset != 0 {
+			return fmt.Errorf("Key offset (%v) requires a record size", self.KeyOffset)
+		}
+	} else if self.KeyOffset < 0 || self.KeyOffset+self.KeySize > self.RecordSize {
+		return fmt.Errorf("Key [%v, %v) doesn't fit in a %v byte record",
+			self.KeyOffset, self.KeyOffset+self.KeySize, self.RecordSize)
+	}
+	return nil
+}
+
+// Number of bytes in each element
+func (self ElemFormat) ElemSize() int {
+	if self.RecordSize == 0 {
+		return self.KeySize
+	}
+	return self.RecordSize
+}
+
+// True if elements carry more than just their key
+func (self ElemFormat) HasPayload() bool {
+	return self.ElemSize() != self.KeySize
+}
+
+// Number of bits in each key
+func (self ElemFormat) KeyBits() int {
+	return self.KeySize * 8
+}
+
+// Returns the key of the element starting at elem[0]
+func (self ElemFormat) Key(elem []byte) uint64 {
+	if self.KeySize == 8 {
+		return binary.LittleEndian.Uint64(elem[self.KeyOffset:])
+	}
+	return (uint64)(binary.LittleEndian.Uint32(elem[self.KeyOffset:]))
+}
+
+// Overwrite the key of the element starting at elem[0]
+func (self ElemFormat) putKey(elem []byte, key uint64) {
+	if self.KeySize == 8 {
+		binary.LittleEndian.PutUint64(elem[self.KeyOffset:], key)
+	} else {
+		binary.LittleEndian.PutUint32(elem[self.KeyOffset:], (uint32)(key))
+	}
+}
+
+// Isolate the radix group from a key of any size (returns the groupID)
+func KeyGroupBits(key uint64, offset int, width int) int {
+	return (int)((key >> (uint)(offset)) & ((1 << (uint)(width)) - 1))
+}
@@ -37,22 +37,44 @@ type BucketReader struct {
 	nArr   int // Number of arrays
 	nPart  int // Number of partitions (should be fixed for each array)
 
+	// ReadRef only returns refs that contain whole elements of this size
+	elemSize int
+
 	incIdx func() bool // Function to increment the index while iterating (modifies arrX and partX)
 }
 
 func NewBucketReader(sources []data.DistribArray, order ReadOrder) (*BucketReader, error) {
+	return NewAlignedBucketReader(sources, order, 1)
+}
+
+// Like NewBucketReader but for arrays of elemSize-byte elements. Every
+// partition must contain whole elements and ReadRef() may only be asked for
+// whole elements so that no element is ever split between two workers.
+func NewAlignedBucketReader(sources []data.DistribArray, order ReadOrder, elemSize int) (*BucketReader, error) {
 	var err error
 
+	if elemSize < 1 {
+		return nil, fmt.Errorf("Invalid element size %v", elemSize)
+	}
+
 	shapes := make([]*data.DistribArrayShape, len(sources))
 	for i := 0; i < len(sources); i++ {
 		if shapes[i], err = sources[i].GetShape(); err != nil {
 			return nil, err
 		}
+
+		for partX := 0; partX < shapes[i].NPart(); partX++ {
+			if shapes[i].Len(partX)%(int64)(elemSize) != 0 {
+				return nil, fmt.Errorf("Partition %v of array %v has length %v, not a multiple of the element size (%v)",
+					partX, i, shapes[i].Len(partX), elemSize)
+			}
+		}
 	}
 
 	reader := &BucketReader{arrs: sources, shapes: shapes,
 		arrX: 0, partX: 0,
 		nArr: len(sources), nPart: shapes[0].NPart(),
+		elemSize: elemSize,
 	}
 
 	if order == INORDER {
@@ -92,6 +114,10 @@ func (self *BucketReader) incIdxInOrder() bool {
 
 // Like Read but returns PartRefs instead of bytes
 func (self *BucketReader) ReadRef(sz int) ([]*data.PartRef, error) {
+	if sz%self.elemSize != 0 {
+		return nil, fmt.Errorf("Read size %v is not a multiple of the element size (%v)", sz, self.elemSize)
+	}
+
 	var out []*data.PartRef
 	nNeeded := sz
 
@@ -0,0 +1,102 @@
+package sort
+
+// Histogram pre-pass. If every key has the same digit for some pass (e.g.
+// the high bits of keys that fit in 16 bits), that pass is pure data movement.
+// StatsWorkers count the digits of their input (returning counts, not data)
+// and the driver skips passes whose digit never changes.
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+)
+
+// A radix digit: width bits starting at bit offset of the key
+type Digit struct {
+	Offset int `json:"offset"`
+	Width  int `json:"width"`
+}
+
+// What a distributed sort did
+type SortReport struct {
+	// Passes that were run
+	Passes []Digit
+
+	// Passes that were skipped because every key had the same digit (see
+	// SortOptions.Histogram)
+	Skipped []Digit
+}
+
+// Build the report for the sort described by manifest
+func newSortReport(manifest *SortManifest) *SortReport {
+	skip := make(map[int]bool)
+	for _, step := range manifest.Skipped {
+		skip[step] = true
+	}
+
+	report := &SortReport{}
+	for step, digit := range manifest.Options.passDigits() {
+		if skip[step] {
+			report.Skipped = append(report.Skipped, digit)
+		} else {
+			report.Passes = append(report.Passes, digit)
+		}
+	}
+	return report
+}
+
+// Like SortDistribFromArr but also reports which passes were run
+func SortDistribFromArrWithReport(ctx context.Context, arr data.DistribArray, sz int, baseName string,
+	factory *data.ArrayFactory, worker DistribWorker, opts *SortOptions) ([]data.DistribArray, *SortReport, error) {
+	if opts == nil {
+		opts = DefaultSortOptions()
+	}
+
+	manifest := &SortManifest{BaseName: baseName, Size: sz, Outputs: []string{""}, Options: opts}
+	tracker := newArrayTracker(factory)
+	outputs, err := sortDistrib(ctx, []data.DistribArray{arr}, manifest, tracker, worker)
+	if err != nil {
+		return nil, nil, cleanupFailedSort(err, tracker, manifest)
+	}
+	return outputs, newSortReport(manifest), nil
+}
+
+// Run opts.Histogram over arrs (nElem elements) and return the passes whose
+// digit is the same for every key. At least one pass is always left to run so
+// that the sort still produces new output arrays.
+func findConstantDigits(ctx context.Context, arrs []data.DistribArray, nElem int, opts *SortOptions,
+	nworker int, maxPerWorker int) ([]int, error) {
+
+	workerInputs, err := splitInputs(arrs, opts, nworker, maxPerWorker)
+	if err != nil {
+		return nil, err
+	}
+
+	digits := opts.passDigits()
+	stats, err := collectStats(ctx, workerInputs, digits, opts.Format(), opts.Histogram)
+	if err != nil {
+		return nil, err
+	}
+	if stats.Count != (int64)(nElem) {
+		return nil, fmt.Errorf("Workers counted %v elements, expected %v", stats.Count, nElem)
+	}
+
+	skipped := []int{}
+	for step := range digits {
+		nValue := 0
+		for _, count := range stats.Counts[step] {
+			if count != 0 {
+				nValue++
+			}
+		}
+		if nValue <= 1 {
+			skipped = append(skipped, step)
+		}
+	}
+
+	if len(skipped) == len(digits) {
+		skipped = skipped[:len(skipped)-1]
+	}
+	return skipped, nil
+}
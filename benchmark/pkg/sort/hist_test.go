@@ -0,0 +1,199 @@
+package sort
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+// Returns a DistribWorker that records the name of every task it runs
+func recordingWorker(names *[]string) DistribWorker {
+	var lock sync.Mutex
+	return func(ctx context.Context, inBkts []*data.PartRef, offset int, width int, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+		lock.Lock()
+		*names = append(*names, baseName)
+		lock.Unlock()
+		return LocalDistribWorker(ctx, inBkts, offset, width, format, baseName, factory)
+	}
+}
+
+// Generate nElem keys that fit in 16 bits
+func generateSmallKeys(t *testing.T, nElem int) []byte {
+	raw, err := GenerateInputs((uint64)(nElem))
+	require.Nil(t, err, "Failed to generate inputs")
+	for i := 0; i < len(raw); i += 4 {
+		binary.LittleEndian.PutUint32(raw[i:], binary.LittleEndian.Uint32(raw[i:])&0xffff)
+	}
+	return raw
+}
+
+func TestSortHistogramSkip(t *testing.T) {
+	origRaw := generateSmallKeys(t, 1111)
+
+	arr, err := data.MemArrayFactory.Create("testSortHistogramInput", data.CreateShapeUniform((int64)(len(origRaw)), 1))
+	require.Nil(t, err, "Failed to create input")
+	defer arr.Destroy()
+	writer, err := arr.GetPartWriter(0)
+	require.Nil(t, err, "Failed to get writer")
+	_, err = writer.Write(origRaw)
+	require.Nil(t, err, "Failed to write input")
+	writer.Close()
+
+	var names []string
+	opts := DefaultSortOptions()
+	opts.Cleanup = CleanupIntermediate
+	opts.Histogram = LocalStatsWorker
+	outArrs, report, err := SortDistribFromArrWithReport(context.Background(), arr, len(origRaw), "testSortHistogram",
+		data.MemArrayFactory, recordingWorker(&names), opts)
+	require.Nil(t, err, "Sort failed")
+
+	require.Equal(t, []Digit{{0, 8}, {8, 8}}, report.Passes, "Wrong passes run")
+	require.Equal(t, []Digit{{16, 8}, {24, 8}}, report.Skipped, "Wrong passes skipped")
+	require.Equal(t, 2*opts.NWorker, len(names), "Skipped passes still ran workers")
+
+	reader, err := NewBucketReader(outArrs, STRIDED)
+	require.Nil(t, err, "Couldn't read output")
+	outRaw := make([]byte, len(origRaw))
+	_, err = bucketRead(reader, outRaw)
+	require.Nil(t, err, "Couldn't read output")
+	require.Nil(t, CheckSort(origRaw, outRaw), "Sorted wrong")
+
+	for _, out := range outArrs {
+		out.Destroy()
+	}
+}
+
+// Skipping the first pass changes which pass consumes the input
+func TestSortHistogramSkipFirst(t *testing.T) {
+	origRaw, err := GenerateInputs(1111)
+	require.Nil(t, err, "Failed to generate inputs")
+	for i := 0; i < len(origRaw); i += 4 {
+		binary.LittleEndian.PutUint32(origRaw[i:], binary.LittleEndian.Uint32(origRaw[i:])&0xffffff00)
+	}
+
+	for _, cleanup := range []CleanupPolicy{CleanupAll, CleanupIntermediate} {
+		tmpDir, err := ioutil.TempDir("", "radixSortHistTest")
+		require.Nilf(t, err, "Couldn't create temporary test directory")
+		defer os.RemoveAll(tmpDir)
+
+		opts := DefaultSortOptions()
+		opts.Cleanup = cleanup
+		opts.Histogram = LocalStatsWorker
+		outRaw, err := SortDistribFromRaw(context.Background(), origRaw, "testSortHistogramFirst", data.NewFileArrayFactory(tmpDir),
+			LocalDistribWorker, opts)
+		require.Nil(t, err, "Sort failed")
+		require.Nil(t, CheckSort(origRaw, outRaw), "Sorted wrong")
+
+		entries, err := ioutil.ReadDir(tmpDir)
+		require.Nil(t, err, "Couldn't list array directory")
+		require.Zerof(t, len(entries), "Sort left arrays behind (cleanup %v)", cleanup)
+	}
+}
+
+// If every digit is constant, one pass still has to produce the output
+func TestSortHistogramConstant(t *testing.T) {
+	origRaw := make([]byte, 4*1000)
+	for i := 0; i < len(origRaw); i += 4 {
+		binary.LittleEndian.PutUint32(origRaw[i:], 0xdeadbeef)
+	}
+
+	var names []string
+	opts := DefaultSortOptions()
+	opts.Histogram = LocalStatsWorker
+	outRaw, err := SortDistribFromRaw(context.Background(), origRaw, "testSortHistogramConstant", data.MemArrayFactory,
+		recordingWorker(&names), opts)
+	require.Nil(t, err, "Sort failed")
+	require.Nil(t, CheckSort(origRaw, outRaw), "Sorted wrong")
+	require.Equal(t, opts.NWorker, len(names), "Should run exactly one pass")
+}
+
+// Resumed sorts must remember the skipped passes
+func TestSortHistogramResume(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortHistTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	factory := data.NewFileArrayFactory(tmpDir)
+	origRaw := generateSmallKeys(t, 1111)
+
+	opts := DefaultSortOptions()
+	opts.CheckpointDir = tmpDir
+	opts.KeepOnError = true
+	opts.Histogram = LocalStatsWorker
+	_, err = SortDistribFromRaw(context.Background(), origRaw, "testHistResume", factory, failingWorker("step1_worker0"), opts)
+	require.NotNil(t, err, "Sort succeeded with a failing worker")
+
+	manifest, err := LoadSortManifest(tmpDir, "testHistResume")
+	require.Nil(t, err, "Couldn't load manifest")
+	require.Equal(t, []int{2, 3}, manifest.Skipped, "Skipped passes not checkpointed")
+
+	var names []string
+	outArrs, err := ResumeSort(context.Background(), tmpDir, "testHistResume", factory, recordingWorker(&names))
+	require.Nil(t, err, "Failed to resume sort")
+	for _, name := range names {
+		require.Truef(t, strings.Contains(name, "step1"), "Resumed sort ran %v", name)
+	}
+
+	reader, err := NewBucketReader(outArrs, STRIDED)
+	require.Nil(t, err, "Couldn't read output")
+	outRaw := make([]byte, len(origRaw))
+	_, err = bucketRead(reader, outRaw)
+	require.Nil(t, err, "Couldn't read output")
+	require.Nil(t, CheckSort(origRaw, outRaw), "Resumed sort was wrong")
+}
+
+// Checkpoints can't refer to the caller's input, a sort of one only becomes
+// resumable once its first pass has finished
+func TestSortHistogramResumeFromArr(t *testing.T) {
+	origRaw := generateSmallKeys(t, 1111)
+
+	for _, failStep := range []string{"step0_worker0", "step1_worker0"} {
+		tmpDir, err := ioutil.TempDir("", "radixSortHistTest")
+		require.Nilf(t, err, "Couldn't create temporary test directory")
+		defer os.RemoveAll(tmpDir)
+
+		factory := data.NewFileArrayFactory(tmpDir)
+		opts := DefaultSortOptions()
+		opts.CheckpointDir = tmpDir
+		opts.KeepOnError = true
+		opts.Histogram = LocalStatsWorker
+
+		arr, err := createRawInput(origRaw, "callerInput", opts, factory)
+		require.Nil(t, err, "Failed to create input")
+
+		_, err = SortDistribFromArr(context.Background(), arr, len(origRaw), "testHistResumeArr", factory,
+			failingWorker(failStep), opts)
+		require.NotNil(t, err, "Sort succeeded with a failing worker")
+
+		if failStep == "step0_worker0" {
+			_, err = LoadSortManifest(tmpDir, "testHistResumeArr")
+			require.NotNil(t, err, "Checkpointed before the first pass")
+			_, err = ResumeSort(context.Background(), tmpDir, "testHistResumeArr", factory, LocalDistribWorker)
+			require.NotNil(t, err, "Resumed without a checkpoint")
+			require.Nil(t, arr.Destroy())
+			continue
+		}
+
+		manifest, err := LoadSortManifest(tmpDir, "testHistResumeArr")
+		require.Nil(t, err, "Couldn't load manifest")
+		require.Equal(t, []int{2, 3}, manifest.Skipped, "Skipped passes not checkpointed")
+		require.NotContains(t, manifest.Outputs, "", "Checkpoint refers to the caller's input")
+
+		outArrs, err := ResumeSort(context.Background(), tmpDir, "testHistResumeArr", factory, LocalDistribWorker)
+		require.Nil(t, err, "Failed to resume sort")
+
+		reader, err := NewBucketReader(outArrs, STRIDED)
+		require.Nil(t, err, "Couldn't read output")
+		outRaw := make([]byte, len(origRaw))
+		_, err = bucketRead(reader, outRaw)
+		require.Nil(t, err, "Couldn't read output")
+		require.Nil(t, CheckSort(origRaw, outRaw), "Resumed sort was wrong")
+	}
+}
@@ -0,0 +1,244 @@
+package sort
+
+// Order-preserving key encodings. The radix sort only understands unsigned
+// keys, other key types are transformed into unsigned keys with the same
+// ordering before sorting and transformed back afterwards.
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"math"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+)
+
+// How keys are interpreted (see SortOptions.KeyEncoding)
+type KeyEncoding int
+
+const (
+	// Unsigned integers, the native format of the sort
+	UnsignedKeys KeyEncoding = iota
+
+	// Two's complement signed integers (the sign bit is flipped)
+	SignedKeys
+
+	// IEEE 754 floats, float32 for 4-byte keys and float64 for 8-byte keys.
+	// Negative NaNs sort before everything, positive NaNs after.
+	FloatKeys
+)
+
+// Transform key (of keyBits bits) into an unsigned key with the same ordering
+func encodeKey(key uint64, keyBits int, enc KeyEncoding, descending bool) uint64 {
+	signBit := (uint64)(1) << (uint)(keyBits-1)
+	mask := signBit | (signBit - 1)
+
+	switch enc {
+	case SignedKeys:
+		key ^= signBit
+	case FloatKeys:
+		if key&signBit != 0 {
+			key = ^key & mask
+		} else {
+			key ^= signBit
+		}
+	}
+
+	if descending {
+		key = ^key & mask
+	}
+	return key
+}
+
+// Inverse of encodeKey
+func decodeKey(key uint64, keyBits int, enc KeyEncoding, descending bool) uint64 {
+	signBit := (uint64)(1) << (uint)(keyBits-1)
+	mask := signBit | (signBit - 1)
+
+	if descending {
+		key = ^key & mask
+	}
+
+	switch enc {
+	case SignedKeys:
+		key ^= signBit
+	case FloatKeys:
+		if key&signBit != 0 {
+			key ^= signBit
+		} else {
+			key = ^key & mask
+		}
+	}
+	return key
+}
+
+// Apply (or reverse if decode is set) the key encoding in opts to every
+// element of raw in place. This is a no-op for ascending unsigned keys.
+func transformKeys(raw []byte, opts *SortOptions, decode bool) {
+	if !opts.encodesKeys() {
+		return
+	}
+
+	format := opts.Format()
+	elemSz := format.ElemSize()
+	for i := 0; i+elemSz <= len(raw); i += elemSz {
+		key := format.Key(raw[i:])
+		if decode {
+			key = decodeKey(key, format.KeyBits(), opts.KeyEncoding, opts.Descending)
+		} else {
+			key = encodeKey(key, format.KeyBits(), opts.KeyEncoding, opts.Descending)
+		}
+		format.putKey(raw[i:], key)
+	}
+}
+
+// Sort raw (keySize-byte keys of type enc) with SortDistribFromRaw. opts may
+// be nil, its element format is overridden.
+func sortTyped(ctx context.Context, raw []byte, keySize int, enc KeyEncoding, baseName string,
+	factory *data.ArrayFactory, worker DistribWorker, opts *SortOptions) ([]byte, error) {
+
+	if len(raw) == 0 {
+		return raw, nil
+	}
+
+	typedOpts := DefaultSortOptions()
+	if opts != nil {
+		*typedOpts = *opts
+	}
+	typedOpts.KeySize = keySize
+	typedOpts.RecordSize = 0
+	typedOpts.KeyOffset = 0
+	typedOpts.KeyEncoding = enc
+
+	outRaw, err := SortDistribFromRaw(ctx, raw, baseName, factory, worker, typedOpts)
+	if outRaw == nil {
+		return nil, err
+	}
+	if len(outRaw) != len(raw) {
+		return nil, fmt.Errorf("Sort returned %v bytes, expected %v", len(outRaw), len(raw))
+	}
+	return outRaw, err
+}
+
+// Sort keys in place using the distributed sort (see SortDistribFromRaw). The
+// width, workers, read order, order (opts.Descending) etc. come from opts (may
+// be nil), the key format is set automatically.
+func SortUint32s(ctx context.Context, keys []uint32, baseName string,
+	factory *data.ArrayFactory, worker DistribWorker, opts *SortOptions) error {
+
+	raw := make([]byte, len(keys)*4)
+	for i, k := range keys {
+		binary.LittleEndian.PutUint32(raw[i*4:], k)
+	}
+
+	out, err := sortTyped(ctx, raw, 4, UnsignedKeys, baseName, factory, worker, opts)
+	if out == nil {
+		return err
+	}
+
+	for i := range keys {
+		keys[i] = binary.LittleEndian.Uint32(out[i*4:])
+	}
+	return err
+}
+
+// Like SortUint32s
+func SortInt32s(ctx context.Context, keys []int32, baseName string,
+	factory *data.ArrayFactory, worker DistribWorker, opts *SortOptions) error {
+
+	raw := make([]byte, len(keys)*4)
+	for i, k := range keys {
+		binary.LittleEndian.PutUint32(raw[i*4:], (uint32)(k))
+	}
+
+	out, err := sortTyped(ctx, raw, 4, SignedKeys, baseName, factory, worker, opts)
+	if out == nil {
+		return err
+	}
+
+	for i := range keys {
+		keys[i] = (int32)(binary.LittleEndian.Uint32(out[i*4:]))
+	}
+	return err
+}
+
+// Like SortUint32s. See FloatKeys for how NaNs are ordered.
+func SortFloat32s(ctx context.Context, keys []float32, baseName string,
+	factory *data.ArrayFactory, worker DistribWorker, opts *SortOptions) error {
+
+	raw := make([]byte, len(keys)*4)
+	for i, k := range keys {
+		binary.LittleEndian.PutUint32(raw[i*4:], math.Float32bits(k))
+	}
+
+	out, err := sortTyped(ctx, raw, 4, FloatKeys, baseName, factory, worker, opts)
+	if out == nil {
+		return err
+	}
+
+	for i := range keys {
+		keys[i] = math.Float32frombits(binary.LittleEndian.Uint32(out[i*4:]))
+	}
+	return err
+}
+
+// Like SortUint32s. 64-bit keys need a worker whose LocalSorter supports
+// them.
+func SortUint64s(ctx context.Context, keys []uint64, baseName string,
+	factory *data.ArrayFactory, worker DistribWorker, opts *SortOptions) error {
+
+	raw := make([]byte, len(keys)*8)
+	for i, k := range keys {
+		binary.LittleEndian.PutUint64(raw[i*8:], k)
+	}
+
+	out, err := sortTyped(ctx, raw, 8, UnsignedKeys, baseName, factory, worker, opts)
+	if out == nil {
+		return err
+	}
+
+	for i := range keys {
+		keys[i] = binary.LittleEndian.Uint64(out[i*8:])
+	}
+	return err
+}
+
+// Like SortUint64s
+func SortInt64s(ctx context.Context, keys []int64, baseName string,
+	factory *data.ArrayFactory, worker DistribWorker, opts *SortOptions) error {
+
+	raw := make([]byte, len(keys)*8)
+	for i, k := range keys {
+		binary.LittleEndian.PutUint64(raw[i*8:], (uint64)(k))
+	}
+
+	out, err := sortTyped(ctx, raw, 8, SignedKeys, baseName, factory, worker, opts)
+	if out == nil {
+		return err
+	}
+
+	for i := range keys {
+		keys[i] = (int64)(binary.LittleEndian.Uint64(out[i*8:]))
+	}
+	return err
+}
+
+// Like SortUint64s. See FloatKeys for how NaNs are ordered.
+func SortFloat64s(ctx context.Context, keys []float64, baseName string,
+	factory *data.ArrayFactory, worker DistribWorker, opts *SortOptions) error {
+
+	raw := make([]byte, len(keys)*8)
+	for i, k := range keys {
+		binary.LittleEndian.PutUint64(raw[i*8:], math.Float64bits(k))
+	}
+
+	out, err := sortTyped(ctx, raw, 8, FloatKeys, baseName, factory, worker, opts)
+	if out == nil {
+		return err
+	}
+
+	for i := range keys {
+		keys[i] = math.Float64frombits(binary.LittleEndian.Uint64(out[i*8:]))
+	}
+	return err
+}
@@ -0,0 +1,184 @@
+package sort
+
+import (
+	"bytes"
+	"context"
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyEncoding(t *testing.T) {
+	// Each list is in ascending order
+	signed := []int64{math.MinInt64, -1 << 40, -2, -1, 0, 1, 2, 1 << 40, math.MaxInt64}
+	floats := []float64{math.Inf(-1), -math.MaxFloat64, -1.5, -math.SmallestNonzeroFloat64,
+		0, math.SmallestNonzeroFloat64, 1.5, math.MaxFloat64, math.Inf(1)}
+
+	check := func(t *testing.T, keys []uint64, keyBits int, enc KeyEncoding) {
+		for _, descending := range []bool{false, true} {
+			encoded := make([]uint64, len(keys))
+			for i, k := range keys {
+				encoded[i] = encodeKey(k, keyBits, enc, descending)
+				require.Equalf(t, k, decodeKey(encoded[i], keyBits, enc, descending), "Key %v doesn't round trip", i)
+				if keyBits == 32 {
+					require.LessOrEqualf(t, encoded[i], (uint64)(math.MaxUint32), "Key %v encoded past 32 bits", i)
+				}
+			}
+
+			for i := 1; i < len(encoded); i++ {
+				if descending {
+					require.Greaterf(t, encoded[i-1], encoded[i], "Keys %v and %v in wrong order (descending)", i-1, i)
+				} else {
+					require.Lessf(t, encoded[i-1], encoded[i], "Keys %v and %v in wrong order", i-1, i)
+				}
+			}
+		}
+	}
+
+	t.Run("Int64", func(t *testing.T) {
+		keys := make([]uint64, len(signed))
+		for i, v := range signed {
+			keys[i] = (uint64)(v)
+		}
+		check(t, keys, 64, SignedKeys)
+	})
+
+	t.Run("Int32", func(t *testing.T) {
+		signed32 := []int32{math.MinInt32, -1 << 20, -1, 0, 1, 1 << 20, math.MaxInt32}
+		keys := make([]uint64, len(signed32))
+		for i, v := range signed32 {
+			keys[i] = (uint64)((uint32)(v))
+		}
+		check(t, keys, 32, SignedKeys)
+	})
+
+	t.Run("Float64", func(t *testing.T) {
+		keys := make([]uint64, len(floats))
+		for i, v := range floats {
+			keys[i] = math.Float64bits(v)
+		}
+		check(t, keys, 64, FloatKeys)
+	})
+
+	t.Run("Float32", func(t *testing.T) {
+		keys := make([]uint64, len(floats))
+		for i, v := range floats {
+			f := (float32)(v)
+			if math.Abs(v) == math.MaxFloat64 {
+				f = (float32)(math.Copysign(math.MaxFloat32, v))
+			} else if math.Abs(v) == math.SmallestNonzeroFloat64 {
+				f = (float32)(math.Copysign(math.SmallestNonzeroFloat32, v))
+			}
+			keys[i] = (uint64)(math.Float32bits(f))
+		}
+		check(t, keys, 32, FloatKeys)
+	})
+}
+
+func TestSortTyped(t *testing.T) {
+	ctx := context.Background()
+	worker := NewLocalDistribWorker(&goSorter{})
+	rng := rand.New(rand.NewSource(42))
+	nElem := 1111
+
+	t.Run("Int32", func(t *testing.T) {
+		keys := make([]int32, nElem)
+		for i := range keys {
+			keys[i] = (int32)(rng.Uint32())
+		}
+		ref := append([]int32{}, keys...)
+		sort.Slice(ref, func(i, j int) bool { return ref[i] < ref[j] })
+
+		err := SortInt32s(ctx, keys, "testSortInt32s", data.MemArrayFactory, worker, nil)
+		require.Nil(t, err, "Sort failed")
+		require.Equal(t, ref, keys, "Sorted wrong")
+	})
+
+	t.Run("Int64", func(t *testing.T) {
+		keys := make([]int64, nElem)
+		for i := range keys {
+			keys[i] = (int64)(rng.Uint64())
+		}
+		ref := append([]int64{}, keys...)
+		sort.Slice(ref, func(i, j int) bool { return ref[i] < ref[j] })
+
+		err := SortInt64s(ctx, keys, "testSortInt64s", data.MemArrayFactory, worker, nil)
+		require.Nil(t, err, "Sort failed")
+		require.Equal(t, ref, keys, "Sorted wrong")
+	})
+
+	t.Run("Uint64", func(t *testing.T) {
+		keys := make([]uint64, nElem)
+		for i := range keys {
+			keys[i] = rng.Uint64()
+		}
+		ref := append([]uint64{}, keys...)
+		sort.Slice(ref, func(i, j int) bool { return ref[i] < ref[j] })
+
+		err := SortUint64s(ctx, keys, "testSortUint64s", data.MemArrayFactory, worker, nil)
+		require.Nil(t, err, "Sort failed")
+		require.Equal(t, ref, keys, "Sorted wrong")
+	})
+
+	t.Run("Float32", func(t *testing.T) {
+		keys := make([]float32, nElem)
+		for i := range keys {
+			keys[i] = (float32)(rng.NormFloat64() * 1000)
+		}
+		keys[0] = (float32)(math.Inf(-1))
+		keys[1] = (float32)(math.Inf(1))
+		ref := append([]float32{}, keys...)
+		sort.Slice(ref, func(i, j int) bool { return ref[i] < ref[j] })
+
+		err := SortFloat32s(ctx, keys, "testSortFloat32s", data.MemArrayFactory, worker, nil)
+		require.Nil(t, err, "Sort failed")
+		require.Equal(t, ref, keys, "Sorted wrong")
+	})
+
+	t.Run("Float64Descending", func(t *testing.T) {
+		keys := make([]float64, nElem)
+		for i := range keys {
+			keys[i] = rng.NormFloat64() * 1e10
+		}
+		ref := append([]float64{}, keys...)
+		sort.Sort(sort.Reverse(sort.Float64Slice(ref)))
+
+		opts := DefaultSortOptions()
+		opts.Descending = true
+		opts.Width = 16
+		err := SortFloat64s(ctx, keys, "testSortFloat64s", data.MemArrayFactory, worker, opts)
+		require.Nil(t, err, "Sort failed")
+		require.Equal(t, ref, keys, "Sorted wrong")
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		err := SortUint32s(ctx, nil, "testSortEmpty", data.MemArrayFactory, worker, nil)
+		require.Nil(t, err, "Failed to sort nothing")
+	})
+}
+
+// Encoding happens on a copy, the caller's input must be left alone
+func TestSortRawEncodedInput(t *testing.T) {
+	orig, err := GenerateInputs(1111)
+	require.Nil(t, err, "Failed to generate inputs")
+	inRaw := append([]byte{}, orig...)
+
+	opts := DefaultSortOptions()
+	opts.KeyEncoding = SignedKeys
+	outRaw, err := SortDistribFromRaw(context.Background(), inRaw, "testSortRawEncoded", data.MemArrayFactory, LocalDistribWorker, opts)
+	require.Nil(t, err, "Sort failed")
+	require.True(t, bytes.Equal(orig, inRaw), "Input was modified")
+
+	ref := make([]int32, len(orig)/4)
+	out := make([]int32, len(orig)/4)
+	for i := range ref {
+		ref[i] = (int32)(Uint32Format.Key(orig[i*4:]))
+		out[i] = (int32)(Uint32Format.Key(outRaw[i*4:]))
+	}
+	sort.Slice(ref, func(i, j int) bool { return ref[i] < ref[j] })
+	require.Equal(t, ref, out, "Sorted wrong")
+}
@@ -1,3 +1,6 @@
+//go:build !nocuda
+// +build !nocuda
+
 package sort
 
 // These are go wrappers for libsort so I don't have to
@@ -7,6 +10,9 @@ package sort
 // Because of Go's inflexible type system, everything must be in terms of
 // []byte to avoid uneccesary copying and converting. By convention, 'len'
 // refers to the number of uint32s and 'size' refers to the number of bytes.
+//
+// Build with the 'nocuda' tag to replace these with the pure-Go
+// implementations in cpusort.go (see libsort_nocuda.go).
 
 // #cgo CFLAGS: -O3 -I../../../libsort --std=gnu99
 // #cgo LDFLAGS: -L../../../libsort -lsort
@@ -14,9 +20,12 @@ package sort
 import "C"
 import (
 	"errors"
+	"math"
 	"unsafe"
 )
 
+const defaultSorterName = LibsortGpuSorterName
+
 // Perform one-time initialization of libsort, this must be called at least once
 // per process (calls after the first do nothing)
 var libSortInitialized bool = false
@@ -42,6 +51,21 @@ func GpuFull(in []byte) error {
 	return nil
 }
 
+// Sort in using libsort's CPU implementation (std::sort)
+func ProvidedCpu(in []byte) error {
+	if len(in) == 0 {
+		return nil
+	}
+
+	cints := (*C.uint32_t)(unsafe.Pointer(&in[0]))
+	success, _ := C.providedCpu(cints, (C.size_t)(len(in)/4))
+	if !success {
+		return errors.New("libsort providedCpu failed\n")
+	}
+
+	return nil
+}
+
 // Interpret in as uint32s and sort by the radix of width bits starting at bit 'offset'
 // boundaries will contain the byte offset of each radix group after sorting
 func GpuPartial(in []byte, boundaries []int64, offset int, width int) error {
@@ -75,3 +99,69 @@ func GenerateInputs(len uint64) ([]byte, error) {
 
 	return arr, nil
 }
+
+// LocalSorter backed by libsort's GPU routines
+type libsortGpuSorter struct{}
+
+func (self *libsortGpuSorter) Name() string {
+	return LibsortGpuSorterName
+}
+
+func (self *libsortGpuSorter) Init() error {
+	return InitLibSort()
+}
+
+func (self *libsortGpuSorter) Partial(in []byte, boundaries []int64, offset int, width int, format ElemFormat) error {
+	if err := self.Caps().CheckFormat(format); err != nil {
+		return err
+	}
+	return GpuPartial(in, boundaries, offset, width)
+}
+
+func (self *libsortGpuSorter) Full(in []byte, format ElemFormat) error {
+	if err := self.Caps().CheckFormat(format); err != nil {
+		return err
+	}
+	return GpuFull(in)
+}
+
+func (self *libsortGpuSorter) Caps() LocalSorterCaps {
+	// gpuPartial only supports 32bit sizes (and keys)
+	return LocalSorterCaps{MaxElem: math.MaxInt32, Gpu: true, KeySizes: []int{4}}
+}
+
+// LocalSorter backed by libsort's CPU routines. libsort doesn't provide a CPU
+// partial sort so we use the pure-Go version for Partial().
+type libsortCpuSorter struct{}
+
+func (self *libsortCpuSorter) Name() string {
+	return LibsortCpuSorterName
+}
+
+func (self *libsortCpuSorter) Init() error {
+	return InitLibSort()
+}
+
+func (self *libsortCpuSorter) Partial(in []byte, boundaries []int64, offset int, width int, format ElemFormat) error {
+	if err := self.Caps().CheckFormat(format); err != nil {
+		return err
+	}
+	return CpuPartial(in, boundaries, offset, width)
+}
+
+func (self *libsortCpuSorter) Full(in []byte, format ElemFormat) error {
+	if err := self.Caps().CheckFormat(format); err != nil {
+		return err
+	}
+	return ProvidedCpu(in)
+}
+
+func (self *libsortCpuSorter) Caps() LocalSorterCaps {
+	// libsort only handles uint32s
+	return LocalSorterCaps{KeySizes: []int{4}}
+}
+
+func init() {
+	RegisterLocalSorter(&libsortGpuSorter{})
+	RegisterLocalSorter(&libsortCpuSorter{})
+}
@@ -0,0 +1,29 @@
+//go:build nocuda
+// +build nocuda
+
+package sort
+
+// CPU-only replacements for the libsort wrappers in libsort.go. These are
+// selected with the 'nocuda' build tag and allow the whole package (including
+// the distributed sort) to run on machines without CUDA or libsort.
+
+const defaultSorterName = GoSorterName
+
+func InitLibSort() error {
+	return nil
+}
+
+func GpuFull(in []byte) error {
+	return CpuFull(in)
+}
+
+// Interpret in as uint32s and sort by the radix of width bits starting at bit 'offset'
+// boundaries will contain the byte offset of each radix group after sorting
+func GpuPartial(in []byte, boundaries []int64, offset int, width int) error {
+	return CpuPartial(in, boundaries, offset, width)
+}
+
+// Generate 'len' uint32's and return the array as a byte slice (total bytes will be 4*len)
+func GenerateInputs(len uint64) ([]byte, error) {
+	return CpuGenerateInputs(len)
+}
@@ -0,0 +1,150 @@
+package sort
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Names of the built-in LocalSorters. Not all of these are available in every
+// build (e.g. the libsort sorters are missing with the 'nocuda' tag), use
+// LocalSorterNames() to see what is registered.
+const (
+	GoSorterName         = "go"
+	LibsortGpuSorterName = "gpu"
+	LibsortCpuSorterName = "libsortCpu"
+)
+
+// Describes what a LocalSorter can do
+type LocalSorterCaps struct {
+	// Maximum number of elements that can be sorted in a single call to
+	// Partial or Full. Zero means unlimited.
+	MaxElem int
+
+	// True if the sorter runs on a GPU (callers may need to reserve a device)
+	Gpu bool
+
+	// Key sizes (in bytes) that the sorter can handle. Empty means 4-byte
+	// keys only (sorters written before 64-bit keys leave it unset).
+	KeySizes []int
+
+	// True if the sorter can handle records with a payload (see
+	// ElemFormat.RecordSize), otherwise it only sorts bare keys
+	Records bool
+}
+
+// Returns an error if the sorter can't handle elements in format
+func (self LocalSorterCaps) CheckFormat(format ElemFormat) error {
+	if format.HasPayload() && !self.Records {
+		return fmt.Errorf("Records with a payload are not supported")
+	}
+
+	keySizes := self.KeySizes
+	if len(keySizes) == 0 {
+		keySizes = []int{4}
+	}
+	for _, keySize := range keySizes {
+		if keySize == format.KeySize {
+			return nil
+		}
+	}
+	return fmt.Errorf("Unsupported key size %v (supported sizes: %v)", format.KeySize, keySizes)
+}
+
+// A backend for sorting data in local memory. Inputs are byte slices
+// containing elements laid out as described by an ElemFormat.
+type LocalSorter interface {
+	// Unique name used to register and look up the sorter
+	Name() string
+
+	// Perform any one-time initialization. Init may be called multiple times,
+	// calls after the first should do nothing.
+	Init() error
+
+	// Sort in by the radix of width bits starting at bit 'offset' of each
+	// key. The sort must be stable. boundaries (2^width elements) will contain
+	// the byte offset of each radix group after sorting.
+	Partial(in []byte, boundaries []int64, offset int, width int, format ElemFormat) error
+
+	// Fully sort in, in place
+	Full(in []byte, format ElemFormat) error
+
+	Caps() LocalSorterCaps
+}
+
+var localSorters = map[string]LocalSorter{}
+var localSortersLock sync.RWMutex
+
+// Make sorter available through GetLocalSorter(). Names must be unique.
+func RegisterLocalSorter(sorter LocalSorter) error {
+	localSortersLock.Lock()
+	defer localSortersLock.Unlock()
+
+	if _, ok := localSorters[sorter.Name()]; ok {
+		return fmt.Errorf("LocalSorter %v already registered", sorter.Name())
+	}
+	localSorters[sorter.Name()] = sorter
+	return nil
+}
+
+func GetLocalSorter(name string) (LocalSorter, error) {
+	localSortersLock.RLock()
+	defer localSortersLock.RUnlock()
+
+	sorter, ok := localSorters[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown LocalSorter %v", name)
+	}
+	return sorter, nil
+}
+
+// Returns the names of all registered LocalSorters (in sorted order)
+func LocalSorterNames() []string {
+	localSortersLock.RLock()
+	defer localSortersLock.RUnlock()
+
+	names := make([]string, 0, len(localSorters))
+	for name := range localSorters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Returns the preferred sorter for this build (libsort's GPU sorter if
+// available, otherwise the pure-Go sorter).
+func DefaultLocalSorter() LocalSorter {
+	sorter, err := GetLocalSorter(defaultSorterName)
+	if err != nil {
+		// The default is always registered by this package
+		panic(err)
+	}
+	return sorter
+}
+
+// Pure-Go sorter (see cpusort.go)
+type goSorter struct{}
+
+func (self *goSorter) Name() string {
+	return GoSorterName
+}
+
+func (self *goSorter) Init() error {
+	return nil
+}
+
+func (self *goSorter) Partial(in []byte, boundaries []int64, offset int, width int, format ElemFormat) error {
+	return CpuPartialFormat(in, boundaries, offset, width, format)
+}
+
+func (self *goSorter) Full(in []byte, format ElemFormat) error {
+	return CpuFullFormat(in, format)
+}
+
+func (self *goSorter) Caps() LocalSorterCaps {
+	return LocalSorterCaps{KeySizes: []int{4, 8}, Records: true}
+}
+
+func init() {
+	RegisterLocalSorter(&goSorter{})
+}
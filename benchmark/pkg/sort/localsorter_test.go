@@ -0,0 +1,83 @@
+package sort
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLocalSorterRegistry(t *testing.T) {
+	names := LocalSorterNames()
+	require.Contains(t, names, GoSorterName, "Pure-Go sorter not registered")
+	require.Contains(t, names, DefaultLocalSorter().Name(), "Default sorter not registered")
+
+	err := RegisterLocalSorter(&goSorter{})
+	require.NotNil(t, err, "Registered a duplicate sorter")
+
+	_, err = GetLocalSorter("notARealSorter")
+	require.NotNil(t, err, "Returned a sorter that doesn't exist")
+}
+
+func TestLocalSorterCaps(t *testing.T) {
+	// Sorters that predate KeySizes only handle uint32 keys
+	var legacy LocalSorterCaps
+	require.Nil(t, legacy.CheckFormat(Uint32Format), "Rejected uint32 keys")
+	require.NotNil(t, legacy.CheckFormat(Uint64Format), "Accepted uint64 keys")
+
+	caps := LocalSorterCaps{KeySizes: []int{8}}
+	require.Nil(t, caps.CheckFormat(Uint64Format), "Rejected uint64 keys")
+	require.NotNil(t, caps.CheckFormat(Uint32Format), "Accepted an unlisted key size")
+	require.NotNil(t, caps.CheckFormat(ElemFormat{KeySize: 8, RecordSize: 16}), "Accepted records")
+}
+
+// Run the basic local and distributed tests against every registered sorter
+func TestLocalSorters(t *testing.T) {
+	for _, name := range LocalSorterNames() {
+		sorter, err := GetLocalSorter(name)
+		require.Nilf(t, err, "Failed to get sorter %v", name)
+
+		t.Run(name, func(t *testing.T) {
+			err := sorter.Init()
+			require.Nil(t, err, "Failed to initialize sorter")
+
+			for _, keySize := range sorter.Caps().KeySizes {
+				format := ElemFormat{KeySize: keySize}
+
+				t.Run(fmt.Sprintf("Full%v", format.KeyBits()), func(t *testing.T) {
+					test, err := GenerateInputs((uint64)(4099 * keySize / 4))
+					require.Nil(t, err, "Failed to generate inputs")
+
+					ref := make([]byte, len(test))
+					copy(ref, test)
+
+					err = sorter.Full(test, format)
+					require.Nil(t, err, "Error while sorting")
+
+					err = CheckSortFormat(ref, test, format)
+					require.Nilf(t, err, "Sorted Wrong: %v", err)
+				})
+
+				t.Run(fmt.Sprintf("Partial%v", format.KeyBits()), func(t *testing.T) {
+					width := 8
+					test, err := GenerateInputs((uint64)(1021 * keySize / 4))
+					require.Nil(t, err, "Failed to generate inputs")
+
+					ref := make([]byte, len(test))
+					copy(ref, test)
+
+					boundaries := make([]int64, 1<<width)
+					err = sorter.Partial(test, boundaries, 0, width, format)
+					require.Nil(t, err, "Error while sorting")
+
+					checkPartialFormat(t, test, boundaries, ref, format)
+				})
+			}
+
+			t.Run("DistribWorker", func(t *testing.T) {
+				DistribWorkerTest(t, data.MemArrayFactory, NewLocalDistribWorker(sorter))
+			})
+		})
+	}
+}
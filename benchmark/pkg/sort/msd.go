@@ -0,0 +1,423 @@
+package sort
+
+// Most-significant-digit (MSD) distributed sort. Instead of moving the whole
+// dataset once per digit like the LSD sort in distrib.go, the MSD sort
+// partitions the input on its top digit and then has a single worker fully
+// sort each bucket. Buckets that are too big for one worker are partitioned
+// again on the next digit.
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// Read inBkts in order and fully sort them (elements are laid out as
+// described by format). Returns a distributed array (generated by 'factory')
+// with a single partition containing the sorted output. The output array must
+// be named baseName+"_output" (see DistribWorker). Workers should give up if
+// ctx is cancelled.
+type FullWorker func(ctx context.Context, inBkts []*data.PartRef, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error)
+
+// A FullWorker that sorts in the local process using the default LocalSorter
+// for this build (see DefaultLocalSorter())
+func LocalFullWorker(ctx context.Context, inBkts []*data.PartRef, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+	return NewLocalFullWorker(DefaultLocalSorter())(ctx, inBkts, format, baseName, factory)
+}
+
+// Returns a FullWorker that sorts in the local process using sorter. Like
+// NewLocalDistribWorker, cancellation is only checked before starting.
+func NewLocalFullWorker(sorter LocalSorter) FullWorker {
+	return func(ctx context.Context, inBkts []*data.PartRef, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		return LocalSortFull(sorter, inBkts, format, baseName+"_output", factory)
+	}
+}
+
+// Read inBkts and fully sort them in the local process using sorter. The
+// output is written to a new single-partition array called outName.
+func LocalSortFull(sorter LocalSorter, inBkts []*data.PartRef, format ElemFormat,
+	outName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+	var err error
+
+	if err = sorter.Caps().CheckFormat(format); err != nil {
+		return nil, errors.Wrapf(err, "Local sorter %v can't sort this input", sorter.Name())
+	}
+
+	if err = sorter.Init(); err != nil {
+		return nil, errors.Wrapf(err, "Failed to initialize local sorter %v", sorter.Name())
+	}
+
+	totalLen := 0
+	for i := 0; i < len(inBkts); i++ {
+		totalLen += inBkts[i].NByte
+	}
+
+	elemSz := format.ElemSize()
+	if totalLen%elemSz != 0 {
+		return nil, fmt.Errorf("Input length %v is not a multiple of the element size (%v)", totalLen, elemSz)
+	}
+
+	maxElem := sorter.Caps().MaxElem
+	if maxElem != 0 && totalLen/elemSz > maxElem {
+		return nil, fmt.Errorf("Input too large for local sorter %v: %v elements (max %v)", sorter.Name(), totalLen/elemSz, maxElem)
+	}
+
+	inBytes, err := data.FetchPartRefs(inBkts)
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't read input references")
+	}
+
+	if err = sorter.Full(inBytes, format); err != nil {
+		return nil, errors.Wrap(err, "Local sort failed")
+	}
+
+	outArr, err := factory.Create(outName, data.CreateShape([]int64{(int64)(len(inBytes))}))
+	if err != nil {
+		return nil, errors.Wrap(err, "Could not allocate output")
+	}
+
+	writer, err := outArr.GetPartWriter(0)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to write output")
+	}
+	defer writer.Close()
+
+	n, err := writer.Write(inBytes)
+	if err != nil && err != io.EOF {
+		return nil, errors.Wrap(err, "Could not write to output")
+	}
+	if n != len(inBytes) {
+		return nil, fmt.Errorf("Could not write enough bytes to output: wanted %v, got %v", len(inBytes), n)
+	}
+
+	return outArr, nil
+}
+
+// MSD sort of arr (sz bytes). worker partitions the input on the most
+// significant digit (digits are taken from the top of opts.passWidths()),
+// then every bucket is fully sorted by finisher. Buckets bigger than
+// opts.MaxBucketBytes are partitioned again on the next digit first. This
+// moves the data twice (plus once per extra level) regardless of the key
+// size. Returns an ordered list of single-partition arrays containing the
+// sorted output. Failures are handled as in SortDistribFromArr.
+// Checkpointing is not supported.
+func SortMSDFromArr(ctx context.Context, arr data.DistribArray, sz int, baseName string,
+	factory *data.ArrayFactory, worker DistribWorker, finisher FullWorker, opts *SortOptions) ([]data.DistribArray, error) {
+	if opts == nil {
+		opts = DefaultSortOptions()
+	}
+
+	manifest := &SortManifest{BaseName: baseName, Size: sz, Options: opts}
+	tracker := newArrayTracker(factory)
+	outputs, err := sortMSD(ctx, arr, manifest, tracker, worker, finisher)
+	if err != nil {
+		return nil, cleanupFailedSort(err, tracker, manifest)
+	}
+	return outputs, nil
+}
+
+// Like SortDistribFromRaw but using an MSD sort (see SortMSDFromArr)
+func SortMSDFromRaw(ctx context.Context, inRaw []byte, baseName string,
+	factory *data.ArrayFactory, worker DistribWorker, finisher FullWorker, opts *SortOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultSortOptions()
+	}
+
+	inName := baseName + "_input"
+	manifest := &SortManifest{BaseName: baseName, Size: len(inRaw), Input: inName, Options: opts}
+	tracker := newArrayTracker(factory)
+
+	origArr, err := createRawInput(inRaw, inName, opts, tracker.trackingFactory())
+	if err == nil {
+		var outArrs []data.DistribArray
+		outArrs, err = sortMSD(ctx, origArr, manifest, tracker, worker, finisher)
+		if err == nil {
+			return finishRawSort(outArrs, origArr, len(inRaw), opts)
+		}
+	}
+	return nil, cleanupFailedSort(err, tracker, manifest)
+}
+
+// A range of keys that still needs sorting
+type msdBucket struct {
+	name string
+	refs []*data.PartRef
+	size int
+
+	// Number of digits already sorted on and the number of (low) key bits
+	// left to sort
+	depth int
+	nBit  int
+
+	// Sorted output of the bucket, in order
+	out []data.DistribArray
+}
+
+// State shared by every bucket of an MSD sort
+type msdSort struct {
+	opts     *SortOptions
+	format   ElemFormat
+	widths   []int
+	tracker  *arrayTracker
+	worker   DistribWorker
+	finisher DistribWorker
+
+	// Limits the number of worker tasks running at once
+	slots chan struct{}
+}
+
+// Implementation of SortMSDFromArr
+func sortMSD(ctx context.Context, arr data.DistribArray, manifest *SortManifest,
+	tracker *arrayTracker, worker DistribWorker, finisher FullWorker) ([]data.DistribArray, error) {
+
+	opts := manifest.Options
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Invalid sort options")
+	}
+	if opts.CheckpointDir != "" {
+		return nil, fmt.Errorf("MSD sorts can't be checkpointed")
+	}
+
+	format := opts.Format()
+	root := &msdBucket{name: manifest.BaseName + "_msd", size: manifest.Size, nBit: format.KeyBits()}
+	refs, err := arrayRefs(arr, manifest.Size, format)
+	if err != nil {
+		return nil, err
+	}
+	root.refs = refs
+
+	state := &msdSort{
+		opts:     opts,
+		format:   format,
+		widths:   opts.passWidths(),
+		tracker:  tracker,
+		worker:   worker,
+		finisher: fullAsDistrib(finisher),
+		slots:    make(chan struct{}, opts.nWorker(manifest.Size)),
+	}
+
+	// Nothing to sort but the input still has to be cleaned up
+	if root.size == 0 {
+		return nil, consumeInput(arr, manifest, tracker)
+	}
+
+	// The input is only needed for the first pass
+	destroyInput := func() error {
+		return consumeInput(arr, manifest, tracker)
+	}
+
+	if err = state.partition(ctx, root, destroyInput); err != nil {
+		return nil, err
+	}
+	if err = tracker.wait(); err != nil {
+		return nil, errors.Wrap(err, "Failed to destroy one or more intermediate arrays")
+	}
+	return root.out, nil
+}
+
+// Wrap a FullWorker so that it can go through the same retry logic
+// (runWorkerTask) as partitioning workers
+func fullAsDistrib(finisher FullWorker) DistribWorker {
+	return func(ctx context.Context, inBkts []*data.PartRef, offset int, width int, format ElemFormat,
+		baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+		return finisher(ctx, inBkts, format, baseName, factory)
+	}
+}
+
+// Returns refs to every (non-empty) partition of arr, which must hold sz
+// bytes of whole elements
+func arrayRefs(arr data.DistribArray, sz int, format ElemFormat) ([]*data.PartRef, error) {
+	shape, err := arr.GetShape()
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't get input shape")
+	}
+
+	var refs []*data.PartRef
+	total := 0
+	for i := 0; i < shape.NPart(); i++ {
+		partLen := (int)(shape.Len(i))
+		if partLen%format.ElemSize() != 0 {
+			return nil, fmt.Errorf("Input partition %v has length %v, not a multiple of the element size (%v)",
+				i, partLen, format.ElemSize())
+		}
+		if partLen != 0 {
+			refs = append(refs, &data.PartRef{Arr: arr, PartIdx: i, Start: 0, NByte: partLen})
+			total += partLen
+		}
+	}
+	if total != sz {
+		return nil, fmt.Errorf("Input has %v bytes, expected %v", total, sz)
+	}
+	return refs, nil
+}
+
+// Destroy a sort's input once it has been read (if the options say to)
+func consumeInput(arr data.DistribArray, manifest *SortManifest, tracker *arrayTracker) error {
+	if manifest.Options.Cleanup != CleanupAll {
+		return nil
+	}
+	if err := tracker.destroyInput(manifest.Input, arr); err != nil {
+		return errors.Wrap(err, "Failed to destroy sort input")
+	}
+	return nil
+}
+
+// Run a single worker task once a slot is free
+func (self *msdSort) runTask(ctx context.Context, worker DistribWorker, inputs []*data.PartRef,
+	offset int, width int, name string) (data.DistribArray, string, error) {
+
+	select {
+	case self.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, "", ctx.Err()
+	}
+	defer func() { <-self.slots }()
+
+	return runWorkerTask(ctx, worker, inputs, offset, width, self.format, name, self.tracker, self.opts)
+}
+
+// Sort a bucket, either by finishing it with one worker or by partitioning it
+// on its next digit
+func (self *msdSort) sortBucket(ctx context.Context, bkt *msdBucket) error {
+	finish := bkt.depth == len(self.widths) ||
+		self.opts.MaxBucketBytes == 0 || bkt.size <= self.opts.MaxBucketBytes
+	if !finish {
+		// Our inputs are shared with our siblings, the parent destroys them
+		return self.partition(ctx, bkt, nil)
+	}
+
+	out, _, err := self.runTask(ctx, self.finisher, bkt.refs, 0, 0, bkt.name+"_full")
+	if err != nil {
+		return errors.Wrapf(err, "Failed to sort bucket %v", bkt.name)
+	}
+	bkt.out = []data.DistribArray{out}
+	return nil
+}
+
+// Partition bkt on its next digit and sort each resulting bucket. If set,
+// consumed is called once bkt's inputs are no longer needed.
+func (self *msdSort) partition(ctx context.Context, bkt *msdBucket, consumed func() error) error {
+	width := self.widths[len(self.widths)-1-bkt.depth]
+	offset := bkt.nBit - width
+
+	nworker := self.opts.nWorker(bkt.size)
+	nElem := bkt.size / self.format.ElemSize()
+	perWorker := ((nElem + nworker - 1) / nworker) * self.format.ElemSize()
+	inputs := splitRefs(bkt.refs, perWorker)
+
+	parts := make([]data.DistribArray, len(inputs))
+	partNames := make([]string, len(inputs))
+	group, groupCtx := errgroup.WithContext(ctx)
+	for i := range inputs {
+		id := i
+		group.Go(func() error {
+			var err error
+			parts[id], partNames[id], err = self.runTask(groupCtx, self.worker, inputs[id], offset, width,
+				fmt.Sprintf("%v_part%v", bkt.name, id))
+			if err != nil {
+				return errors.Wrapf(err, "Failed to partition bucket %v (worker %v)", bkt.name, id)
+			}
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return err
+	}
+
+	if consumed != nil {
+		if err := consumed(); err != nil {
+			return err
+		}
+	}
+
+	// Bucket i is partition i of every worker's output (in worker order so
+	// that the sort stays stable)
+	shapes := make([]*data.DistribArrayShape, len(parts))
+	for i, part := range parts {
+		var err error
+		if shapes[i], err = part.GetShape(); err != nil {
+			return errors.Wrapf(err, "Couldn't get shape of %v", partNames[i])
+		}
+	}
+
+	var children []*msdBucket
+	for digit := 0; digit < 1<<width; digit++ {
+		child := &msdBucket{name: fmt.Sprintf("%v_%v", bkt.name, digit), depth: bkt.depth + 1, nBit: offset}
+		for i, part := range parts {
+			partLen := (int)(shapes[i].Len(digit))
+			if partLen != 0 {
+				child.refs = append(child.refs, &data.PartRef{Arr: part, PartIdx: digit, Start: 0, NByte: partLen})
+				child.size += partLen
+			}
+		}
+		if child.size != 0 {
+			children = append(children, child)
+		}
+	}
+
+	group, groupCtx = errgroup.WithContext(ctx)
+	for _, child := range children {
+		child := child
+		group.Go(func() error {
+			return self.sortBucket(groupCtx, child)
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return err
+	}
+
+	for _, child := range children {
+		bkt.out = append(bkt.out, child.out...)
+	}
+
+	if self.opts.Cleanup != CleanupNone {
+		for i, part := range parts {
+			if err := self.tracker.destroyInput(partNames[i], part); err != nil {
+				return errors.Wrapf(err, "Failed to destroy %v", partNames[i])
+			}
+		}
+	}
+
+	return nil
+}
+
+// Split refs into consecutive groups of sz bytes (the last group may be
+// smaller). sz must be a multiple of the element size and refs must contain
+// whole elements.
+func splitRefs(refs []*data.PartRef, sz int) [][]*data.PartRef {
+	var groups [][]*data.PartRef
+	var cur []*data.PartRef
+	nNeeded := sz
+
+	for _, ref := range refs {
+		start := ref.Start
+		remaining := ref.NByte
+		for remaining > 0 {
+			n := remaining
+			if n > nNeeded {
+				n = nNeeded
+			}
+			cur = append(cur, &data.PartRef{Arr: ref.Arr, PartIdx: ref.PartIdx, Start: start, NByte: n})
+			start += n
+			remaining -= n
+			nNeeded -= n
+
+			if nNeeded == 0 {
+				groups = append(groups, cur)
+				cur = nil
+				nNeeded = sz
+			}
+		}
+	}
+	if cur != nil {
+		groups = append(groups, cur)
+	}
+	return groups
+}
@@ -0,0 +1,178 @@
+package sort
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func testSortMSD(t *testing.T, baseName string, origRaw []byte, factory *data.ArrayFactory, opts *SortOptions) {
+	worker := NewLocalDistribWorker(&goSorter{})
+	finisher := NewLocalFullWorker(&goSorter{})
+
+	outRaw, err := SortMSDFromRaw(context.Background(), origRaw, baseName, factory, worker, finisher, opts)
+	require.Nil(t, err, "Sort Error")
+
+	err = CheckSortFormat(origRaw, outRaw, opts.Format())
+	require.Nil(t, err, "Sorted Wrong")
+}
+
+func TestSortMSD(t *testing.T) {
+	origRaw, err := GenerateInputs(1111)
+	require.Nil(t, err, "Failed to generate inputs")
+
+	t.Run("Mem", func(t *testing.T) {
+		testSortMSD(t, "testSortMSD", origRaw, data.MemArrayFactory, DefaultSortOptions())
+	})
+
+	t.Run("File", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "radixSortMSDTest")
+		require.Nilf(t, err, "Couldn't create temporary test directory")
+		defer os.RemoveAll(tmpDir)
+
+		testSortMSD(t, "testSortMSD", origRaw, data.NewFileArrayFactory(tmpDir), DefaultSortOptions())
+
+		entries, err := ioutil.ReadDir(tmpDir)
+		require.Nil(t, err, "Couldn't list array directory")
+		require.Zero(t, len(entries), "Sort left arrays behind")
+	})
+
+	// Small buckets force extra MSD passes
+	t.Run("Recursive", func(t *testing.T) {
+		opts := DefaultSortOptions()
+		opts.Width = 4
+		opts.MaxBucketBytes = 64
+		testSortMSD(t, "testSortMSDRecursive", origRaw, data.MemArrayFactory, opts)
+	})
+
+	t.Run("Records", func(t *testing.T) {
+		opts := DefaultSortOptions()
+		opts.KeySize = 8
+		opts.RecordSize = 24
+		opts.KeyOffset = 8
+		opts.NWorker = 3
+		recRaw, err := GenerateRecords(1111, opts.Format())
+		require.Nil(t, err, "Failed to generate inputs")
+
+		testSortMSD(t, "testSortMSDRecords", recRaw, data.MemArrayFactory, opts)
+	})
+
+	// Empty inputs are still cleaned up
+	t.Run("Empty", func(t *testing.T) {
+		for _, cleanup := range []CleanupPolicy{CleanupAll, CleanupIntermediate} {
+			tmpDir, err := ioutil.TempDir("", "radixSortMSDTest")
+			require.Nilf(t, err, "Couldn't create temporary test directory")
+			defer os.RemoveAll(tmpDir)
+
+			opts := DefaultSortOptions()
+			opts.Cleanup = cleanup
+			outRaw, err := SortMSDFromRaw(context.Background(), []byte{}, "testSortMSDEmpty", data.NewFileArrayFactory(tmpDir),
+				LocalDistribWorker, LocalFullWorker, opts)
+			require.Nil(t, err, "Sort Error")
+			require.Zero(t, len(outRaw))
+
+			entries, err := ioutil.ReadDir(tmpDir)
+			require.Nil(t, err, "Couldn't list array directory")
+			require.Zerof(t, len(entries), "Sort left arrays behind (cleanup %v)", cleanup)
+		}
+	})
+
+	t.Run("Checkpoint", func(t *testing.T) {
+		opts := DefaultSortOptions()
+		opts.CheckpointDir = os.TempDir()
+		_, err := SortMSDFromRaw(context.Background(), origRaw, "testSortMSDCheckpoint", data.MemArrayFactory,
+			LocalDistribWorker, LocalFullWorker, opts)
+		require.NotNil(t, err, "MSD sort accepted a checkpoint directory")
+	})
+}
+
+// Every key shares its top bits so the first pass puts everything in one
+// bucket
+func TestSortMSDSkewed(t *testing.T) {
+	origRaw, err := GenerateInputs(1111)
+	require.Nil(t, err, "Failed to generate inputs")
+	for i := 0; i < len(origRaw); i += 4 {
+		key := binary.LittleEndian.Uint32(origRaw[i:])
+		binary.LittleEndian.PutUint32(origRaw[i:], 0xab000000|(key&0x0000ffff))
+	}
+
+	opts := DefaultSortOptions()
+	opts.MaxBucketBytes = 256
+
+	tmpDir, err := ioutil.TempDir("", "radixSortMSDTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	testSortMSD(t, "testSortMSDSkewed", origRaw, data.NewFileArrayFactory(tmpDir), opts)
+
+	entries, err := ioutil.ReadDir(tmpDir)
+	require.Nil(t, err, "Couldn't list array directory")
+	require.Zero(t, len(entries), "Sort left arrays behind")
+}
+
+func TestSortMSDFailure(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortMSDTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+
+	origRaw, err := GenerateInputs(1111)
+	require.Nil(t, err, "Failed to generate inputs")
+
+	opts := DefaultSortOptions()
+	opts.Width = 4
+	opts.MaxBucketBytes = 64
+
+	nCall := 0
+	finisher := func(ctx context.Context, inBkts []*data.PartRef, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+		out, err := LocalFullWorker(ctx, inBkts, format, baseName, factory)
+		if err == nil && nCall == 5 {
+			err = context.DeadlineExceeded
+		}
+		nCall++
+		return out, err
+	}
+
+	// One worker at a time so that nCall isn't racy
+	opts.NWorker = 1
+	_, err = SortMSDFromRaw(context.Background(), origRaw, "testSortMSDFailure", data.NewFileArrayFactory(tmpDir),
+		LocalDistribWorker, finisher, opts)
+	require.NotNil(t, err, "Failure not reported")
+
+	entries, err := ioutil.ReadDir(tmpDir)
+	require.Nil(t, err, "Couldn't list array directory")
+	require.Zero(t, len(entries), "Failed sort left arrays behind")
+}
+
+func TestSplitRefs(t *testing.T) {
+	refs := []*data.PartRef{
+		{PartIdx: 0, Start: 0, NByte: 12},
+		{PartIdx: 1, Start: 4, NByte: 4},
+		{PartIdx: 2, Start: 0, NByte: 20},
+	}
+
+	groups := splitRefs(refs, 8)
+	require.Equal(t, 5, len(groups), "Wrong number of groups")
+
+	total := 0
+	for i, group := range groups {
+		groupSz := 0
+		for _, ref := range group {
+			groupSz += ref.NByte
+		}
+		if i != len(groups)-1 {
+			require.Equalf(t, 8, groupSz, "Group %v has the wrong size", i)
+		}
+		total += groupSz
+	}
+	require.Equal(t, 36, total, "Groups don't cover the input")
+
+	// Second group straddles partitions 0 and 1
+	require.Equal(t, 2, len(groups[1]))
+	require.Equal(t, &data.PartRef{PartIdx: 0, Start: 8, NByte: 4}, groups[1][0])
+	require.Equal(t, &data.PartRef{PartIdx: 1, Start: 4, NByte: 4}, groups[1][1])
+}
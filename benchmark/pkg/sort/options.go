@@ -0,0 +1,258 @@
+package sort
+
+import (
+	"fmt"
+	"time"
+)
+
+// Largest radix width we'll use for a single pass. Each pass creates 2^width
+// partitions per worker so this is already very large.
+const MaxSortWidth = 16
+
+// What SortDistribFromArr/SortDistribFromRaw should destroy while sorting.
+// Final outputs of SortDistribFromArr are never destroyed, they belong to the
+// caller.
+type CleanupPolicy int
+
+const (
+	// Destroy the input array and every intermediate array as soon as it has
+	// been consumed (this minimizes the space used)
+	CleanupAll CleanupPolicy = iota
+
+	// Destroy intermediate arrays but leave the caller's input array alone
+	CleanupIntermediate
+
+	// Don't destroy anything. Useful for debugging.
+	CleanupNone
+)
+
+// Configuration for a distributed sort. Use DefaultSortOptions() to get a
+// valid starting point. Options are only read by the sort, the same
+// SortOptions may be used by concurrent sorts.
+type SortOptions struct {
+	// Radix width (in bits) of each pass. Must evenly divide the key size,
+	// ignored if WidthSchedule is set.
+	Width int
+
+	// Explicit radix width for each pass (e.g. []int{8, 8, 16}). Passes run in
+	// order starting from the least significant bit. Widths must add up to
+	// the key size.
+	WidthSchedule []int
+
+	// Number of workers per pass (degree of parallelism), ignored if
+	// BytesPerWorker is set
+	NWorker int
+
+	// If non-zero, use as many workers as needed to give each one roughly
+	// this many bytes of input
+	BytesPerWorker int
+
+	// How to traverse the previous pass's outputs when assigning worker
+	// inputs. Only STRIDED gives a correct sort with more than one worker so
+	// INORDER requires NWorker == 1 (and no BytesPerWorker).
+	ReadOrder ReadOrder
+
+	// Size of each key in bytes, 4 (uint32) or 8 (uint64). Keys are
+	// little-endian. Not every LocalSorter supports 8-byte keys (see
+	// LocalSorterCaps).
+	KeySize int
+
+	// Size of each record in bytes. Zero (the default) means the input is
+	// just keys, otherwise each record holds a KeySize key at KeyOffset and
+	// records are moved whole. Not every LocalSorter supports records.
+	RecordSize int
+	KeyOffset  int
+
+	// How to interpret keys (unsigned by default). SortDistribFromRaw encodes
+	// keys into order-preserving unsigned keys on the way in and decodes them
+	// on the way out. SortDistribFromArr expects already-encoded keys and
+	// returns encoded keys.
+	KeyEncoding KeyEncoding
+
+	// Sort largest first (SortDistribFromRaw only, see KeyEncoding)
+	Descending bool
+
+	// Which arrays to destroy while sorting
+	Cleanup CleanupPolicy
+
+	// Keep every array created by a failed sort (normally they are all
+	// destroyed). Useful for debugging. CleanupNone implies this.
+	KeepOnError bool
+
+	// Number of times to try each worker's task before giving up on the sort
+	MaxAttempts int
+
+	// How long to wait before the first retry of a failed task, doubles after
+	// each failure
+	RetryBackoff time.Duration
+
+	// If non-zero, launch a duplicate of any task that takes longer than this.
+	// Whichever attempt finishes first is used, the other one is destroyed in
+	// the background when it finishes. The sort still waits for it before
+	// returning.
+	SpeculateAfter time.Duration
+
+	// MSD sorts only (see SortMSDFromArr): buckets bigger than this are
+	// partitioned again on the next digit instead of being sorted by a single
+	// worker. Zero means every bucket is sorted after the first pass.
+	MaxBucketBytes int
+
+	// Sample sorts only (see SortSampleFromArr): fraction of the input's
+	// elements to read when choosing splitters. Small inputs are
+	// oversampled so that there are always a few samples per bucket.
+	SampleFraction float64
+
+	// If set, run a histogram pre-pass with this worker and skip any pass
+	// whose digit is the same for every key (LSD sorts only). This isn't
+	// saved in checkpoints, resumed sorts reuse the original pre-pass.
+	Histogram StatsWorker `json:"-"`
+
+	// If set, a SortManifest is written to this directory after every pass
+	// (usually the root of a file ArrayFactory). If the driver dies, the sort
+	// can be continued with ResumeSort(). Failed sorts remove their manifest
+	// unless their arrays are kept (see KeepOnError).
+	CheckpointDir string
+}
+
+func DefaultSortOptions() *SortOptions {
+	return &SortOptions{
+		Width:          8,
+		NWorker:        2,
+		ReadOrder:      STRIDED,
+		KeySize:        4,
+		Cleanup:        CleanupAll,
+		MaxAttempts:    1,
+		RetryBackoff:   100 * time.Millisecond,
+		SampleFraction: 0.01,
+	}
+}
+
+// Check that the options describe a sort we can run
+func (self *SortOptions) Validate() error {
+	format := self.Format()
+	if err := format.Validate(); err != nil {
+		return err
+	}
+	keyBits := format.KeyBits()
+
+	if self.WidthSchedule != nil {
+		if len(self.WidthSchedule) == 0 {
+			return fmt.Errorf("Width schedule is empty")
+		}
+
+		total := 0
+		for pass, width := range self.WidthSchedule {
+			if width <= 0 || width > MaxSortWidth {
+				return fmt.Errorf("Invalid width %v for pass %v: must be between 1 and %v", width, pass, MaxSortWidth)
+			}
+			total += width
+		}
+		if total != keyBits {
+			return fmt.Errorf("Width schedule covers %v bits but keys have %v bits", total, keyBits)
+		}
+	} else {
+		if self.Width <= 0 || self.Width > MaxSortWidth {
+			return fmt.Errorf("Invalid width %v: must be between 1 and %v", self.Width, MaxSortWidth)
+		}
+		if keyBits%self.Width != 0 {
+			return fmt.Errorf("Width %v does not evenly divide the key size (%v bits)", self.Width, keyBits)
+		}
+	}
+
+	if self.MaxBucketBytes < 0 {
+		return fmt.Errorf("Invalid max bucket size: %v", self.MaxBucketBytes)
+	}
+
+	if self.SampleFraction < 0 || self.SampleFraction > 1 {
+		return fmt.Errorf("Invalid sample fraction %v: must be between 0 and 1", self.SampleFraction)
+	}
+
+	if self.BytesPerWorker < 0 {
+		return fmt.Errorf("Invalid bytes per worker: %v", self.BytesPerWorker)
+	} else if self.BytesPerWorker == 0 && self.NWorker <= 0 {
+		return fmt.Errorf("Invalid number of workers: %v", self.NWorker)
+	}
+
+	switch self.KeyEncoding {
+	case UnsignedKeys, SignedKeys, FloatKeys:
+	default:
+		return fmt.Errorf("Unrecognized key encoding: %v", self.KeyEncoding)
+	}
+
+	if self.ReadOrder != INORDER && self.ReadOrder != STRIDED {
+		return fmt.Errorf("Unrecognized read order: %v", self.ReadOrder)
+	}
+	if self.ReadOrder == INORDER && (self.NWorker != 1 || self.BytesPerWorker != 0) {
+		return fmt.Errorf("INORDER reads only sort correctly with a single worker")
+	}
+
+	if self.MaxAttempts < 1 {
+		return fmt.Errorf("Invalid max attempts %v: must be at least 1", self.MaxAttempts)
+	}
+	if self.RetryBackoff < 0 || self.SpeculateAfter < 0 {
+		return fmt.Errorf("Retry backoff and speculation threshold must not be negative")
+	}
+
+	switch self.Cleanup {
+	case CleanupAll, CleanupIntermediate, CleanupNone:
+	default:
+		return fmt.Errorf("Unrecognized cleanup policy: %v", self.Cleanup)
+	}
+
+	return nil
+}
+
+// Layout of the elements being sorted
+func (self *SortOptions) Format() ElemFormat {
+	return ElemFormat{KeySize: self.KeySize, RecordSize: self.RecordSize, KeyOffset: self.KeyOffset}
+}
+
+// True if keys need to be transformed before (and after) sorting
+func (self *SortOptions) encodesKeys() bool {
+	return self.KeyEncoding != UnsignedKeys || self.Descending
+}
+
+// Returns the radix width of every pass. Options must be valid.
+func (self *SortOptions) passWidths() []int {
+	if self.WidthSchedule != nil {
+		return self.WidthSchedule
+	}
+
+	nPass := self.Format().KeyBits() / self.Width
+	widths := make([]int, nPass)
+	for i := 0; i < nPass; i++ {
+		widths[i] = self.Width
+	}
+	return widths
+}
+
+// Returns the digit sorted by every pass. Options must be valid.
+func (self *SortOptions) passDigits() []Digit {
+	widths := self.passWidths()
+	digits := make([]Digit, len(widths))
+	offset := 0
+	for i, width := range widths {
+		digits[i] = Digit{Offset: offset, Width: width}
+		offset += width
+	}
+	return digits
+}
+
+// Returns the number of workers to use for sz bytes of input. Every worker
+// gets at least one element (except for empty inputs which still use one
+// worker). Options must be valid.
+func (self *SortOptions) nWorker(sz int) int {
+	nworker := self.NWorker
+	if self.BytesPerWorker != 0 {
+		nworker = (sz + self.BytesPerWorker - 1) / self.BytesPerWorker
+	}
+
+	nElem := sz / self.Format().ElemSize()
+	if nworker > nElem {
+		nworker = nElem
+	}
+	if nworker < 1 {
+		nworker = 1
+	}
+	return nworker
+}
@@ -0,0 +1,183 @@
+package sort
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortOptionsValidate(t *testing.T) {
+	require.Nil(t, DefaultSortOptions().Validate(), "Default options are invalid")
+
+	badOpts := []func(o *SortOptions){
+		func(o *SortOptions) { o.Width = 0 },
+		func(o *SortOptions) { o.Width = 5 },
+		func(o *SortOptions) { o.Width = 32 },
+		func(o *SortOptions) { o.WidthSchedule = []int{} },
+		func(o *SortOptions) { o.WidthSchedule = []int{8, 8} },
+		func(o *SortOptions) { o.WidthSchedule = []int{16, 0, 16} },
+		func(o *SortOptions) { o.NWorker = 0 },
+		func(o *SortOptions) { o.BytesPerWorker = -1 },
+		func(o *SortOptions) { o.ReadOrder = 42 },
+		func(o *SortOptions) { o.ReadOrder = INORDER; o.NWorker = 2 },
+		func(o *SortOptions) { o.ReadOrder = INORDER; o.NWorker = 1; o.BytesPerWorker = 100 },
+		func(o *SortOptions) { o.KeySize = 2 },
+		func(o *SortOptions) { o.KeySize = 8; o.WidthSchedule = []int{16, 16} },
+		func(o *SortOptions) { o.KeyOffset = 4 },
+		func(o *SortOptions) { o.RecordSize = 16; o.KeyOffset = 14 },
+		func(o *SortOptions) { o.RecordSize = 16; o.KeyOffset = -1 },
+		func(o *SortOptions) { o.KeyEncoding = 42 },
+		func(o *SortOptions) { o.Cleanup = 42 },
+	}
+	for i, modify := range badOpts {
+		opts := DefaultSortOptions()
+		modify(opts)
+		require.NotNilf(t, opts.Validate(), "Accepted invalid options %v: %+v", i, opts)
+	}
+
+	opts := DefaultSortOptions()
+	opts.Width = 5
+	opts.WidthSchedule = []int{4, 12, 16}
+	require.Nil(t, opts.Validate(), "Schedule should override width")
+	require.Equal(t, []int{4, 12, 16}, opts.passWidths())
+
+	opts = DefaultSortOptions()
+	opts.Width = 16
+	require.Equal(t, []int{16, 16}, opts.passWidths())
+
+	opts.KeySize = 8
+	require.Nil(t, opts.Validate(), "Rejected 64-bit keys")
+	require.Equal(t, []int{16, 16, 16, 16}, opts.passWidths())
+
+	opts.NWorker = 0
+	opts.BytesPerWorker = 100
+	require.Equal(t, 1, opts.nWorker(0))
+	require.Equal(t, 1, opts.nWorker(100))
+	require.Equal(t, 2, opts.nWorker(101))
+
+	// Never more workers than elements
+	opts.BytesPerWorker = 1
+	require.Equal(t, 3, opts.nWorker(24))
+	opts.BytesPerWorker = 0
+	opts.NWorker = 5
+	require.Equal(t, 3, opts.nWorker(24))
+	require.Equal(t, 1, opts.nWorker(0))
+}
+
+func TestSortDistribOptions(t *testing.T) {
+	worker := NewLocalDistribWorker(&goSorter{})
+
+	t.Run("Schedule", func(t *testing.T) {
+		opts := DefaultSortOptions()
+		opts.WidthSchedule = []int{4, 12, 16}
+		SortDistribOptsTest(t, "testSortSchedule", data.MemArrayFactory, worker, opts)
+	})
+
+	t.Run("BytesPerWorker", func(t *testing.T) {
+		opts := DefaultSortOptions()
+		opts.BytesPerWorker = 1000
+		SortDistribOptsTest(t, "testSortBytesPerWorker", data.MemArrayFactory, worker, opts)
+	})
+
+	// More workers than elements, uneven splits leave some workers without
+	// any input
+	t.Run("TinyInput", func(t *testing.T) {
+		for _, nElem := range []int{3, 5} {
+			origRaw, err := GenerateInputs((uint64)(nElem))
+			require.Nil(t, err, "Failed to generate inputs")
+
+			byWorkers := DefaultSortOptions()
+			byWorkers.NWorker = 4
+			byBytes := DefaultSortOptions()
+			byBytes.BytesPerWorker = 1
+			withHist := DefaultSortOptions()
+			withHist.NWorker = 4
+			withHist.Histogram = LocalStatsWorker
+
+			for _, opts := range []*SortOptions{byWorkers, byBytes, withHist} {
+				outRaw, err := SortDistribFromRaw(context.Background(), origRaw, "testSortTiny", data.MemArrayFactory, worker, opts)
+				require.Nil(t, err, "Failed to sort %v elements", nElem)
+				require.Nil(t, CheckSort(origRaw, outRaw), "Sorted wrong")
+			}
+		}
+	})
+
+	// INORDER only preserves radix order with a single worker
+	t.Run("InOrder", func(t *testing.T) {
+		opts := DefaultSortOptions()
+		opts.ReadOrder = INORDER
+		opts.NWorker = 1
+		SortDistribOptsTest(t, "testSortInOrder", data.MemArrayFactory, worker, opts)
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		opts := DefaultSortOptions()
+		opts.Width = 7
+		_, err := SortDistribFromRaw(context.Background(), make([]byte, 64), "testSortInvalid", data.MemArrayFactory, worker, opts)
+		require.NotNil(t, err, "Sorted with invalid options")
+	})
+}
+
+func TestSortDistribCleanup(t *testing.T) {
+	worker := NewLocalDistribWorker(&goSorter{})
+
+	// Returns the number of arrays left behind by a sort
+	runSort := func(t *testing.T, opts *SortOptions) int {
+		tmpDir, err := ioutil.TempDir("", "radixSortCleanupTest")
+		require.Nilf(t, err, "Couldn't create temporary test directory")
+		defer os.RemoveAll(tmpDir)
+
+		SortDistribOptsTest(t, "testCleanup", data.NewFileArrayFactory(tmpDir), worker, opts)
+
+		entries, err := ioutil.ReadDir(tmpDir)
+		require.Nil(t, err, "Couldn't list array directory")
+		return len(entries)
+	}
+
+	opts := DefaultSortOptions()
+	opts.Cleanup = CleanupAll
+	require.Equal(t, 0, runSort(t, opts), "CleanupAll left arrays behind")
+
+	opts.Cleanup = CleanupIntermediate
+	require.Equal(t, 0, runSort(t, opts), "CleanupIntermediate left arrays behind")
+
+	// Input plus NWorker outputs per pass
+	opts.Cleanup = CleanupNone
+	require.Equal(t, 1+opts.NWorker*len(opts.passWidths()), runSort(t, opts), "CleanupNone destroyed arrays")
+}
+
+// Sorts with different options must be able to run at the same time
+func TestSortDistribConcurrent(t *testing.T) {
+	worker := NewLocalDistribWorker(&goSorter{})
+
+	widths := []int{4, 8, 16}
+	errs := make(chan error, len(widths))
+	for _, width := range widths {
+		go func(width int) {
+			origRaw, err := CpuGenerateInputs(1111)
+			if err != nil {
+				errs <- err
+				return
+			}
+
+			opts := DefaultSortOptions()
+			opts.Width = width
+			opts.NWorker = width / 4
+			outRaw, err := SortDistribFromRaw(context.Background(), origRaw, fmt.Sprintf("testConcurrent%v", width), data.MemArrayFactory, worker, opts)
+			if err != nil {
+				errs <- err
+				return
+			}
+			errs <- CheckSort(origRaw, outRaw)
+		}(width)
+	}
+
+	for range widths {
+		require.Nil(t, <-errs, "Concurrent sort failed")
+	}
+}
@@ -0,0 +1,156 @@
+package sort
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/pkg/errors"
+)
+
+// Result of a single attempt at a worker's task
+type attemptResult struct {
+	outName string
+	out     data.DistribArray
+	err     error
+}
+
+// Name of worker id's task in the given step of sort baseName
+func taskName(baseName string, step int, id int) string {
+	return fmt.Sprintf("%v_step%v_worker%v", baseName, step, id)
+}
+
+// Name passed to the DistribWorker for the given attempt at a task. The first
+// attempt just uses the task name, later attempts (retries and speculative
+// duplicates) get a unique suffix so that their outputs never collide.
+func attemptName(taskName string, attempt int) string {
+	if attempt == 0 {
+		return taskName
+	}
+	return fmt.Sprintf("%v_attempt%v", taskName, attempt)
+}
+
+// Run one worker's task, retrying failures and launching a speculative
+// duplicate of slow attempts as configured in opts. Every attempt's output is
+// tracked in tracker. Returns the output (and its name) of the first
+// successful attempt as soon as it finishes. Other attempts are cancelled and
+// their outputs destroyed in the background once they finish (workers that
+// ignore ctx may take a while), callers must wait for them with tracker.wait()
+// and destroy inputs with tracker.destroyInput(). If every attempt fails,
+// their outputs are left in tracker for the caller to clean up.
+func runWorkerTask(ctx context.Context, worker DistribWorker, inputs []*data.PartRef, offset int, width int,
+	format ElemFormat, taskName string, tracker *arrayTracker, opts *SortOptions) (data.DistribArray, string, error) {
+
+	taskCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	factory := tracker.trackingFactory()
+
+	// Enough room for every attempt we could launch so that attempts never
+	// block on reporting their results
+	results := make(chan *attemptResult, opts.MaxAttempts+1)
+
+	var specTimer <-chan time.Time
+	speculated := false
+	nAttempt := 0
+	nRunning := 0
+	launch := func() {
+		name := attemptName(taskName, nAttempt)
+		outName := name + "_output"
+
+		// Workers may create their outputs out of our sight
+		tracker.add(outName, nil)
+		go func() {
+			out, err := worker(taskCtx, inputs, offset, width, format, name, factory)
+			results <- &attemptResult{outName: outName, out: out, err: err}
+		}()
+
+		nAttempt++
+		nRunning++
+		if opts.SpeculateAfter > 0 && !speculated {
+			specTimer = time.After(opts.SpeculateAfter)
+		}
+	}
+
+	// Throw away an attempt's output (if it made one)
+	discard := func(res *attemptResult) {
+		if res.out != nil {
+			if err := res.out.Destroy(); err == nil {
+				tracker.forget(res.outName)
+			}
+		} else {
+			tracker.destroy(res.outName)
+		}
+	}
+
+	var winner *attemptResult
+	var failed []*attemptResult
+	var lastErr error
+	var retryTimer <-chan time.Time
+	var ctxDone <-chan struct{}
+	nFailure := 0
+
+	launch()
+	for {
+		select {
+		case res := <-results:
+			nRunning--
+			if res.err == nil {
+				winner = res
+			} else {
+				failed = append(failed, res)
+				lastErr = res.err
+				nFailure++
+			}
+
+		case <-specTimer:
+			specTimer = nil
+			if winner == nil && nRunning > 0 && !speculated {
+				speculated = true
+				launch()
+			}
+
+		case <-retryTimer:
+			retryTimer = nil
+			ctxDone = nil
+			launch()
+
+		case <-ctxDone:
+			return nil, "", errors.Wrapf(ctx.Err(), "Gave up retrying after %v failures (last error: %v)", nFailure, lastErr)
+		}
+
+		if winner != nil {
+			// Don't wait for the losers, taskCtx is cancelled on return.
+			// They may still be reading inputs, tracker puts off destroying
+			// those until they're done.
+			if nRunning > 0 {
+				tracker.addStraggler()
+				go func(nRunning int) {
+					defer tracker.doneStraggler()
+					for ; nRunning > 0; nRunning-- {
+						discard(<-results)
+					}
+				}(nRunning)
+			}
+
+			for _, res := range failed {
+				discard(res)
+			}
+			return winner.out, winner.outName, nil
+		}
+
+		if nRunning == 0 && retryTimer == nil {
+			if nFailure >= opts.MaxAttempts || ctx.Err() != nil {
+				if nFailure > 1 {
+					return nil, "", errors.Wrapf(lastErr, "Failed after %v attempts", nFailure)
+				}
+				return nil, "", lastErr
+			}
+
+			backoff := opts.RetryBackoff << (uint)(nFailure-1)
+			retryTimer = time.After(backoff)
+			ctxDone = ctx.Done()
+		}
+	}
+}
@@ -0,0 +1,192 @@
+package sort
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/require"
+)
+
+// A fake worker for testing retries. The first nFail attempts at every task
+// fail (after creating their output) and the first attempt at every task
+// sleeps for delay (or until cancelled) before doing anything.
+type flakyWorker struct {
+	nFail int
+	delay time.Duration
+
+	lock      sync.Mutex
+	nAttempts map[string]int
+	nCalls    int32
+}
+
+func newFlakyWorker(nFail int, delay time.Duration) *flakyWorker {
+	return &flakyWorker{nFail: nFail, delay: delay, nAttempts: make(map[string]int)}
+}
+
+func (self *flakyWorker) worker(ctx context.Context, inBkts []*data.PartRef, offset int, width int, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+	atomic.AddInt32(&self.nCalls, 1)
+
+	task := baseName
+	if idx := strings.Index(baseName, "_attempt"); idx >= 0 {
+		task = baseName[:idx]
+	}
+
+	self.lock.Lock()
+	attempt := self.nAttempts[task]
+	self.nAttempts[task]++
+	self.lock.Unlock()
+
+	if attempt == 0 && self.delay > 0 {
+		select {
+		case <-time.After(self.delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	out, err := LocalDistribWorker(ctx, inBkts, offset, width, format, baseName, factory)
+	if err != nil {
+		return nil, err
+	}
+
+	if attempt < self.nFail {
+		return nil, errors.New("Injected failure")
+	}
+	return out, nil
+}
+
+// Sort with worker and make sure that it worked and nothing leaked
+func testRetrySort(t *testing.T, worker DistribWorker, opts *SortOptions) error {
+	origRaw, err := CpuGenerateInputs(1111)
+	require.Nil(t, err, "Failed to generate test inputs")
+
+	recorder, created := recordingFactory(data.MemArrayFactory)
+	outRaw, sortErr := SortDistribFromRaw(context.Background(), origRaw, "testRetry", recorder, worker, opts)
+	if sortErr == nil {
+		require.Nil(t, CheckSort(origRaw, outRaw), "Did not sort correctly")
+	}
+
+	for _, name := range created() {
+		arr, err := data.MemArrayFactory.Open(name)
+		if err == nil {
+			arr.Destroy()
+		}
+		require.NotNilf(t, err, "Array %v leaked", name)
+	}
+
+	return sortErr
+}
+
+func TestSortDistribRetry(t *testing.T) {
+	t.Run("Recover", func(t *testing.T) {
+		flaky := newFlakyWorker(2, 0)
+
+		opts := DefaultSortOptions()
+		opts.MaxAttempts = 3
+		opts.RetryBackoff = time.Millisecond
+		require.Nil(t, testRetrySort(t, flaky.worker, opts), "Sort failed despite retries")
+		require.Equal(t, int32(3*opts.NWorker*len(opts.passWidths())), flaky.nCalls, "Wrong number of attempts")
+	})
+
+	t.Run("GiveUp", func(t *testing.T) {
+		flaky := newFlakyWorker(3, 0)
+
+		opts := DefaultSortOptions()
+		opts.MaxAttempts = 3
+		opts.RetryBackoff = time.Millisecond
+		err := testRetrySort(t, flaky.worker, opts)
+		require.NotNil(t, err, "Sort succeeded without enough attempts")
+		require.Contains(t, err.Error(), "Injected failure", "Wrong error reported")
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		flaky := newFlakyWorker(1, 0)
+
+		opts := DefaultSortOptions()
+		opts.MaxAttempts = 2
+		opts.RetryBackoff = time.Hour
+
+		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+		defer cancel()
+		_, err := SortDistribFromRaw(ctx, make([]byte, 4096), "testRetryCancel", data.MemArrayFactory, flaky.worker, opts)
+		require.Equal(t, context.DeadlineExceeded, errors.Cause(err), "Retry backoff ignored cancellation")
+	})
+}
+
+func TestSortDistribSpeculate(t *testing.T) {
+	// Without speculation every task would take an hour
+	flaky := newFlakyWorker(0, time.Hour)
+
+	opts := DefaultSortOptions()
+	opts.SpeculateAfter = 5 * time.Millisecond
+	require.Nil(t, testRetrySort(t, flaky.worker, opts), "Speculative sort failed")
+	require.Equal(t, int32(2*opts.NWorker*len(opts.passWidths())), flaky.nCalls, "Wrong number of attempts")
+}
+
+// The local and SRK workers ignore ctx, the winner mustn't wait for them
+func TestSpeculateIgnoredCancel(t *testing.T) {
+	const slow = 2 * time.Second
+
+	var nCalls int32
+	worker := func(ctx context.Context, inBkts []*data.PartRef, offset int, width int, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+		if atomic.AddInt32(&nCalls, 1) == 1 {
+			time.Sleep(slow)
+		}
+		return LocalDistribWorker(context.Background(), inBkts, offset, width, format, baseName, factory)
+	}
+
+	raw, err := CpuGenerateInputs(100)
+	require.Nil(t, err, "Failed to generate test inputs")
+	in := createVerifyArr(t, "testSpeculateIgnoredInput", raw)
+	defer in.Destroy()
+	refs := []*data.PartRef{{Arr: in, PartIdx: 0, Start: 0, NByte: len(raw)}}
+
+	opts := DefaultSortOptions()
+	opts.SpeculateAfter = 10 * time.Millisecond
+	tracker := newArrayTracker(data.MemArrayFactory)
+
+	start := time.Now()
+	out, outName, err := runWorkerTask(context.Background(), worker, refs, 0, opts.Width, opts.Format(),
+		"testSpeculateIgnored", tracker, opts)
+	require.Nil(t, err, "Speculative task failed")
+	require.True(t, time.Since(start) < slow/2, "Waited for the slow attempt (%v)", time.Since(start))
+	require.Equal(t, attemptName("testSpeculateIgnored", 1)+"_output", outName)
+	require.Nil(t, out.Destroy())
+	tracker.forget(outName)
+
+	// The slow attempt's output goes away once it finishes
+	require.Nil(t, tracker.wait())
+	require.Empty(t, tracker.names(), "Slow attempt leaked")
+}
+
+// Losing attempts may still be reading a pass's inputs after the pass is over,
+// the sort must not destroy them early or return before the attempts finish
+func TestSortSpeculateStraggler(t *testing.T) {
+	const slow = 300 * time.Millisecond
+
+	var nCalls int32
+	var nDone int32
+	worker := func(ctx context.Context, inBkts []*data.PartRef, offset int, width int, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+		if atomic.AddInt32(&nCalls, 1) == 1 {
+			defer atomic.AddInt32(&nDone, 1)
+			time.Sleep(slow)
+		}
+		return LocalDistribWorker(context.Background(), inBkts, offset, width, format, baseName, factory)
+	}
+
+	opts := DefaultSortOptions()
+	opts.SpeculateAfter = 10 * time.Millisecond
+	require.Nil(t, testRetrySort(t, worker, opts), "Speculative sort failed")
+	require.Equal(t, int32(1), atomic.LoadInt32(&nDone), "Sort returned before the slow attempt finished")
+}
+
+func TestAttemptName(t *testing.T) {
+	require.Equal(t, "task", attemptName("task", 0))
+	require.NotEqual(t, attemptName("task", 1), attemptName("task", 2))
+}
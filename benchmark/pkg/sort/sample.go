@@ -0,0 +1,334 @@
+package sort
+
+// Sample sort. Radix buckets are badly unbalanced for skewed keys (e.g. small
+// integers with all their high bits zero). A sample sort reads a fraction of
+// the input, picks quantile splitters and partitions by splitter so that
+// every bucket gets a similar share of the data. Each bucket is then sorted by
+// a single FullWorker.
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// Inputs smaller than this many samples per bucket are oversampled
+const minSamplesPerBucket = 32
+
+// Samples are read in at most this many contiguous runs to avoid lots of tiny
+// reads
+const maxSampleRuns = 64
+
+// Read inBkts in order and partition them by splitters (which are sorted).
+// Returns a distributed array (generated by 'factory') with
+// len(splitters)+1 partitions, partition i holds the elements with
+// splitters[i-1] <= key < splitters[i] in their original order. The output
+// array must be named baseName+"_output" (see DistribWorker). Workers should
+// give up if ctx is cancelled.
+type SplitWorker func(ctx context.Context, inBkts []*data.PartRef, splitters []uint64, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error)
+
+// A SplitWorker that partitions in the local process
+func LocalSplitWorker(ctx context.Context, inBkts []*data.PartRef, splitters []uint64, format ElemFormat, baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return LocalSortSplit(inBkts, splitters, format, baseName+"_output", factory)
+}
+
+// Read inBkts and partition them by splitters in the local process. The
+// output is written to a new array called outName (one partition per bucket).
+func LocalSortSplit(inBkts []*data.PartRef, splitters []uint64, format ElemFormat,
+	outName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+
+	inBytes, err := data.FetchPartRefs(inBkts)
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't read input references")
+	}
+
+	boundaries := make([]int64, len(splitters)+1)
+	if err = CpuSplitFormat(inBytes, boundaries, splitters, format); err != nil {
+		return nil, errors.Wrap(err, "Local split failed")
+	}
+
+	return writeBuckets(inBytes, boundaries, outName, factory)
+}
+
+// Read nSample keys from refs (which hold whole elements). Samples are taken
+// in evenly spaced runs across the whole input.
+func SampleKeys(refs []*data.PartRef, nSample int, format ElemFormat) ([]uint64, error) {
+	elemSz := format.ElemSize()
+
+	total := 0
+	for _, ref := range refs {
+		total += ref.NByte
+	}
+	nElem := total / elemSz
+	if nSample > nElem {
+		nSample = nElem
+	}
+	if nSample == 0 {
+		return nil, nil
+	}
+
+	nRun := nSample
+	if nRun > maxSampleRuns {
+		nRun = maxSampleRuns
+	}
+	stride := nElem / nRun
+	runLen := (nSample + nRun - 1) / nRun
+	if runLen > stride {
+		runLen = stride
+	}
+
+	sampleRefs := refs
+	if nSample < nElem {
+		sampleRefs = nil
+		for run := 0; run < nRun; run++ {
+			sampleRefs = append(sampleRefs, sliceRefs(refs, run*stride*elemSz, runLen*elemSz)...)
+		}
+	}
+
+	raw, err := data.FetchPartRefs(sampleRefs)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read samples")
+	}
+
+	keys := make([]uint64, len(raw)/elemSz)
+	for i := range keys {
+		keys[i] = format.Key(raw[i*elemSz:])
+	}
+	return keys, nil
+}
+
+// Returns refs to sz bytes of refs starting at byte start
+func sliceRefs(refs []*data.PartRef, start int, sz int) []*data.PartRef {
+	var out []*data.PartRef
+	for _, ref := range refs {
+		if sz == 0 {
+			break
+		}
+		if start >= ref.NByte {
+			start -= ref.NByte
+			continue
+		}
+
+		n := ref.NByte - start
+		if n > sz {
+			n = sz
+		}
+		out = append(out, &data.PartRef{Arr: ref.Arr, PartIdx: ref.PartIdx, Start: ref.Start + start, NByte: n})
+		start = 0
+		sz -= n
+	}
+	return out
+}
+
+// Pick nBucket-1 splitters that divide samples into nBucket equal parts.
+// samples is sorted in place.
+func ChooseSplitters(samples []uint64, nBucket int) []uint64 {
+	if len(samples) == 0 || nBucket < 2 {
+		return nil
+	}
+
+	sort.Slice(samples, func(i, j int) bool { return samples[i] < samples[j] })
+
+	splitters := make([]uint64, nBucket-1)
+	for i := range splitters {
+		splitters[i] = samples[(i+1)*len(samples)/nBucket]
+	}
+	return splitters
+}
+
+// Sample sort of arr (sz bytes). A fraction of the input
+// (opts.SampleFraction) is read to choose one splitter per worker, splitter
+// partitions the input, then each bucket is fully sorted by finisher. Every
+// bucket holds roughly the same amount of data regardless of the key
+// distribution (except for heavily repeated keys, equal keys always go to the
+// same bucket). Returns an ordered list of single-partition arrays containing
+// the sorted output. Failures are handled as in SortDistribFromArr.
+// Checkpointing is not supported.
+func SortSampleFromArr(ctx context.Context, arr data.DistribArray, sz int, baseName string,
+	factory *data.ArrayFactory, splitter SplitWorker, finisher FullWorker, opts *SortOptions) ([]data.DistribArray, error) {
+	if opts == nil {
+		opts = DefaultSortOptions()
+	}
+
+	manifest := &SortManifest{BaseName: baseName, Size: sz, Options: opts}
+	tracker := newArrayTracker(factory)
+	outputs, err := sortSample(ctx, arr, manifest, tracker, splitter, finisher)
+	if err != nil {
+		return nil, cleanupFailedSort(err, tracker, manifest)
+	}
+	return outputs, nil
+}
+
+// Like SortDistribFromRaw but using a sample sort (see SortSampleFromArr)
+func SortSampleFromRaw(ctx context.Context, inRaw []byte, baseName string,
+	factory *data.ArrayFactory, splitter SplitWorker, finisher FullWorker, opts *SortOptions) ([]byte, error) {
+	if opts == nil {
+		opts = DefaultSortOptions()
+	}
+
+	inName := baseName + "_input"
+	manifest := &SortManifest{BaseName: baseName, Size: len(inRaw), Input: inName, Options: opts}
+	tracker := newArrayTracker(factory)
+
+	origArr, err := createRawInput(inRaw, inName, opts, tracker.trackingFactory())
+	if err == nil {
+		var outArrs []data.DistribArray
+		outArrs, err = sortSample(ctx, origArr, manifest, tracker, splitter, finisher)
+		if err == nil {
+			return finishRawSort(outArrs, origArr, len(inRaw), opts)
+		}
+	}
+	return nil, cleanupFailedSort(err, tracker, manifest)
+}
+
+// Implementation of SortSampleFromArr
+func sortSample(ctx context.Context, arr data.DistribArray, manifest *SortManifest,
+	tracker *arrayTracker, splitter SplitWorker, finisher FullWorker) ([]data.DistribArray, error) {
+
+	opts := manifest.Options
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Invalid sort options")
+	}
+	if opts.CheckpointDir != "" {
+		return nil, fmt.Errorf("Sample sorts can't be checkpointed")
+	}
+
+	format := opts.Format()
+	elemSz := format.ElemSize()
+	refs, err := arrayRefs(arr, manifest.Size, format)
+	if err != nil {
+		return nil, err
+	}
+	// Nothing to sort but the input still has to be cleaned up
+	if manifest.Size == 0 {
+		return nil, consumeInput(arr, manifest, tracker)
+	}
+
+	nworker := opts.nWorker(manifest.Size)
+	nElem := manifest.Size / elemSz
+
+	nSample := (int)(opts.SampleFraction * (float64)(nElem))
+	if nSample < minSamplesPerBucket*nworker {
+		nSample = minSamplesPerBucket * nworker
+	}
+	samples, err := SampleKeys(refs, nSample, format)
+	if err != nil {
+		return nil, err
+	}
+	splitters := ChooseSplitters(samples, nworker)
+	nBucket := len(splitters) + 1
+
+	state := &msdSort{
+		opts:    opts,
+		format:  format,
+		tracker: tracker,
+		worker: func(ctx context.Context, inBkts []*data.PartRef, offset int, width int, format ElemFormat,
+			baseName string, factory *data.ArrayFactory) (data.DistribArray, error) {
+			return splitter(ctx, inBkts, splitters, format, baseName, factory)
+		},
+		finisher: fullAsDistrib(finisher),
+		slots:    make(chan struct{}, nworker),
+	}
+	name := manifest.BaseName + "_sample"
+
+	// Partition by splitter
+	perWorker := ((nElem + nworker - 1) / nworker) * elemSz
+	inputs := splitRefs(refs, perWorker)
+	parts := make([]data.DistribArray, len(inputs))
+	partNames := make([]string, len(inputs))
+	group, groupCtx := errgroup.WithContext(ctx)
+	for i := range inputs {
+		id := i
+		group.Go(func() error {
+			var err error
+			parts[id], partNames[id], err = state.runTask(groupCtx, state.worker, inputs[id], 0, 0,
+				fmt.Sprintf("%v_part%v", name, id))
+			if err != nil {
+				return errors.Wrapf(err, "Failed to partition input (worker %v)", id)
+			}
+			return nil
+		})
+	}
+	if err = group.Wait(); err != nil {
+		return nil, err
+	}
+
+	if err = consumeInput(arr, manifest, tracker); err != nil {
+		return nil, err
+	}
+
+	// The split outputs look just like radix outputs (one partition per
+	// bucket) so a strided BucketReader returns them in bucket order
+	bucketSzs := make([]int, nBucket)
+	for i, part := range parts {
+		shape, err := part.GetShape()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Couldn't get shape of %v", partNames[i])
+		}
+		for b := 0; b < nBucket; b++ {
+			bucketSzs[b] += (int)(shape.Len(b))
+		}
+	}
+
+	reader, err := NewAlignedBucketReader(parts, STRIDED, elemSz)
+	if err != nil {
+		return nil, err
+	}
+
+	bucketRefs := make([][]*data.PartRef, nBucket)
+	for b := 0; b < nBucket; b++ {
+		if bucketSzs[b] != 0 {
+			if bucketRefs[b], err = reader.ReadRef(bucketSzs[b]); err != nil {
+				return nil, errors.Wrapf(err, "Failed to read bucket %v", b)
+			}
+		}
+	}
+
+	outputs := make([]data.DistribArray, nBucket)
+	group, groupCtx = errgroup.WithContext(ctx)
+	for b := 0; b < nBucket; b++ {
+		if bucketSzs[b] == 0 {
+			continue
+		}
+
+		id := b
+		group.Go(func() error {
+			var err error
+			outputs[id], _, err = state.runTask(groupCtx, state.finisher, bucketRefs[id], 0, 0,
+				fmt.Sprintf("%v_%v_full", name, id))
+			if err != nil {
+				return errors.Wrapf(err, "Failed to sort bucket %v", id)
+			}
+			return nil
+		})
+	}
+	if err = group.Wait(); err != nil {
+		return nil, err
+	}
+
+	if opts.Cleanup != CleanupNone {
+		for i, part := range parts {
+			if err := tracker.destroyInput(partNames[i], part); err != nil {
+				return nil, errors.Wrapf(err, "Failed to destroy %v", partNames[i])
+			}
+		}
+	}
+	if err = tracker.wait(); err != nil {
+		return nil, errors.Wrap(err, "Failed to destroy one or more intermediate arrays")
+	}
+
+	var sorted []data.DistribArray
+	for _, out := range outputs {
+		if out != nil {
+			sorted = append(sorted, out)
+		}
+	}
+	return sorted, nil
+}
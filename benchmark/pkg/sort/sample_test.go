@@ -0,0 +1,167 @@
+package sort
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSortSample(t *testing.T) {
+	origRaw, err := GenerateInputs(1111)
+	require.Nil(t, err, "Failed to generate inputs")
+
+	t.Run("Mem", func(t *testing.T) {
+		outRaw, err := SortSampleFromRaw(context.Background(), origRaw, "testSortSample", data.MemArrayFactory,
+			LocalSplitWorker, LocalFullWorker, nil)
+		require.Nil(t, err, "Sort Error")
+		require.Nil(t, CheckSort(origRaw, outRaw), "Sorted Wrong")
+	})
+
+	t.Run("File", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "radixSortSampleTest")
+		require.Nilf(t, err, "Couldn't create temporary test directory")
+		defer os.RemoveAll(tmpDir)
+
+		opts := DefaultSortOptions()
+		opts.NWorker = 5
+		outRaw, err := SortSampleFromRaw(context.Background(), origRaw, "testSortSample", data.NewFileArrayFactory(tmpDir),
+			LocalSplitWorker, LocalFullWorker, opts)
+		require.Nil(t, err, "Sort Error")
+		require.Nil(t, CheckSort(origRaw, outRaw), "Sorted Wrong")
+
+		entries, err := ioutil.ReadDir(tmpDir)
+		require.Nil(t, err, "Couldn't list array directory")
+		require.Zero(t, len(entries), "Sort left arrays behind")
+	})
+
+	// Empty inputs are still cleaned up
+	t.Run("Empty", func(t *testing.T) {
+		for _, cleanup := range []CleanupPolicy{CleanupAll, CleanupIntermediate} {
+			tmpDir, err := ioutil.TempDir("", "radixSortSampleTest")
+			require.Nilf(t, err, "Couldn't create temporary test directory")
+			defer os.RemoveAll(tmpDir)
+
+			opts := DefaultSortOptions()
+			opts.Cleanup = cleanup
+			outRaw, err := SortSampleFromRaw(context.Background(), []byte{}, "testSortSampleEmpty", data.NewFileArrayFactory(tmpDir),
+				LocalSplitWorker, LocalFullWorker, opts)
+			require.Nil(t, err, "Sort Error")
+			require.Zero(t, len(outRaw))
+
+			entries, err := ioutil.ReadDir(tmpDir)
+			require.Nil(t, err, "Couldn't list array directory")
+			require.Zerof(t, len(entries), "Sort left arrays behind (cleanup %v)", cleanup)
+		}
+	})
+
+	t.Run("Records", func(t *testing.T) {
+		opts := DefaultSortOptions()
+		opts.KeySize = 8
+		opts.RecordSize = 16
+		opts.KeyEncoding = SignedKeys
+		recRaw, err := GenerateRecords(1111, opts.Format())
+		require.Nil(t, err, "Failed to generate inputs")
+
+		outRaw, err := SortSampleFromRaw(context.Background(), recRaw, "testSortSampleRecords", data.MemArrayFactory,
+			LocalSplitWorker, NewLocalFullWorker(&goSorter{}), opts)
+		require.Nil(t, err, "Sort Error")
+
+		for i := 16; i < len(outRaw); i += 16 {
+			prev := (int64)(binary.LittleEndian.Uint64(outRaw[i-16:]))
+			cur := (int64)(binary.LittleEndian.Uint64(outRaw[i:]))
+			require.LessOrEqualf(t, prev, cur, "Records out of order at %v", i/16)
+		}
+	})
+}
+
+// Small integers have all their high bits zero, radix buckets on the top bits
+// would put everything in one bucket
+func TestSortSampleSkewed(t *testing.T) {
+	nElem := 4096
+	origRaw, err := GenerateInputs((uint64)(nElem))
+	require.Nil(t, err, "Failed to generate inputs")
+	for i := 0; i < len(origRaw); i += 4 {
+		binary.LittleEndian.PutUint32(origRaw[i:], binary.LittleEndian.Uint32(origRaw[i:])%1000)
+	}
+
+	arr, err := data.MemArrayFactory.Create("testSortSampleSkewedInput", data.CreateShapeUniform((int64)(len(origRaw)), 1))
+	require.Nil(t, err, "Failed to create input")
+	writer, err := arr.GetPartWriter(0)
+	require.Nil(t, err, "Failed to get writer")
+	_, err = writer.Write(origRaw)
+	require.Nil(t, err, "Failed to write input")
+	writer.Close()
+
+	opts := DefaultSortOptions()
+	opts.NWorker = 4
+	opts.Cleanup = CleanupIntermediate
+	outArrs, err := SortSampleFromArr(context.Background(), arr, len(origRaw), "testSortSampleSkewed", data.MemArrayFactory,
+		LocalSplitWorker, LocalFullWorker, opts)
+	require.Nil(t, err, "Sort Error")
+	require.Equal(t, opts.NWorker, len(outArrs), "Wrong number of buckets")
+
+	var outRaw []byte
+	for i, out := range outArrs {
+		raw, err := data.FetchPartRefs([]*data.PartRef{{Arr: out, PartIdx: 0, Start: 0, NByte: lenOf(t, out)}})
+		require.Nil(t, err, "Failed to read output")
+
+		// Every bucket should be within 50% of an even share
+		share := len(origRaw) / opts.NWorker
+		require.InDeltaf(t, share, len(raw), (float64)(share)/2, "Bucket %v is unbalanced", i)
+		outRaw = append(outRaw, raw...)
+		out.Destroy()
+	}
+	require.Nil(t, CheckSort(origRaw, outRaw), "Sorted Wrong")
+	arr.Destroy()
+}
+
+func lenOf(t *testing.T, arr data.DistribArray) int {
+	shape, err := arr.GetShape()
+	require.Nil(t, err, "Couldn't get shape")
+	return (int)(shape.Len(0))
+}
+
+func TestChooseSplitters(t *testing.T) {
+	samples := []uint64{9, 3, 7, 1, 5, 0, 8, 2, 6, 4}
+	require.Equal(t, []uint64{2, 5, 7}, ChooseSplitters(samples, 4))
+	require.Nil(t, ChooseSplitters(samples, 1))
+	require.Nil(t, ChooseSplitters(nil, 4))
+}
+
+func TestSampleKeys(t *testing.T) {
+	raw := make([]byte, 4*1000)
+	for i := 0; i < 1000; i++ {
+		binary.LittleEndian.PutUint32(raw[i*4:], (uint32)(i))
+	}
+
+	arr, err := data.MemArrayFactory.Create("testSampleKeys", data.CreateShapeUniform(400, 10))
+	require.Nil(t, err, "Failed to create array")
+	defer arr.Destroy()
+	for i := 0; i < 10; i++ {
+		writer, err := arr.GetPartWriter(i)
+		require.Nil(t, err, "Failed to get writer")
+		_, err = writer.Write(raw[i*400 : (i+1)*400])
+		require.Nil(t, err, "Failed to write")
+		writer.Close()
+	}
+
+	refs, err := arrayRefs(arr, len(raw), Uint32Format)
+	require.Nil(t, err, "Failed to get refs")
+
+	samples, err := SampleKeys(refs, 200, Uint32Format)
+	require.Nil(t, err, "Failed to sample")
+	require.Equal(t, 64*4, len(samples), "Wrong number of samples")
+
+	// Samples must come from across the whole input
+	require.Less(t, samples[0], (uint64)(100))
+	require.Greater(t, samples[len(samples)-1], (uint64)(900))
+
+	samples, err = SampleKeys(refs, 5000, Uint32Format)
+	require.Nil(t, err, "Failed to sample")
+	require.Equal(t, 1000, len(samples), "Oversampled the input")
+}
@@ -0,0 +1,149 @@
+package sort
+
+// Distributed statistics. Workers summarize their share of an array (counts,
+// min/max key and digit histograms) and the driver merges the summaries, the
+// data itself is never moved.
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// Summary of the keys in an array. Keys are treated as unsigned integers
+// (KeyEncoding is not applied).
+type ArrayStats struct {
+	// Number of elements
+	Count int64 `json:"count"`
+
+	// Smallest and largest key, both are zero if Count is zero
+	Min uint64 `json:"min"`
+	Max uint64 `json:"max"`
+
+	// Digits that were counted, Counts[i] is the histogram of Digits[i] (it
+	// has 2^Digits[i].Width entries)
+	Digits []Digit   `json:"digits"`
+	Counts [][]int64 `json:"counts"`
+}
+
+// Add the statistics in other to self. Both must have counted the same
+// digits.
+func (self *ArrayStats) Merge(other *ArrayStats) error {
+	if len(self.Digits) != len(other.Digits) || len(self.Counts) != len(other.Counts) {
+		return fmt.Errorf("Can't merge stats with different digits (%v vs %v)", self.Digits, other.Digits)
+	}
+	for i, digit := range self.Digits {
+		if other.Digits[i] != digit || len(other.Counts[i]) != len(self.Counts[i]) {
+			return fmt.Errorf("Can't merge stats with different digits (%v vs %v)", self.Digits, other.Digits)
+		}
+	}
+
+	if other.Count != 0 {
+		if self.Count == 0 || other.Min < self.Min {
+			self.Min = other.Min
+		}
+		if self.Count == 0 || other.Max > self.Max {
+			self.Max = other.Max
+		}
+	}
+	self.Count += other.Count
+
+	for i := range self.Counts {
+		for value, count := range other.Counts[i] {
+			self.Counts[i][value] += count
+		}
+	}
+	return nil
+}
+
+// Compute the statistics (see ArrayStats) of inBkts (laid out as described by
+// format), counting each of digits. Workers should give up if ctx is
+// cancelled.
+type StatsWorker func(ctx context.Context, inBkts []*data.PartRef, digits []Digit, format ElemFormat) (*ArrayStats, error)
+
+// A StatsWorker that runs in the local process
+func LocalStatsWorker(ctx context.Context, inBkts []*data.PartRef, digits []Digit, format ElemFormat) (*ArrayStats, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	inBytes, err := data.FetchPartRefs(inBkts)
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't read input references")
+	}
+
+	return CpuStatsFormat(inBytes, digits, format)
+}
+
+// Compute the statistics of arr (sz bytes) using worker. The array is split
+// between workers as in a sort pass (opts.NWorker or opts.BytesPerWorker) and
+// the results are merged on the driver. Only the element format, read order
+// and worker count are taken from opts (nil means DefaultSortOptions()). arr
+// is not modified.
+func ArrayStatsFromArr(ctx context.Context, arr data.DistribArray, sz int, digits []Digit,
+	worker StatsWorker, opts *SortOptions) (*ArrayStats, error) {
+	if opts == nil {
+		opts = DefaultSortOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Invalid sort options")
+	}
+
+	format := opts.Format()
+	nworker := opts.nWorker(sz)
+	nElem := sz / format.ElemSize()
+	maxPerWorker := ((nElem + nworker - 1) / nworker) * format.ElemSize()
+	inputs, err := splitInputs([]data.DistribArray{arr}, opts, nworker, maxPerWorker)
+	if err != nil {
+		return nil, err
+	}
+
+	total, err := collectStats(ctx, inputs, digits, format, worker)
+	if err != nil {
+		return nil, err
+	}
+	if total.Count != (int64)(nElem) {
+		return nil, fmt.Errorf("Workers counted %v elements, expected %v", total.Count, nElem)
+	}
+	return total, nil
+}
+
+// Run worker on each of inputs in parallel and merge the results
+func collectStats(ctx context.Context, inputs [][]*data.PartRef, digits []Digit, format ElemFormat,
+	worker StatsWorker) (*ArrayStats, error) {
+
+	// An empty result also validates the digits
+	total, err := CpuStatsFormat(nil, digits, format)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*ArrayStats, len(inputs))
+	group, groupCtx := errgroup.WithContext(ctx)
+	for i := range inputs {
+		id := i
+		group.Go(func() error {
+			stats, err := worker(groupCtx, inputs[id], digits, format)
+			if err != nil {
+				return errors.Wrapf(err, "Stats worker %v failed", id)
+			} else if stats == nil {
+				return fmt.Errorf("Stats worker %v returned no results", id)
+			}
+			results[id] = stats
+			return nil
+		})
+	}
+	if err = group.Wait(); err != nil {
+		return nil, err
+	}
+
+	for i, stats := range results {
+		if err = total.Merge(stats); err != nil {
+			return nil, errors.Wrapf(err, "Stats worker %v returned bad results", i)
+		}
+	}
+	return total, nil
+}
@@ -0,0 +1,119 @@
+package sort
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCpuStats(t *testing.T) {
+	in := make([]byte, 4*4)
+	for i, v := range []uint32{0x0102, 0x0103, 0x0203, 0x0103} {
+		binary.LittleEndian.PutUint32(in[i*4:], v)
+	}
+
+	stats, err := CpuStatsFormat(in, []Digit{{0, 8}, {8, 4}, {16, 16}}, Uint32Format)
+	require.Nil(t, err, "Stats failed")
+	require.Equal(t, (int64)(4), stats.Count)
+	require.Equal(t, (uint64)(0x0102), stats.Min)
+	require.Equal(t, (uint64)(0x0203), stats.Max)
+
+	counts := stats.Counts
+	require.Equal(t, 3, len(counts))
+	require.Equal(t, (int64)(1), counts[0][2])
+	require.Equal(t, (int64)(3), counts[0][3])
+	require.Equal(t, (int64)(3), counts[1][1])
+	require.Equal(t, (int64)(1), counts[1][2])
+	require.Equal(t, (int64)(4), counts[2][0])
+	require.Equal(t, 1<<16, len(counts[2]))
+
+	_, err = CpuStatsFormat(in, []Digit{{28, 8}}, Uint32Format)
+	require.NotNil(t, err, "Accepted a digit past the end of the key")
+}
+
+func TestLocalStatsWorker(t *testing.T) {
+	t.Run("Mem", func(t *testing.T) {
+		StatsWorkerTest(t, data.MemArrayFactory, LocalStatsWorker)
+	})
+
+	t.Run("File", func(t *testing.T) {
+		tmpDir, err := ioutil.TempDir("", "radixSortStatsTest")
+		require.Nilf(t, err, "Couldn't create temporary test directory")
+		defer os.RemoveAll(tmpDir)
+
+		StatsWorkerTest(t, data.NewFileArrayFactory(tmpDir), LocalStatsWorker)
+	})
+}
+
+func TestArrayStatsRecords(t *testing.T) {
+	format := ElemFormat{KeySize: 8, RecordSize: 16, KeyOffset: 8}
+	recRaw, err := GenerateRecords(513, format)
+	require.Nil(t, err, "Failed to generate inputs")
+
+	arr, err := data.MemArrayFactory.Create("testStatsRecords", data.CreateShapeUniform((int64)(len(recRaw)), 1))
+	require.Nil(t, err, "Failed to create input")
+	defer arr.Destroy()
+	writer, err := arr.GetPartWriter(0)
+	require.Nil(t, err, "Failed to get writer")
+	_, err = writer.Write(recRaw)
+	require.Nil(t, err, "Failed to write input")
+	writer.Close()
+
+	opts := DefaultSortOptions()
+	opts.KeySize = format.KeySize
+	opts.RecordSize = format.RecordSize
+	opts.KeyOffset = format.KeyOffset
+	digits := []Digit{{Offset: 56, Width: 8}}
+	stats, err := ArrayStatsFromArr(context.Background(), arr, len(recRaw), digits, LocalStatsWorker, opts)
+	require.Nil(t, err, "Failed to compute stats")
+
+	expected, err := CpuStatsFormat(recRaw, digits, format)
+	require.Nil(t, err, "Failed to compute expected stats")
+	require.Equal(t, expected, stats)
+	require.Equal(t, (int64)(513), stats.Count)
+}
+
+func TestArrayStatsEmpty(t *testing.T) {
+	arr, err := data.MemArrayFactory.Create("testStatsEmpty", data.CreateShapeUniform(0, 2))
+	require.Nil(t, err, "Failed to create input")
+	defer arr.Destroy()
+
+	stats, err := ArrayStatsFromArr(context.Background(), arr, 0, []Digit{{Offset: 0, Width: 4}}, LocalStatsWorker, nil)
+	require.Nil(t, err, "Failed to compute stats")
+	require.Zero(t, stats.Count)
+	require.Equal(t, make([]int64, 16), stats.Counts[0])
+
+	_, err = ArrayStatsFromArr(context.Background(), arr, 0, []Digit{{Offset: 30, Width: 4}}, LocalStatsWorker, nil)
+	require.NotNil(t, err, "Accepted a digit past the end of the key")
+}
+
+func TestArrayStatsNoResults(t *testing.T) {
+	raw, err := GenerateInputs(16)
+	require.Nil(t, err, "Failed to generate inputs")
+	arr := createVerifyArr(t, "testStatsNoResults", raw)
+	defer arr.Destroy()
+
+	nilWorker := func(ctx context.Context, inBkts []*data.PartRef, digits []Digit, format ElemFormat) (*ArrayStats, error) {
+		return nil, nil
+	}
+	_, err = ArrayStatsFromArr(context.Background(), arr, len(raw), []Digit{{Offset: 0, Width: 4}}, nilWorker, nil)
+	require.NotNil(t, err, "Accepted a worker without results")
+}
+
+func TestArrayStatsMerge(t *testing.T) {
+	a := &ArrayStats{Count: 2, Min: 5, Max: 9, Digits: []Digit{{0, 1}}, Counts: [][]int64{{1, 1}}}
+	b := &ArrayStats{Count: 1, Min: 3, Max: 3, Digits: []Digit{{0, 1}}, Counts: [][]int64{{0, 1}}}
+	require.Nil(t, a.Merge(b))
+	require.Equal(t, &ArrayStats{Count: 3, Min: 3, Max: 9, Digits: []Digit{{0, 1}}, Counts: [][]int64{{1, 2}}}, a)
+
+	// Empty stats don't affect min/max
+	require.Nil(t, a.Merge(&ArrayStats{Digits: []Digit{{0, 1}}, Counts: [][]int64{{0, 0}}}))
+	require.Equal(t, (uint64)(3), a.Min)
+
+	require.NotNil(t, a.Merge(&ArrayStats{Digits: []Digit{{1, 1}}, Counts: [][]int64{{0, 0}}}), "Merged different digits")
+}
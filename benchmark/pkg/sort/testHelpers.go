@@ -2,7 +2,7 @@ package sort
 
 import (
 	"bytes"
-	"encoding/binary"
+	"context"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -14,67 +14,81 @@ import (
 	"github.com/stretchr/testify/require"
 )
 
+// Generate nElem random elements in format (keys and payloads are both random)
+func GenerateRecords(nElem int, format ElemFormat) ([]byte, error) {
+	sz := nElem * format.ElemSize()
+	raw, err := GenerateInputs((uint64)((sz + 3) / 4))
+	if err != nil {
+		return nil, err
+	}
+	return raw[:sz], nil
+}
+
 func CheckSort(orig []byte, new []byte) error {
-	var err error
+	return CheckSortFormat(orig, new, Uint32Format)
+}
 
+// Like CheckSort but for any element format
+func CheckSortFormat(orig []byte, new []byte, format ElemFormat) error {
 	if len(orig) != len(new) {
 		return fmt.Errorf("Lengths do not match: Expected %v, Got %v\n", len(orig), len(new))
 	}
 
-	intOrig := make([]uint32, len(orig)/4)
-	intNew := make([]uint32, len(new)/4)
+	elemSz := format.ElemSize()
+	if len(orig)%elemSz != 0 {
+		return fmt.Errorf("Length (%v) is not a multiple of the element size (%v)", len(orig), elemSz)
+	}
 
-	err = binary.Read(bytes.NewReader(orig), binary.LittleEndian, intOrig)
-	if err != nil {
-		return errors.Wrap(err, "Couldn't interpret orig")
+	// Full match against orig
+	ref := make([]byte, len(orig))
+	copy(ref, orig)
+	if err := CpuFullFormat(ref, format); err != nil {
+		return errors.Wrap(err, "Couldn't sort reference")
 	}
 
-	err = binary.Read(bytes.NewReader(new), binary.LittleEndian, intNew)
-	if err != nil {
-		return errors.Wrap(err, "Couldn't interpret new")
+	for i := 0; i < len(ref)/elemSz; i++ {
+		refKey := format.Key(ref[i*elemSz:])
+		newKey := format.Key(new[i*elemSz:])
+		if refKey != newKey {
+			return fmt.Errorf("Response doesn't match reference at %v\n: Expected %v, Got %v\n", i, refKey, newKey)
+		}
 	}
 
-	// Set membership test
-	// intOrigCpy := make([]uint32, len(intOrig))
-	// intNewCpy := make([]uint32, len(intNew))
-	// copy(intOrigCpy, intOrig)
-	// copy(intNewCpy, intNew)
-	// sort.Slice(intOrigCpy, func(i, j int) bool { return intOrigCpy[i] < intOrigCpy[j] })
-	// sort.Slice(intNewCpy, func(i, j int) bool { return intNewCpy[i] < intNewCpy[j] })
-	// for i := 0; i < len(intOrigCpy); i++ {
-	// 	if intOrigCpy[i] != intNewCpy[i] {
-	// 		fmt.Printf("Response doesn't have same elements as ref at %v\n: Expected %v, Got %v\n", i, intOrigCpy[i], intNew[i])
-	// 		// return fmt.Errorf("Response doesn't have same elements as ref at %v\n: Expected %v, Got %v\n", i, intOrigCpy[i], intNew[i])
-	// 	}
-	// }
-
-	// In order test
-	// prev := (uint32)(0)
-	// nerr := 0
-	// for i := 0; i < len(intNew); i++ {
-	// 	// fmt.Printf("%v: 0x%08x\n", i, intNew[i])
-	// 	if intNew[i] < prev {
-	// 		// fmt.Printf("Out of order at index %v:\t%x < %x\n", i, intNew[i], prev)
-	// 		nerr += 1
-	// 		return fmt.Errorf("Out of order at index %v: 0x%08x < 0x%08x", i, intNew[i], prev)
-	// 	}
-	// 	prev = intNew[i]
-	// }
-	// fmt.Printf("Nerror: %v\n", nerr)
+	if format.HasPayload() {
+		return checkSameRecords(orig, new, elemSz)
+	}
+	return nil
+}
 
-	// Full match against orig
-	intOrigCpy := make([]uint32, len(intOrig))
-	copy(intOrigCpy, intOrig)
-	sort.Slice(intOrigCpy, func(i, j int) bool { return intOrigCpy[i] < intOrigCpy[j] })
-	for i := 0; i < len(intOrigCpy); i++ {
-		if intOrigCpy[i] != intNew[i] {
-			return fmt.Errorf("Response doesn't match reference at %v\n: Expected %v, Got %v\n", i, intOrigCpy[i], intNew[i])
+// Make sure a and b contain the same records (in any order). Catches
+// payloads that got separated from their keys.
+func checkSameRecords(a []byte, b []byte, recordSz int) error {
+	nRecord := len(a) / recordSz
+	records := func(raw []byte) []string {
+		out := make([]string, nRecord)
+		for i := range out {
+			out[i] = string(raw[i*recordSz : (i+1)*recordSz])
+		}
+		sort.Strings(out)
+		return out
+	}
+
+	aRecords := records(a)
+	bRecords := records(b)
+	for i := range aRecords {
+		if aRecords[i] != bRecords[i] {
+			return fmt.Errorf("Response contains different records than the input")
 		}
 	}
 	return nil
 }
 
 func CheckPartialArray(arr data.DistribArray, offset, width int) error {
+	return CheckPartialArrayFormat(arr, offset, width, Uint32Format)
+}
+
+// Like CheckPartialArray but for any element format
+func CheckPartialArrayFormat(arr data.DistribArray, offset, width int, format ElemFormat) error {
 	reader, err := NewBucketReader([]data.DistribArray{arr}, INORDER)
 	if err != nil {
 		return errors.Wrap(err, "Failed to get reader for output")
@@ -84,12 +98,12 @@ func CheckPartialArray(arr data.DistribArray, offset, width int) error {
 	if err != nil {
 		return errors.Wrap(err, "couldn't read input")
 	}
-	testInts := make([]uint32, len(testRaw)/4)
 
-	err = binary.Read(bytes.NewReader(testRaw), binary.LittleEndian, testInts)
-	if err != nil {
-		return errors.Wrap(err, "Couldn't interpret output")
+	elemSz := format.ElemSize()
+	if len(testRaw)%elemSz != 0 {
+		return fmt.Errorf("Output size (%v) is not a multiple of the element size (%v)", len(testRaw), elemSz)
 	}
+	nElem := len(testRaw) / elemSz
 
 	shape, err := arr.GetShape()
 	if err != nil {
@@ -97,24 +111,22 @@ func CheckPartialArray(arr data.DistribArray, offset, width int) error {
 	}
 	boundaries := make([]uint64, shape.NPart()+1)
 
-	sum := (uint64)(len(testInts))
+	sum := (uint64)(nElem)
 	boundaries[shape.NPart()] = sum
 	for i := shape.NPart() - 1; i > 0; i-- {
-		sum -= (uint64)(shape.Len(i) / 4)
+		sum -= (uint64)(shape.Len(i) / (int64)(elemSz))
 		boundaries[i] = sum
 	}
 
 	curGroup := 0
-	for i := 0; i < len(testInts); i++ {
+	for i := 0; i < nElem; i++ {
 		for (uint64)(i) == boundaries[curGroup+1] {
 			curGroup++
 		}
-		group := GroupBits(testInts[i], offset, width)
+		group := KeyGroupBits(format.Key(testRaw[i*elemSz:]), offset, width)
 		if group != curGroup {
 			return fmt.Errorf("Element %v in wrong group: expected %v, got %v", i, curGroup, group)
-			// fmt.Printf("(%v:%v) Element %v (0x%x) in wrong group: expected %x, got %x\n", offset, width, i, testInts[i], curGroup, group)
 		}
-
 	}
 
 	return nil
@@ -352,7 +364,7 @@ func DistribWorkerTest(t *testing.T, factory *data.ArrayFactory, worker DistribW
 
 	origArr.Close()
 
-	outArr, err := worker(PartRefs, 0, width, "testDistribWorker", factory)
+	outArr, err := worker(context.Background(), PartRefs, 0, width, Uint32Format, "testDistribWorker", factory)
 	require.Nil(t, err)
 
 	outShape, err := outArr.GetShape()
@@ -385,52 +397,67 @@ func DistribWorkerTest(t *testing.T, factory *data.ArrayFactory, worker DistribW
 	require.Equal(t, nByte, totalLen, "Output buckets have the wrong number of elements")
 
 	checkPartial(t, outRaw, boundaries, origRaw)
+
+	outArr.Destroy()
+	origArr.Destroy()
 }
 
 func SortDistribTest(t *testing.T, baseName string, factory *data.ArrayFactory, worker DistribWorker) {
+	SortDistribOptsTest(t, baseName, factory, worker, nil)
+}
+
+// Like SortDistribTest but with explicit sort options
+func SortDistribOptsTest(t *testing.T, baseName string, factory *data.ArrayFactory, worker DistribWorker, opts *SortOptions) {
 	var err error
 
 	err = InitLibSort()
 	require.Nil(t, err, "Failed to initialize libsort")
 
+	if opts == nil {
+		opts = DefaultSortOptions()
+	}
+	format := opts.Format()
+
 	// Should be an odd (in both senses) number to pick up unaligned corner
 	// cases
 	nElem := 1111
 	// nElem := (1024 * 1024) + 5
-	origRaw, err := GenerateInputs((uint64)(nElem))
+	origRaw, err := GenerateRecords(nElem, format)
 	require.Nil(t, err, "Failed to generate test inputs")
 
-	outRaw, err := SortDistribFromRaw(origRaw, baseName, factory, worker)
+	outRaw, err := SortDistribFromRaw(context.Background(), origRaw, baseName, factory, worker, opts)
 	require.Nil(t, err, "Sort Error")
 
-	err = CheckSort(origRaw, outRaw)
+	err = CheckSortFormat(origRaw, outRaw, format)
 	require.Nilf(t, err, "Did not sort correctly: %v", err)
 }
 
 // Make sure the partial sort worked and set the boundaries correctly
 func checkPartial(t *testing.T, testBytes []byte, boundaries []int64, origBytes []byte) {
-	var err error
+	checkPartialFormat(t, testBytes, boundaries, origBytes, Uint32Format)
+}
 
+// Like checkPartial but for any element format
+func checkPartialFormat(t *testing.T, testBytes []byte, boundaries []int64, origBytes []byte, format ElemFormat) {
 	require.Equal(t, len(origBytes), len(testBytes), "Test array has the wrong length")
 
-	test := make([]uint32, len(testBytes)/4)
-	orig := make([]uint32, len(origBytes)/4)
-
-	intLen := len(test)
+	elemSz := format.ElemSize()
+	nElem := len(testBytes) / elemSz
 
-	err = binary.Read(bytes.NewReader(origBytes), binary.LittleEndian, orig)
-	require.Nil(t, err, "Couldn't interpret orig")
-
-	err = binary.Read(bytes.NewReader(testBytes), binary.LittleEndian, test)
-	require.Nil(t, err, "Couldn't interpret test")
+	test := make([]uint64, nElem)
+	orig := make([]uint64, nElem)
+	for i := 0; i < nElem; i++ {
+		test[i] = format.Key(testBytes[i*elemSz:])
+		orig[i] = format.Key(origBytes[i*elemSz:])
+	}
 
 	// len(boundaries) is 2^radixWidth, -1 gives us ones for the first width bits
-	mask := (uint32)(len(boundaries) - 1)
+	mask := (uint64)(len(boundaries) - 1)
 
 	boundaries = append(boundaries, (int64)(len(testBytes)))
-	curBucket := (uint32)(0)
-	for i := 0; i < intLen; i++ {
-		for i == (int)(boundaries[curBucket+1])/4 {
+	curBucket := (uint64)(0)
+	for i := 0; i < nElem; i++ {
+		for i == (int)(boundaries[curBucket+1])/elemSz {
 			curBucket++
 		}
 
@@ -442,7 +469,67 @@ func checkPartial(t *testing.T, testBytes []byte, boundaries []int64, origBytes
 	// to compare set membership.
 	sort.Slice(orig, func(i, j int) bool { return orig[i] < orig[j] })
 	sort.Slice(test, func(i, j int) bool { return test[i] < test[j] })
-	for i := 0; i < intLen; i++ {
+	for i := 0; i < nElem; i++ {
 		require.Equalf(t, orig[i], test[i], "output does not contain all the same values as the input at index %v", i)
 	}
 }
+
+// Check that worker computes the right statistics for an array in factory
+// (see ArrayStatsFromArr)
+func StatsWorkerTest(t *testing.T, factory *data.ArrayFactory, worker StatsWorker) {
+	nElem := 1021
+	npart := 3
+	origRaw, err := GenerateInputs((uint64)(nElem))
+	require.Nil(t, err, "Failed to generate test inputs")
+
+	// Uneven partitions so that workers straddle them
+	caps := []int64{100 * 4, (int64)(nElem-300) * 4, 200 * 4}
+	arr, err := factory.Create("testStatsInput", data.CreateShape(caps))
+	require.Nil(t, err, "Failed to create input")
+	defer arr.Destroy()
+
+	start := 0
+	for i := 0; i < npart; i++ {
+		writer, err := arr.GetPartWriter(i)
+		require.Nil(t, err, "Failed to get writer")
+		_, err = writer.Write(origRaw[start : start+(int)(caps[i])])
+		require.Nil(t, err, "Failed to write input")
+		writer.Close()
+		start += (int)(caps[i])
+	}
+	require.Nil(t, arr.Close(), "Failed to commit input")
+
+	digits := []Digit{{Offset: 0, Width: 8}, {Offset: 28, Width: 4}}
+	opts := DefaultSortOptions()
+	opts.NWorker = 4
+	stats, err := ArrayStatsFromArr(context.Background(), arr, len(origRaw), digits, worker, opts)
+	require.Nil(t, err, "Failed to compute stats")
+
+	require.Equal(t, (int64)(nElem), stats.Count, "Wrong count")
+	require.Equal(t, digits, stats.Digits, "Wrong digits")
+
+	min, max := Uint32Format.Key(origRaw), Uint32Format.Key(origRaw)
+	low := make([]int64, 1<<8)
+	high := make([]int64, 1<<4)
+	for i := 0; i < len(origRaw); i += 4 {
+		key := Uint32Format.Key(origRaw[i:])
+		if key < min {
+			min = key
+		}
+		if key > max {
+			max = key
+		}
+		low[KeyGroupBits(key, 0, 8)]++
+		high[KeyGroupBits(key, 28, 4)]++
+	}
+	require.Equal(t, min, stats.Min, "Wrong min")
+	require.Equal(t, max, stats.Max, "Wrong max")
+	require.Equal(t, [][]int64{low, high}, stats.Counts, "Wrong histograms")
+
+	// The input must be left alone
+	refs, err := arrayRefs(arr, len(origRaw), Uint32Format)
+	require.Nil(t, err, "Input shape changed")
+	outRaw, err := data.FetchPartRefs(refs)
+	require.Nil(t, err, "Couldn't read input after stats")
+	require.Equal(t, origRaw, outRaw, "Stats modified the input")
+}
@@ -0,0 +1,203 @@
+package sort
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/pkg/errors"
+)
+
+// Keeps track of every array created during a sort so that they can all be
+// destroyed if the sort fails. Arrays are identified by the name they were
+// created with in factory.
+type arrayTracker struct {
+	factory *data.ArrayFactory
+
+	lock sync.Mutex
+	// A nil entry means the array may have been created outside this process
+	// (e.g. by a FaaS worker) and needs to be opened before destroying.
+	arrs map[string]data.DistribArray
+
+	// Attempts that lost to a faster duplicate but may still be reading their
+	// inputs. Inputs destroyed while any are running are put off until the
+	// last one finishes.
+	stragglers sync.WaitGroup
+	nStraggler int
+	deferred   []trackedArray
+	deferErr   error
+}
+
+type trackedArray struct {
+	name string
+	arr  data.DistribArray
+}
+
+func newArrayTracker(factory *data.ArrayFactory) *arrayTracker {
+	return &arrayTracker{factory: factory, arrs: make(map[string]data.DistribArray)}
+}
+
+// Returns a factory that creates arrays with the tracker's factory and records
+// them
+func (self *arrayTracker) trackingFactory() *data.ArrayFactory {
+	return &data.ArrayFactory{
+		Create: func(name string, shape data.DistribArrayShape) (data.DistribArray, error) {
+			arr, err := self.factory.Create(name, shape)
+			if err == nil {
+				self.add(name, arr)
+			}
+			return arr, err
+		},
+		Open:         self.factory.Open,
+		OpenReadOnly: self.factory.OpenReadOnly,
+	}
+}
+
+// Record an array. arr may be nil if the array might exist but we don't have
+// a handle to it.
+func (self *arrayTracker) add(name string, arr data.DistribArray) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	if arr == nil {
+		if _, ok := self.arrs[name]; ok {
+			return
+		}
+	}
+	self.arrs[name] = arr
+}
+
+// Stop tracking name (e.g. because it was already destroyed)
+func (self *arrayTracker) forget(name string) {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	delete(self.arrs, name)
+}
+
+// Destroy a single tracked array (if it exists) and stop tracking it
+func (self *arrayTracker) destroy(name string) error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	return self.destroyLocked(name)
+}
+
+// Destroy an array that workers read from, once no straggling attempt can
+// still be reading it. name may be empty if the array isn't tracked (e.g. it
+// belongs to the caller).
+func (self *arrayTracker) destroyInput(name string, arr data.DistribArray) error {
+	self.lock.Lock()
+	if self.nStraggler > 0 {
+		self.deferred = append(self.deferred, trackedArray{name: name, arr: arr})
+		self.lock.Unlock()
+		return nil
+	}
+	self.lock.Unlock()
+
+	if err := arr.Destroy(); err != nil {
+		return err
+	}
+	if name != "" {
+		self.forget(name)
+	}
+	return nil
+}
+
+// Record an attempt that is still running after its task finished. Must be
+// called before the task's inputs can be destroyed.
+func (self *arrayTracker) addStraggler() {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	self.nStraggler++
+	self.stragglers.Add(1)
+}
+
+// A straggler finished. The last one destroys any inputs that were put off.
+func (self *arrayTracker) doneStraggler() {
+	defer self.stragglers.Done()
+
+	self.lock.Lock()
+	self.nStraggler--
+	var deferred []trackedArray
+	if self.nStraggler == 0 {
+		deferred = self.deferred
+		self.deferred = nil
+	}
+	self.lock.Unlock()
+
+	for _, input := range deferred {
+		if err := input.arr.Destroy(); err != nil {
+			self.lock.Lock()
+			self.deferErr = errors.Wrapf(err, "Failed to destroy %v", input.name)
+			self.lock.Unlock()
+		} else if input.name != "" {
+			self.forget(input.name)
+		}
+	}
+}
+
+// Wait for every straggler to finish (and destroy the inputs they held up).
+// Returns the last error from destroying those inputs.
+func (self *arrayTracker) wait() error {
+	self.stragglers.Wait()
+
+	self.lock.Lock()
+	defer self.lock.Unlock()
+	err := self.deferErr
+	self.deferErr = nil
+	return err
+}
+
+// Names of all tracked arrays (in sorted order)
+func (self *arrayTracker) names() []string {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	names := make([]string, 0, len(self.arrs))
+	for name := range self.arrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Destroy every tracked array. Arrays that were never actually created are
+// ignored. Returns the last error encountered, but always tries to destroy
+// everything.
+func (self *arrayTracker) destroyAll() error {
+	self.lock.Lock()
+	defer self.lock.Unlock()
+
+	var destroyErr error
+	for name := range self.arrs {
+		if err := self.destroyLocked(name); err != nil {
+			destroyErr = err
+		}
+	}
+	return destroyErr
+}
+
+// Caller must hold self.lock
+func (self *arrayTracker) destroyLocked(name string) error {
+	arr, ok := self.arrs[name]
+	if !ok {
+		return nil
+	}
+
+	if arr == nil {
+		var err error
+		if arr, err = self.factory.Open(name); err != nil {
+			// Probably never created
+			delete(self.arrs, name)
+			return nil
+		}
+	}
+
+	if err := arr.Destroy(); err != nil {
+		return errors.Wrapf(err, "Failed to destroy %v", name)
+	}
+	delete(self.arrs, name)
+	return nil
+}
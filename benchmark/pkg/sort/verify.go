@@ -0,0 +1,294 @@
+package sort
+
+// Streaming verification of distributed sorts. CheckSort needs the whole
+// input and output in memory, these helpers read arrays a buffer at a time so
+// they work on benchmark-sized sorts. Workers summarize each partition (first
+// and last key plus a checksum) and the driver checks that the summaries line
+// up, the contents are compared with an order-independent checksum of the
+// input and output.
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/pkg/errors"
+	"golang.org/x/sync/errgroup"
+)
+
+// Default read buffer for each array being verified
+const verifyBufferSize = 1024 * 1024
+
+// Order-independent summary of a multiset of elements. Two sets of elements
+// with the same Checksum are almost certainly permutations of each other.
+type Checksum struct {
+	Count int64
+
+	// Sum (mod 2^64) and xor of the hashes of every element
+	Sum uint64
+	Xor uint64
+}
+
+// Add the elements summarized by other
+func (self *Checksum) Add(other *Checksum) {
+	self.Count += other.Count
+	self.Sum += other.Sum
+	self.Xor ^= other.Xor
+}
+
+// Add a single element (all of its bytes, not just the key)
+func (self *Checksum) addElem(elem []byte) {
+	// FNV-1a followed by the splitmix64 finalizer, FNV alone mixes the last
+	// few bytes poorly
+	h := (uint64)(14695981039346656037)
+	for _, b := range elem {
+		h ^= (uint64)(b)
+		h *= 1099511628211
+	}
+	h ^= h >> 30
+	h *= 0xbf58476d1ce4e5b9
+	h ^= h >> 27
+	h *= 0x94d049bb133111eb
+	h ^= h >> 31
+
+	self.Count++
+	self.Sum += h
+	self.Xor ^= h
+}
+
+// Summary of a single partition (see scanArray)
+type partSummary struct {
+	sum Checksum
+
+	// Keys of the first and last element, only valid if sum.Count > 0
+	first uint64
+	last  uint64
+}
+
+// Read every partition of arr using a bufSz buffer and summarize it.
+// Partitions must hold whole elements. If checkOrder is set, the elements of
+// each partition must be sorted (ascending unsigned keys).
+func scanArray(ctx context.Context, arr data.DistribArray, opts *SortOptions, bufSz int, checkOrder bool) ([]*partSummary, error) {
+	format := opts.Format()
+	elemSz := format.ElemSize()
+	if bufSz < elemSz {
+		bufSz = elemSz
+	}
+	buf := make([]byte, bufSz-(bufSz%elemSz))
+
+	shape, err := arr.GetShape()
+	if err != nil {
+		return nil, errors.Wrap(err, "Couldn't get shape")
+	}
+
+	summaries := make([]*partSummary, shape.NPart())
+	for partIdx := range summaries {
+		summaries[partIdx] = &partSummary{}
+		if shape.Len(partIdx)%(int64)(elemSz) != 0 {
+			return nil, fmt.Errorf("Partition %v has length %v, not a multiple of the element size (%v)",
+				partIdx, shape.Len(partIdx), elemSz)
+		}
+		if shape.Len(partIdx) == 0 {
+			continue
+		}
+
+		reader, err := arr.GetPartReader(partIdx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "Couldn't read partition %v", partIdx)
+		}
+		err = scanPart(ctx, reader, buf, summaries[partIdx], format, checkOrder)
+		reader.Close()
+		if err != nil {
+			return nil, errors.Wrapf(err, "Partition %v", partIdx)
+		}
+	}
+	return summaries, nil
+}
+
+// Summarize the elements in reader (see scanArray) into summary using buf
+// (which must hold a whole number of elements)
+func scanPart(ctx context.Context, reader io.Reader, buf []byte, summary *partSummary,
+	format ElemFormat, checkOrder bool) error {
+
+	elemSz := format.ElemSize()
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		n, err := io.ReadFull(reader, buf)
+		if err == io.EOF {
+			return nil
+		} else if err != nil && err != io.ErrUnexpectedEOF {
+			return errors.Wrap(err, "Read failed")
+		}
+		if n%elemSz != 0 {
+			return fmt.Errorf("Partition ends with a partial element (%v bytes)", n%elemSz)
+		}
+
+		for i := 0; i < n; i += elemSz {
+			elem := buf[i : i+elemSz]
+			key := format.Key(elem)
+			if summary.sum.Count == 0 {
+				summary.first = key
+			} else if checkOrder && key < summary.last {
+				return fmt.Errorf("Element %v is out of order: key %#x follows %#x",
+					summary.sum.Count, key, summary.last)
+			}
+			summary.last = key
+			summary.sum.addElem(elem)
+		}
+
+		if err == io.ErrUnexpectedEOF {
+			return nil
+		}
+	}
+}
+
+// Scan arrs with at most nParallel arrays at a time (see scanArray)
+func scanArrays(ctx context.Context, arrs []data.DistribArray, opts *SortOptions, nParallel int, checkOrder bool) ([][]*partSummary, error) {
+	if nParallel < 1 {
+		nParallel = 1
+	}
+
+	summaries := make([][]*partSummary, len(arrs))
+	slots := make(chan struct{}, nParallel)
+	group, groupCtx := errgroup.WithContext(ctx)
+	for i := range arrs {
+		id := i
+		group.Go(func() error {
+			select {
+			case slots <- struct{}{}:
+			case <-groupCtx.Done():
+				return groupCtx.Err()
+			}
+			defer func() { <-slots }()
+
+			var err error
+			summaries[id], err = scanArray(groupCtx, arrs[id], opts, verifyBufferSize, checkOrder)
+			if err != nil {
+				return errors.Wrapf(err, "Array %v", id)
+			}
+			return nil
+		})
+	}
+	if err := group.Wait(); err != nil {
+		return nil, err
+	}
+	return summaries, nil
+}
+
+// Compute the Checksum of every element in arrs (laid out as described by
+// opts, nil means DefaultSortOptions()). Up to nParallel arrays are read at
+// once, each with its own fixed-size buffer.
+func ChecksumArrays(ctx context.Context, arrs []data.DistribArray, opts *SortOptions, nParallel int) (*Checksum, error) {
+	if opts == nil {
+		opts = DefaultSortOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Invalid sort options")
+	}
+
+	summaries, err := scanArrays(ctx, arrs, opts, nParallel, false)
+	if err != nil {
+		return nil, err
+	}
+
+	total := &Checksum{}
+	for _, parts := range summaries {
+		for _, part := range parts {
+			total.Add(&part.sum)
+		}
+	}
+	return total, nil
+}
+
+// Check that arrs (e.g. the output of SortDistribFromArr) is in sorted order
+// when read in opts.ReadOrder (the order the sort writes them in), across
+// partition and array boundaries. The element layout also comes from opts (nil
+// means DefaultSortOptions()). Like SortDistribFromArr, keys are compared as
+// unsigned integers: with KeyEncoding or Descending set the arrays must hold
+// encoded keys (as SortDistribFromArr outputs do). Up to nParallel arrays are
+// checked at once. Returns the Checksum of the elements.
+func VerifySorted(ctx context.Context, arrs []data.DistribArray, opts *SortOptions, nParallel int) (*Checksum, error) {
+	if opts == nil {
+		opts = DefaultSortOptions()
+	}
+	if err := opts.Validate(); err != nil {
+		return nil, errors.Wrap(err, "Invalid sort options")
+	}
+
+	summaries, err := scanArrays(ctx, arrs, opts, nParallel, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// Partitions are visited in the order a BucketReader would return them
+	nPart := 0
+	for _, parts := range summaries {
+		if len(parts) > nPart {
+			nPart = len(parts)
+		}
+	}
+	var order [][2]int
+	if opts.ReadOrder == INORDER {
+		for arrIdx, parts := range summaries {
+			for partIdx := range parts {
+				order = append(order, [2]int{arrIdx, partIdx})
+			}
+		}
+	} else {
+		for partIdx := 0; partIdx < nPart; partIdx++ {
+			for arrIdx, parts := range summaries {
+				if partIdx < len(parts) {
+					order = append(order, [2]int{arrIdx, partIdx})
+				}
+			}
+		}
+	}
+
+	total := &Checksum{}
+	var prev *partSummary
+	var prevLoc [2]int
+	for _, loc := range order {
+		part := summaries[loc[0]][loc[1]]
+		if part.sum.Count == 0 {
+			continue
+		}
+		if prev != nil && part.first < prev.last {
+			return nil, fmt.Errorf("Array %v partition %v starts before the end of array %v partition %v",
+				loc[0], loc[1], prevLoc[0], prevLoc[1])
+		}
+		prev = part
+		prevLoc = loc
+		total.Add(&part.sum)
+	}
+	return total, nil
+}
+
+// Check that outputs holds exactly the elements of inputs in sorted order
+// (see VerifySorted, inputs must use the same key encoding). Memory use is
+// bounded by nParallel read buffers regardless of the array sizes.
+func VerifySort(ctx context.Context, inputs []data.DistribArray, outputs []data.DistribArray,
+	opts *SortOptions, nParallel int) error {
+
+	inSum, err := ChecksumArrays(ctx, inputs, opts, nParallel)
+	if err != nil {
+		return errors.Wrap(err, "Failed to read inputs")
+	}
+
+	outSum, err := VerifySorted(ctx, outputs, opts, nParallel)
+	if err != nil {
+		return errors.Wrap(err, "Output not sorted")
+	}
+
+	if inSum.Count != outSum.Count {
+		return fmt.Errorf("Output has %v elements, input has %v", outSum.Count, inSum.Count)
+	}
+	if *inSum != *outSum {
+		return fmt.Errorf("Output is not a permutation of the input (checksum %x/%x, expected %x/%x)",
+			outSum.Sum, outSum.Xor, inSum.Sum, inSum.Xor)
+	}
+	return nil
+}
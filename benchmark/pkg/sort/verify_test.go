@@ -0,0 +1,196 @@
+package sort
+
+import (
+	"context"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/nathantp/gpu-radix-sort/benchmark/pkg/data"
+	"github.com/stretchr/testify/require"
+)
+
+// Create a memory array with one partition per element of parts
+func createVerifyArr(t *testing.T, name string, parts ...[]byte) data.DistribArray {
+	caps := make([]int64, len(parts))
+	for i, part := range parts {
+		caps[i] = (int64)(len(part))
+	}
+
+	arr, err := data.MemArrayFactory.Create(name, data.CreateShape(caps))
+	require.Nil(t, err, "Failed to create array")
+	for i, part := range parts {
+		writer, err := arr.GetPartWriter(i)
+		require.Nil(t, err, "Failed to get writer")
+		_, err = writer.Write(part)
+		require.Nil(t, err, "Failed to write array")
+		writer.Close()
+	}
+	return arr
+}
+
+func uint32Bytes(keys ...uint32) []byte {
+	raw := make([]byte, 4*len(keys))
+	for i, key := range keys {
+		binary.LittleEndian.PutUint32(raw[i*4:], key)
+	}
+	return raw
+}
+
+func TestVerifySort(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "radixSortVerifyTest")
+	require.Nilf(t, err, "Couldn't create temporary test directory")
+	defer os.RemoveAll(tmpDir)
+	factory := data.NewFileArrayFactory(tmpDir)
+
+	origRaw, err := GenerateInputs(5000)
+	require.Nil(t, err, "Failed to generate inputs")
+	inArr, err := factory.Create("testVerifyInput", data.CreateShapeUniform((int64)(len(origRaw)), 1))
+	require.Nil(t, err, "Failed to create input")
+	writer, err := inArr.GetPartWriter(0)
+	require.Nil(t, err, "Failed to get writer")
+	_, err = writer.Write(origRaw)
+	require.Nil(t, err, "Failed to write input")
+	writer.Close()
+
+	opts := DefaultSortOptions()
+	opts.Cleanup = CleanupIntermediate
+	outArrs, err := SortDistribFromArr(context.Background(), inArr, len(origRaw), "testVerify", factory, LocalDistribWorker, opts)
+	require.Nil(t, err, "Sort failed")
+
+	for _, nParallel := range []int{1, 4} {
+		err = VerifySort(context.Background(), []data.DistribArray{inArr}, outArrs, opts, nParallel)
+		require.Nilf(t, err, "Correct sort rejected (%v parallel)", nParallel)
+	}
+
+	// The outputs are sorted but not in the right order
+	swapped := append([]data.DistribArray{}, outArrs...)
+	swapped[0], swapped[len(swapped)-1] = swapped[len(swapped)-1], swapped[0]
+	err = VerifySort(context.Background(), []data.DistribArray{inArr}, swapped, opts, 2)
+	require.NotNil(t, err, "Accepted misordered arrays")
+
+	// Missing an array
+	err = VerifySort(context.Background(), []data.DistribArray{inArr}, outArrs[1:], opts, 2)
+	require.NotNil(t, err, "Accepted a missing array")
+}
+
+func TestVerifySorted(t *testing.T) {
+	ctx := context.Background()
+
+	// Order is checked across partitions and arrays in read order, empty
+	// partitions are ignored
+	opts := DefaultSortOptions()
+	opts.ReadOrder = INORDER
+	opts.NWorker = 1
+	good := []data.DistribArray{
+		createVerifyArr(t, "a", uint32Bytes(1, 2), uint32Bytes(2, 5)),
+		createVerifyArr(t, "b", uint32Bytes()),
+		createVerifyArr(t, "c", uint32Bytes(5, 6), uint32Bytes(7, 9)),
+	}
+	sum, err := VerifySorted(ctx, good, opts, 2)
+	require.Nil(t, err, "Sorted arrays rejected")
+	require.Equal(t, (int64)(8), sum.Count)
+
+	_, err = VerifySorted(ctx, good, nil, 2)
+	require.NotNil(t, err, "Accepted arrays that are only sorted in order")
+
+	_, err = VerifySorted(ctx, []data.DistribArray{good[2], good[0]}, opts, 1)
+	require.NotNil(t, err, "Accepted unsorted arrays")
+
+	_, err = VerifySorted(ctx, []data.DistribArray{createVerifyArr(t, "d", uint32Bytes(1, 3, 2))}, nil, 1)
+	require.NotNil(t, err, "Accepted an unsorted partition")
+
+	// Radix outputs are read strided (bucket 0 of every array first)
+	strided := []data.DistribArray{
+		createVerifyArr(t, "s0", uint32Bytes(1, 2), uint32Bytes(7)),
+		createVerifyArr(t, "s1", uint32Bytes(3), uint32Bytes(8, 9)),
+	}
+	_, err = VerifySorted(ctx, strided, nil, 2)
+	require.Nil(t, err, "Strided arrays rejected")
+
+	// Keys are compared after encoding, as SortDistribFromArr sees them
+	opts = DefaultSortOptions()
+	opts.KeyEncoding = SignedKeys
+	opts.Descending = true
+	neg := (uint32)(0xffffffff) // -1
+	descending := uint32Bytes(7, 0, neg)
+	transformKeys(descending, opts, false)
+	_, err = VerifySorted(ctx, []data.DistribArray{createVerifyArr(t, "e", descending)}, opts, 1)
+	require.Nil(t, err, "Descending signed keys rejected")
+	ascending := uint32Bytes(neg, 0, 7)
+	transformKeys(ascending, opts, false)
+	_, err = VerifySorted(ctx, []data.DistribArray{createVerifyArr(t, "f", ascending)}, opts, 1)
+	require.NotNil(t, err, "Accepted ascending keys for a descending sort")
+}
+
+// Sorts of encoded keys are verified on the encoded outputs
+func TestVerifyEncoded(t *testing.T) {
+	origRaw, err := GenerateInputs(3000)
+	require.Nil(t, err, "Failed to generate inputs")
+
+	for name, enc := range map[string]KeyEncoding{"Signed": SignedKeys, "Float": FloatKeys} {
+		opts := DefaultSortOptions()
+		opts.KeyEncoding = enc
+		opts.Descending = enc == FloatKeys
+		opts.Cleanup = CleanupIntermediate
+
+		encRaw := make([]byte, len(origRaw))
+		copy(encRaw, origRaw)
+		transformKeys(encRaw, opts, false)
+		inArr := createVerifyArr(t, "testVerifyEncodedInput"+name, encRaw)
+
+		outArrs, err := SortDistribFromArr(context.Background(), inArr, len(encRaw), "testVerifyEncoded"+name,
+			data.MemArrayFactory, LocalDistribWorker, opts)
+		require.Nil(t, err, "Sort failed")
+
+		err = VerifySort(context.Background(), []data.DistribArray{inArr}, outArrs, opts, 2)
+		require.Nilf(t, err, "Correct sort rejected (%v keys)", name)
+	}
+}
+
+// The checksum must catch elements that are changed but still sorted
+func TestVerifyChecksum(t *testing.T) {
+	ctx := context.Background()
+	in := []data.DistribArray{createVerifyArr(t, "in", uint32Bytes(3, 1, 2, 2))}
+
+	require.Nil(t, VerifySort(ctx, in, []data.DistribArray{createVerifyArr(t, "ok", uint32Bytes(1, 2, 2, 3))}, nil, 1))
+	require.NotNil(t, VerifySort(ctx, in, []data.DistribArray{createVerifyArr(t, "dup", uint32Bytes(1, 2, 3, 3))}, nil, 1))
+	require.NotNil(t, VerifySort(ctx, in, []data.DistribArray{createVerifyArr(t, "short", uint32Bytes(1, 2, 3))}, nil, 1))
+}
+
+// Records straddle read buffers
+func TestVerifyRecords(t *testing.T) {
+	opts := DefaultSortOptions()
+	opts.RecordSize = 12
+	opts.KeyOffset = 4
+	format := opts.Format()
+
+	recRaw, err := GenerateRecords(100, format)
+	require.Nil(t, err, "Failed to generate inputs")
+	sorted := make([]byte, len(recRaw))
+	copy(sorted, recRaw)
+	require.Nil(t, (&goSorter{}).Full(sorted, format), "Failed to sort records")
+
+	in := createVerifyArr(t, "recIn", recRaw)
+	out := createVerifyArr(t, "recOut", sorted[:120], sorted[120:])
+
+	inParts, err := scanArray(context.Background(), in, opts, 20, false)
+	require.Nil(t, err, "Failed to scan input")
+	outParts, err := scanArray(context.Background(), out, opts, 20, true)
+	require.Nil(t, err, "Failed to scan output")
+	outSum := outParts[0].sum
+	outSum.Add(&outParts[1].sum)
+	require.Equal(t, inParts[0].sum, outSum, "Checksums differ")
+	require.Equal(t, (int64)(100), outSum.Count)
+
+	// Changing only the payload must change the checksum
+	sorted[len(sorted)-1] ^= 1
+	changed := createVerifyArr(t, "recChanged", sorted)
+	err = VerifySort(context.Background(), []data.DistribArray{in}, []data.DistribArray{changed}, opts, 1)
+	require.NotNil(t, err, "Payload change not detected")
+
+	partial := createVerifyArr(t, "recPartial", sorted[:20], sorted[20:24])
+	_, err = VerifySorted(context.Background(), []data.DistribArray{partial}, opts, 1)
+	require.NotNil(t, err, "Accepted a partial element")
+}
@@ -50,6 +50,24 @@ def f(event):
                 "err" : "Function currently only supports file distributed arrays"
                 }
 
+    if event.get('op', 'sort') != 'sort':
+        return {
+                "success" : False,
+                "err" : "Function currently only supports the 'sort' operation"
+                }
+
+    if event.get('keySize', 4) != 4:
+        return {
+                "success" : False,
+                "err" : "Function currently only supports 4-byte keys"
+                }
+
+    if event.get('recordSize', 0) not in (0, 4) or event.get('keyOffset', 0) != 0:
+        return {
+                "success" : False,
+                "err" : "Function currently only supports bare keys (no records)"
+                }
+
     refs = pylibsort.getPartRefs(event)
     rawBytes = pylibsort.readPartRefs(refs)
 
@@ -122,12 +122,20 @@ class fileDistribArray(DistribArray):
         self.datPath = self.rootPath / 'data.dat'
         self.metaPath = self.rootPath / 'meta.json'
         self.closed = False
+        self.readOnly = False
 
 
     def __commitMeta(self):
-        with open(self.metaPath, 'w') as metaF:
-            jsonShape = {"Lens" : self.shape.lens, "Caps" : self.shape.caps}
+        # Same crash-safe commit as the Go FileDistribArray: write a temporary
+        # file, sync it and rename it over the old metadata
+        tmpPath = self.metaPath.with_name(self.metaPath.name + '.tmp')
+        with open(tmpPath, 'w') as metaF:
+            jsonShape = {"Magic" : "radixsort-filearray", "Version" : 1,
+                         "Lens" : self.shape.lens, "Caps" : self.shape.caps}
             json.dump(jsonShape, metaF)
+            metaF.flush()
+            os.fsync(metaF.fileno())
+        os.replace(tmpPath, self.metaPath)
 
 
     @classmethod
@@ -147,24 +155,36 @@ class fileDistribArray(DistribArray):
         return arr
 
     @classmethod
-    def Open(cls, rootPath):
+    def Open(cls, rootPath, readOnly=False):
+        """Open an existing array. Read-only arrays are never modified (Close()
+        doesn't commit), use this for inputs."""
         arr = cls(rootPath)
+        arr.readOnly = readOnly
 
         if not arr.rootPath.exists():
             raise DistribArrayError("Array {} does not exist".format(rootPath))
 
         with open(arr.metaPath, 'r') as metaF:
             jsonShape = json.load(metaF)
+            if jsonShape.get('Extents'):
+                raise DistribArrayError("Array {} has unlimited partitions, which are not supported".format(rootPath))
+            if jsonShape.get('Layout'):
+                raise DistribArrayError("Array {} uses the '{}' layout, only data.dat arrays are supported".format(rootPath, jsonShape['Layout']))
             arr.shape = ArrayShape(lens = jsonShape['Lens'], caps = jsonShape['Caps'])
         
-        arr.dataF = open(arr.datPath, 'r+b')
+        arr.dataF = open(arr.datPath, 'rb' if readOnly else 'r+b')
 
         return arr
 
 
     def Close(self):
         # Being idempotent just makes things easier
-        if not self.closed:
+        if not self.closed and self.readOnly:
+            self.dataF.close()
+            self.closed = True
+        elif not self.closed:
+            self.dataF.flush()
+            os.fsync(self.dataF.fileno())
             self.dataF.close()
             self.__commitMeta()
             self.closed = True
@@ -193,6 +213,9 @@ class fileDistribArray(DistribArray):
 
 
     def WritePart(self, partId, buf):
+        if self.readOnly:
+            raise DistribArrayError("Array is read-only")
+
         if self.shape.lens[partId] + len(buf) > self.shape.caps[partId]:
             raise DistribArrayError("Wrote beyond end of partition (asked for {}b, limit {}b)".format(len(buf),
                 self.shape.caps[partId] - self.shape.lens[partId]))
@@ -250,7 +273,7 @@ def __fileGetRef(req) -> partRef:
     if req['arrayName'] in openArrs:
         arr = openArrs[req['arrayName']]
     else:
-        arr = fileDistribArray.Open(FileDistribArrayMount / req['arrayName'])
+        arr = fileDistribArray.Open(FileDistribArrayMount / req['arrayName'], readOnly=True)
         openArrs[req['arrayName']] = arr
 
     # Internally, it's easier to work with absolute numbers, so we convert -1